@@ -2,8 +2,11 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"sort"
 
 	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-proxy/provider"
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/log"
 	"github.com/tidwall/gjson"
 )
 
@@ -30,11 +33,52 @@ type PluginConfig struct {
 
 	activeProviderConfig *provider.ProviderConfig `yaml:"-"`
 	activeProvider       provider.Provider        `yaml:"-"`
+	// Provider switched to when the active one fails validation or can't be created
+	fallbackProviderConfig *provider.ProviderConfig `yaml:"-"`
+	// @Title zh-CN 返回路由信息
+	// @Description zh-CN 开启后在响应头中返回实际处理请求的服务提供商和模型，并记录到日志中
+	exposeRoutingHeaders bool `required:"false" yaml:"exposeRoutingHeaders"`
+	// @Title zh-CN 新旧配置优先级
+	// @Description zh-CN 同时配置 provider 和 providers 时生效的配置，可选值为 provider（默认）和 providers
+	providerPrecedence string `required:"false" yaml:"providerPrecedence"`
+	// @Title zh-CN 严格配置校验
+	// @Description zh-CN 开启后同时配置 provider 和 providers 将导致配置校验失败
+	strictProviderConfig bool `required:"false" yaml:"strictProviderConfig"`
+	// Whether both the legacy provider and the providers array are configured
+	providerConfigConflict bool `yaml:"-"`
+	// fallbackProviderIgnored is set when fallbackProviderId can't apply to a legacy provider
+	fallbackProviderIgnored bool `yaml:"-"`
 }
 
+const (
+	providerPrecedenceLegacy = "provider"
+	providerPrecedenceMulti  = "providers"
+)
+
 func (c *PluginConfig) FromJson(json gjson.Result) {
+	if exposeRoutingHeaders := json.Get("exposeRoutingHeaders"); exposeRoutingHeaders.Exists() {
+		c.exposeRoutingHeaders = exposeRoutingHeaders.Bool()
+	}
+	if providerPrecedence := json.Get("providerPrecedence"); providerPrecedence.Exists() {
+		c.providerPrecedence = providerPrecedence.String()
+	}
+	if strictProviderConfig := json.Get("strictProviderConfig"); strictProviderConfig.Exists() {
+		c.strictProviderConfig = strictProviderConfig.Bool()
+	}
+
+	providerJson := json.Get("provider")
+	providersJson := json.Get("providers")
+	c.providerConfigConflict = providerJson.Exists() && providerJson.IsObject() && providersJson.Exists() && providersJson.IsArray()
+	if c.providerConfigConflict {
+		winner, ignored := providerPrecedenceLegacy, providerPrecedenceMulti
+		if c.providerPrecedence == providerPrecedenceMulti {
+			winner, ignored = providerPrecedenceMulti, providerPrecedenceLegacy
+		}
+		log.Warnf("[ai-proxy] both provider and providers are configured, using %s and ignoring %s", winner, ignored)
+	}
+
 	// Process providers array configuration first
-	if providersJson := json.Get("providers"); providersJson.Exists() && providersJson.IsArray() {
+	if providersJson.Exists() && providersJson.IsArray() {
 		c.providerConfigs = make([]provider.ProviderConfig, 0)
 		for _, providerJson := range providersJson.Array() {
 			providerConfig := provider.ProviderConfig{}
@@ -43,20 +87,29 @@ func (c *PluginConfig) FromJson(json gjson.Result) {
 		}
 	}
 
-	// Process legacy single provider configuration
-	if providerJson := json.Get("provider"); providerJson.Exists() && providerJson.IsObject() {
+	// Process legacy single provider configuration, unless providers is set to take precedence
+	legacyWins := !c.providerConfigConflict || c.providerPrecedence != providerPrecedenceMulti
+	if providerJson.Exists() && providerJson.IsObject() && legacyWins {
 		// Legacy single provider configuration
 		providerConfig := provider.ProviderConfig{}
 		providerConfig.FromJson(providerJson)
 		c.providerConfigs = []provider.ProviderConfig{providerConfig}
 		c.activeProviderConfig = &c.providerConfigs[0]
+		c.fallbackProviderConfig = nil
+		// There's no other provider to fall back to with a single legacy provider
+		c.fallbackProviderIgnored = json.Get("fallbackProviderId").String() != ""
+		if c.fallbackProviderIgnored {
+			log.Warnf("[ai-proxy] fallbackProviderId only applies to providers, ignoring it for the legacy provider")
+		}
 		// Legacy configuration is used and the active provider is determined.
 		// We don't need to continue with the new configuration style.
 		return
 	}
 
-	// Reset active provider config
+	// Reset active and fallback provider config
 	c.activeProviderConfig = nil
+	c.fallbackProviderConfig = nil
+	c.fallbackProviderIgnored = false
 
 	// Process activeProviderId to select from configured providers
 	activeProviderId := json.Get("activeProviderId").String()
@@ -68,14 +121,37 @@ func (c *PluginConfig) FromJson(json gjson.Result) {
 			}
 		}
 	}
+
+	// Process fallbackProviderId to select the provider used when the active one is broken
+	fallbackProviderId := json.Get("fallbackProviderId").String()
+	if fallbackProviderId != "" {
+		for i := range c.providerConfigs {
+			if c.providerConfigs[i].GetId() == fallbackProviderId {
+				c.fallbackProviderConfig = &c.providerConfigs[i]
+				break
+			}
+		}
+	}
 }
 
 func (c *PluginConfig) Validate() error {
+	if c.providerPrecedence != "" && c.providerPrecedence != providerPrecedenceLegacy && c.providerPrecedence != providerPrecedenceMulti {
+		return errors.New("invalid providerPrecedence: " + c.providerPrecedence + ", must be provider or providers")
+	}
+	if c.providerConfigConflict && c.strictProviderConfig {
+		return errors.New("provider and providers must not be configured together when strictProviderConfig is enabled")
+	}
+	if c.fallbackProviderIgnored && c.strictProviderConfig {
+		return errors.New("fallbackProviderId must not be configured with the legacy provider when strictProviderConfig is enabled")
+	}
 	if c.activeProviderConfig == nil {
 		return nil
 	}
 	if err := c.activeProviderConfig.Validate(); err != nil {
-		return err
+		if !c.switchToFallbackProvider(err) {
+			return err
+		}
+		return c.activeProviderConfig.Validate()
 	}
 	return nil
 }
@@ -88,10 +164,19 @@ func (c *PluginConfig) Complete() error {
 		return nil
 	}
 
+	err := c.completeActiveProvider()
+	if err != nil && c.switchToFallbackProvider(err) {
+		err = c.completeActiveProvider()
+	}
+	return err
+}
+
+func (c *PluginConfig) completeActiveProvider() error {
 	var err error
 
 	c.activeProvider, err = provider.CreateProvider(*c.activeProviderConfig)
 	if err != nil {
+		c.activeProvider = nil
 		return err
 	}
 
@@ -99,6 +184,25 @@ func (c *PluginConfig) Complete() error {
 	return providerConfig.SetApiTokensFailover(c.activeProvider)
 }
 
+// switchToFallbackProvider makes the fallback provider the active one after the active
+// provider failed with err. It returns false when there is no fallback to switch to.
+func (c *PluginConfig) switchToFallbackProvider(err error) bool {
+	if c.fallbackProviderConfig == nil || c.fallbackProviderConfig == c.activeProviderConfig {
+		return false
+	}
+	log.Warnf("[ai-proxy] active provider %s is unusable: %v, downgrading to fallback provider %s",
+		c.activeProviderConfig.GetId(), err, c.fallbackProviderConfig.GetId())
+	c.activeProviderConfig = c.fallbackProviderConfig
+	// Switch at most once, the fallback has no fallback of its own
+	c.fallbackProviderConfig = nil
+	return true
+}
+
+// ExposeRoutingHeaders reports whether the provider and model serving a request are sent back in response headers
+func (c *PluginConfig) ExposeRoutingHeaders() bool {
+	return c.exposeRoutingHeaders
+}
+
 func (c *PluginConfig) GetProvider() provider.Provider {
 	return c.activeProvider
 }
@@ -112,8 +216,21 @@ func (c *PluginConfig) GetProviderConfigs() []provider.ProviderConfig {
 	return c.providerConfigs
 }
 
+// providersByPriority returns the provider configurations ordered by descending priority,
+// keeping the array order for providers with the same priority
+func (c *PluginConfig) providersByPriority() []*provider.ProviderConfig {
+	providerConfigs := make([]*provider.ProviderConfig, 0, len(c.providerConfigs))
+	for i := range c.providerConfigs {
+		providerConfigs = append(providerConfigs, &c.providerConfigs[i])
+	}
+	sort.SliceStable(providerConfigs, func(i, j int) bool {
+		return providerConfigs[i].GetPriority() > providerConfigs[j].GetPriority()
+	})
+	return providerConfigs
+}
+
 // GetProviderForModel returns the provider that should handle the given model
-// It searches through providers in order and returns the first one that has a mapping for the model
+// It searches through providers by priority, then array order, and returns the first one that has a mapping for the model
 func (c *PluginConfig) GetProviderForModel(modelName string) (*provider.ProviderConfig, provider.Provider) {
 	// For legacy single provider configuration
 	if c.activeProviderConfig != nil {
@@ -121,8 +238,7 @@ func (c *PluginConfig) GetProviderForModel(modelName string) (*provider.Provider
 	}
 
 	// For multi-provider configuration, find the first provider that can handle this model
-	for i := range c.providerConfigs {
-		providerConfig := &c.providerConfigs[i]
+	for _, providerConfig := range c.providersByPriority() {
 		if providerConfig.CanHandleModel(modelName) {
 			// Create provider instance if not exists
 			if p, err := provider.CreateProvider(*providerConfig); err == nil {
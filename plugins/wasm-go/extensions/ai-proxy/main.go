@@ -23,6 +23,11 @@ const (
 	pluginName = "ai-proxy"
 
 	defaultMaxBodyBytes uint32 = 100 * 1024 * 1024
+
+	ctxKeyRoutedProvider = "routedProvider"
+
+	headerRoutedProvider = "x-higress-provider"
+	headerRoutedModel    = "x-higress-model"
 )
 
 func main() {
@@ -141,6 +146,7 @@ func onHttpRequestHeader(ctx wrapper.HttpContext, pluginConfig config.PluginConf
 		// Store provider info in context
 		ctx.SetContext("activeProvider", activeProvider)
 		ctx.SetContext("activeProviderConfig", activeProviderConfig)
+		ctx.SetContext(ctxKeyRoutedProvider, routedProviderName(activeProviderConfig))
 	} else {
 		log.Debugf("[onHttpRequestHeader] multi-provider mode, will select provider based on model")
 	}
@@ -239,6 +245,7 @@ func onHttpRequestBody(ctx wrapper.HttpContext, pluginConfig config.PluginConfig
 					activeProvider = selectedProvider
 
 					log.Debugf("[onHttpRequestBody] selected provider=%s for model=%s", selectedProvider.GetProviderType(), modelName)
+					ctx.SetContext(ctxKeyRoutedProvider, routedProviderName(selectedConfig))
 
 					// Set up the selected provider (similar to header phase)
 					activeProviderConfig.SetApiTokenInUse(ctx)
@@ -305,6 +312,10 @@ func onHttpResponseHeaders(ctx wrapper.HttpContext, pluginConfig config.PluginCo
 		return types.ActionContinue
 	}
 
+	if pluginConfig.ExposeRoutingHeaders() {
+		setRoutingHeaders(ctx)
+	}
+
 	activeProvider := pluginConfig.GetProvider()
 
 	if activeProvider == nil {
@@ -458,6 +469,29 @@ func checkStream(ctx wrapper.HttpContext) {
 	}
 }
 
+// routedProviderName identifies the provider in routing headers and logs, by id when it has one
+func routedProviderName(providerConfig *provider.ProviderConfig) string {
+	if id := providerConfig.GetId(); id != "" {
+		return id
+	}
+	return providerConfig.GetType()
+}
+
+// setRoutingHeaders tells the client which provider and downstream model served the request,
+// and logs them so charges can be reconciled against a concrete provider
+func setRoutingHeaders(ctx wrapper.HttpContext) {
+	providerName := ctx.GetStringContext(ctxKeyRoutedProvider, "")
+	if providerName == "" {
+		return
+	}
+	model := provider.GetRoutedModel(ctx)
+	_ = proxywasm.ReplaceHttpResponseHeader(headerRoutedProvider, providerName)
+	if model != "" {
+		_ = proxywasm.ReplaceHttpResponseHeader(headerRoutedModel, model)
+	}
+	log.Infof("[ai-proxy] request served by provider=%s model=%s", providerName, model)
+}
+
 func getApiName(path string) provider.ApiName {
 	// openai style
 	if strings.HasSuffix(path, "/v1/chat/completions") {
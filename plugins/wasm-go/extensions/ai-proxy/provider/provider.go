@@ -215,6 +215,9 @@ type ProviderConfig struct {
 	// @Title zh-CN 类型
 	// @Description zh-CN AI服务提供商类型
 	typ string `required:"true" yaml:"type" json:"type"`
+	// @Title zh-CN 路由优先级
+	// @Description zh-CN 多个服务提供商都能处理同一模型时，优先选择该值较大的提供商；取值相同时按配置顺序选择。默认值为0。
+	priority int `required:"false" yaml:"priority" json:"priority"`
 	// @Title zh-CN API Tokens
 	// @Description zh-CN 在请求AI服务时用于认证的API Token列表。不同的AI服务提供商可能有不同的名称。部分供应商只支持配置一个API Token（如Azure OpenAI）。
 	apiTokens []string `required:"false" yaml:"apiToken" json:"apiTokens"`
@@ -326,6 +329,10 @@ func (c *ProviderConfig) GetType() string {
 	return c.typ
 }
 
+func (c *ProviderConfig) GetPriority() int {
+	return c.priority
+}
+
 func (c *ProviderConfig) GetProtocol() string {
 	return c.protocol
 }
@@ -337,6 +344,7 @@ func (c *ProviderConfig) IsOpenAIProtocol() bool {
 func (c *ProviderConfig) FromJson(json gjson.Result) {
 	c.id = json.Get("id").String()
 	c.typ = json.Get("type").String()
+	c.priority = int(json.Get("priority").Int())
 	c.apiTokens = make([]string, 0)
 	for _, token := range json.Get("apiTokens").Array() {
 		c.apiTokens = append(c.apiTokens, token.String())
@@ -596,6 +604,14 @@ func (c *ProviderConfig) mapModel(ctx wrapper.HttpContext, model *string) error
 	return nil
 }
 
+// GetRoutedModel returns the model sent to the provider, or the requested model when no mapping was applied
+func GetRoutedModel(ctx wrapper.HttpContext) string {
+	if model := ctx.GetStringContext(ctxKeyFinalRequestModel, ""); model != "" {
+		return model
+	}
+	return ctx.GetStringContext(ctxKeyOriginalRequestModel, "")
+}
+
 func getMappedModel(model string, modelMapping map[string]string) string {
 	mappedModel := doGetMappedModel(model, modelMapping)
 	if len(mappedModel) != 0 {
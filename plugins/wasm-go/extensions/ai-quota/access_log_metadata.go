@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+// Quota decisions written to the access log metadata, named like the decision metrics
+const (
+	QuotaDecisionAllowed            = "allowed"
+	QuotaDecisionFreeAllowance      = "free_allowance_allowed"
+	QuotaDecisionZeroWeight         = "zero_weight_skipped"
+	QuotaDecisionWarmupGrace        = "warmup_grace_allowed"
+	QuotaDecisionInsufficientQuota  = "denied_insufficient_quota"
+	QuotaDecisionDeniedStar         = "denied_star"
+	QuotaDecisionUnknownModelDenied = "unknown_model_denied"
+)
+
+// AccessLogMetadata names the filter state properties the quota decision is written to,
+// each readable in access logs as %FILTER_STATE(wasm.<namespace>.<key>:PLAIN)%
+type AccessLogMetadata struct {
+	Enabled      bool   `yaml:"enabled" json:"enabled"`
+	Namespace    string `yaml:"namespace" json:"namespace"`
+	UserIdKey    string `yaml:"user_id_key" json:"user_id_key"`
+	ModelKey     string `yaml:"model_key" json:"model_key"`
+	WeightKey    string `yaml:"weight_key" json:"weight_key"`
+	RemainingKey string `yaml:"remaining_key" json:"remaining_key"`
+	DecisionKey  string `yaml:"decision_key" json:"decision_key"`
+}
+
+// quotaDecision is the quota state of a request at the point its quota check was decided
+type quotaDecision struct {
+	userId       string
+	modelName    string
+	weight       int
+	remaining    int
+	hasRemaining bool
+	decision     string
+}
+
+func parseAccessLogMetadata(json gjson.Result, config *QuotaConfig) error {
+	metadata := json.Get("access_log_metadata")
+	config.AccessLogMetadata = AccessLogMetadata{
+		Enabled:      metadata.Get("enabled").Bool(),
+		Namespace:    metadata.Get("namespace").String(),
+		UserIdKey:    metadata.Get("user_id_key").String(),
+		ModelKey:     metadata.Get("model_key").String(),
+		WeightKey:    metadata.Get("weight_key").String(),
+		RemainingKey: metadata.Get("remaining_key").String(),
+		DecisionKey:  metadata.Get("decision_key").String(),
+	}
+	m := &config.AccessLogMetadata
+	if !metadata.Get("namespace").Exists() {
+		m.Namespace = "ai_quota"
+	}
+	if m.UserIdKey == "" {
+		m.UserIdKey = "user_id"
+	}
+	if m.ModelKey == "" {
+		m.ModelKey = "model"
+	}
+	if m.WeightKey == "" {
+		m.WeightKey = "weight"
+	}
+	if m.RemainingKey == "" {
+		m.RemainingKey = "remaining"
+	}
+	if m.DecisionKey == "" {
+		m.DecisionKey = "decision"
+	}
+	if m.Enabled && m.Namespace == "" {
+		return errors.New("access_log_metadata namespace must not be empty")
+	}
+	return nil
+}
+
+func (m AccessLogMetadata) property(key string) []string {
+	return []string{m.Namespace + "." + key}
+}
+
+// emitQuotaDecision writes the quota decision of the request to filter state for the
+// access log and downstream filters. Failures are only logged, they never affect the request.
+func emitQuotaDecision(config QuotaConfig, d quotaDecision, log wrapper.Log) {
+	emitDecisionObject(config, d, log)
+	m := config.AccessLogMetadata
+	if !m.Enabled {
+		return
+	}
+	values := map[string]string{
+		m.UserIdKey:   maskUserId(d.userId),
+		m.DecisionKey: d.decision,
+	}
+	// Decisions taken before the model is known carry no model or weight
+	if d.modelName != "" {
+		values[m.ModelKey] = d.modelName
+		values[m.WeightKey] = config.formatQuota(d.weight)
+	}
+	if d.hasRemaining {
+		values[m.RemainingKey] = config.formatQuota(d.remaining)
+	}
+	for key, value := range values {
+		if err := proxywasm.SetProperty(m.property(key), []byte(value)); err != nil {
+			log.Warnf("Failed to set access log metadata %s.%s: %v", m.Namespace, key, err)
+		}
+	}
+}
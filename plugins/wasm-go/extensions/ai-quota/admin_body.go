@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const defaultMaxAdminBodyBytes = 64 * 1024
+
+func parseMaxAdminBodyConfig(json gjson.Result, config *QuotaConfig) error {
+	config.MaxAdminBodyBytes = defaultMaxAdminBodyBytes
+	if maxBytes := json.Get("max_admin_body_bytes"); maxBytes.Exists() {
+		config.MaxAdminBodyBytes = int(maxBytes.Int())
+	}
+	if config.MaxAdminBodyBytes < 0 {
+		return errors.New("max_admin_body_bytes must not be negative")
+	}
+	return nil
+}
+
+// adminBodyTooLarge reports whether an admin request body of the given size exceeds
+// max_admin_body_bytes, 0 disabling the limit
+func (c QuotaConfig) adminBodyTooLarge(size int) bool {
+	return c.MaxAdminBodyBytes > 0 && size > c.MaxAdminBodyBytes
+}
+
+// rejectOversizedAdminBody rejects an admin request whose declared content-length exceeds
+// the limit before its body is buffered, returning whether it was rejected
+func rejectOversizedAdminBody(config QuotaConfig) bool {
+	contentLength, err := proxywasm.GetHttpRequestHeader("content-length")
+	if err != nil || contentLength == "" {
+		return false
+	}
+	size, err := strconv.Atoi(contentLength)
+	if err != nil || !config.adminBodyTooLarge(size) {
+		return false
+	}
+	sendAdminBodyTooLarge(config)
+	return true
+}
+
+func sendAdminBodyTooLarge(config QuotaConfig) {
+	config.sendJSONResponse(http.StatusRequestEntityTooLarge, "ai-gateway.admin_body_too_large",
+		fmt.Sprintf("Request denied by ai quota check. Admin request body exceeds %d bytes.", config.MaxAdminBodyBytes), false, nil)
+}
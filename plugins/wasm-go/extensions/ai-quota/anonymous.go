@@ -0,0 +1,30 @@
+package main
+
+import (
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+)
+
+func parseAnonymousConfig(json gjson.Result, config *QuotaConfig) {
+	config.AllowAnonymousPaths = make([]string, 0)
+	for _, anonymousPath := range json.Get("allow_anonymous_paths").Array() {
+		if anonymousPath.String() != "" {
+			config.AllowAnonymousPaths = append(config.AllowAnonymousPaths, anonymousPath.String())
+		}
+	}
+	config.AnonymousQuotaUser = json.Get("anonymous_quota_user").String()
+	if config.AnonymousQuotaUser == "" {
+		config.AnonymousQuotaUser = "anonymous"
+	}
+}
+
+// useAnonymousUser charges a request without a usable token to the shared anonymous
+// quota user when its path allows anonymous access, reporting whether it did
+func useAnonymousUser(ctx wrapper.HttpContext, config QuotaConfig, reason string, log wrapper.Log) bool {
+	if !matchesPath(config.AllowAnonymousPaths, ctx.Path()) {
+		return false
+	}
+	log.Debugf("Request to %s has %s, charging it to the anonymous user %s", ctx.Path(), reason, config.AnonymousQuotaUser)
+	ctx.SetContext("userId", config.AnonymousQuotaUser)
+	return true
+}
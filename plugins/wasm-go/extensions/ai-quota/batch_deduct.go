@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/resp"
+)
+
+// batchDeductQuota deducts the weights of several models in one step, so a request
+// fanning out to multiple models is charged for all of them or for none
+func batchDeductQuota(ctx wrapper.HttpContext, config QuotaConfig, body string, log wrapper.Log) types.Action {
+	queryValues, _ := url.ParseQuery(body)
+	userId := queryValues.Get("user_id")
+	models := make([]string, 0)
+	for _, model := range strings.Split(queryValues.Get("models"), ",") {
+		if model = strings.TrimSpace(model); model != "" {
+			models = append(models, model)
+		}
+	}
+	if userId == "" || len(models) == 0 {
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id and models can't be empty.", false, nil)
+		return types.ActionContinue
+	}
+
+	weights := make([]int, 0, len(models))
+	modelWeights := make([]map[string]interface{}, 0, len(models))
+	required := 0
+	for _, model := range models {
+		// Completions are always POST requests
+		weight := resolveQuotaWeight(config, model, http.MethodPost, log)
+		weights = append(weights, weight)
+		required += weight
+		modelWeights = append(modelWeights, map[string]interface{}{
+			"model":  model,
+			"weight": json.Number(config.formatQuota(weight)),
+		})
+	}
+
+	if rejectOversizedDeduction(config, userId, strings.Join(models, ","), required, log) {
+		return types.ActionContinue
+	}
+
+	err := config.redisClient.AtomicBatchQuotaCheck(config.totalKey(userId), config.usedKey(userId), weights, func(response resp.Value) {
+		if wrapper.IsRedisErrorResponse(response) || len(response.Array()) < 4 {
+			redisErr := wrapper.GetRedisErrorFromResponse(response)
+			log.Errorf("Failed to batch deduct quota for user %s: %v", maskUserId(userId), redisErr)
+			config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", redisErr), false, nil)
+			return
+		}
+		result := response.Array()
+		remaining := result[2].Integer()
+		if result[3].Integer() != 1 {
+			log.Warnf("Insufficient quota for batch deduction of user %s: remaining=%d, required=%d", maskUserId(userId), remaining, required)
+			config.sendJSONResponse(http.StatusForbidden, "quota-check.insufficient_quota",
+				fmt.Sprintf("Insufficient quota. Required: %s, Available: %s", config.formatQuota(required), config.formatQuota(remaining)), false, nil)
+			return
+		}
+		log.Infof("Batch deducted %d quota for user %s across %d models", required, maskUserId(userId), len(models))
+		checkAutoRefill(config, userId, strings.Join(models, ","), remaining-required, log)
+		requestId, _ := proxywasm.GetHttpRequestHeader(config.RequestIdHeader)
+		for i, model := range models {
+			appendUsageLog(config, userId, model, weights[i], UsageSourceUser, requestId, log)
+		}
+		if wantsCSV() {
+			// One row per model, the remaining quota is the balance after the whole batch
+			rows := make([][]string, 0, len(models))
+			for i, model := range models {
+				rows = append(rows, []string{userId, model, config.formatQuota(weights[i]), config.formatQuota(remaining - required)})
+			}
+			config.sendCSVResponse(http.StatusOK, "ai-gateway.batchdeduct", []string{"user_id", "model", "weight", "remaining"}, rows)
+			return
+		}
+		data := map[string]interface{}{
+			"user_id":   userId,
+			"models":    modelWeights,
+			"deducted":  json.Number(config.formatQuota(required)),
+			"remaining": json.Number(config.formatQuota(remaining - required)),
+		}
+		config.sendJSONResponse(http.StatusOK, "ai-gateway.batchdeduct", "batch deduct successful", true, data)
+	})
+	if err != nil {
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+// bulkEntry is a validated entry of a bulk refresh or delta request
+type bulkEntry struct {
+	userId string
+	amount int
+	// expected value of a compare-and-set refresh, used when compare is true
+	expected string
+	compare  bool
+}
+
+// bulkEntryResult is the outcome of one entry, reported at the entry's index
+type bulkEntryResult struct {
+	Index   int    `json:"index"`
+	UserId  string `json:"user_id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// parseBulkEntries validates a JSON array of {"user_id": string, <amountField>: number}
+// entries, pointing at the index of the first malformed entry
+func parseBulkEntries(config QuotaConfig, body string, amountField string) ([]bulkEntry, error) {
+	if !gjson.Valid(body) {
+		return nil, fmt.Errorf("body must be a JSON array")
+	}
+	parsed := gjson.Parse(body)
+	if !parsed.IsArray() {
+		return nil, fmt.Errorf("body must be a JSON array")
+	}
+	items := parsed.Array()
+	if len(items) == 0 {
+		return nil, fmt.Errorf("body must contain at least one entry")
+	}
+	entries := make([]bulkEntry, 0, len(items))
+	for i, item := range items {
+		if !item.IsObject() {
+			return nil, fmt.Errorf("entry %d must be an object", i)
+		}
+		userId := item.Get("user_id")
+		if userId.Type != gjson.String || userId.String() == "" {
+			return nil, fmt.Errorf("entry %d: user_id must be a non-empty string", i)
+		}
+		amountJson := item.Get(amountField)
+		if amountJson.Type != gjson.Number {
+			return nil, fmt.Errorf("entry %d: %s must be a number", i, amountField)
+		}
+		amount, err := config.parseQuota(amountJson.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %s must be a number within quota_precision decimal places", i, amountField)
+		}
+		entry := bulkEntry{userId: userId.String(), amount: amount}
+		if amountField == "quota" {
+			if entry.expected, entry.compare, err = parseBulkExpected(config, item, i); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// bulkUpdateQuota applies each entry with its own Redis call and reports every outcome,
+// so one failing key doesn't fail the whole batch and a partial import can be resumed
+func bulkUpdateQuota(config QuotaConfig, body string, amountField string, code string,
+	update func(entry bulkEntry, callback wrapper.RedisResponseCallback) error, log wrapper.Log) types.Action {
+	entries, err := parseBulkEntries(config, body, amountField)
+	if err != nil {
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. "+err.Error()+".", false, nil)
+		return types.ActionContinue
+	}
+
+	results := make([]bulkEntryResult, len(entries))
+	pending := len(entries)
+	dispatched := false
+	finish := func() {
+		failed := 0
+		for _, result := range results {
+			if !result.Success {
+				failed++
+			}
+		}
+		statusCode := uint32(http.StatusOK)
+		if failed > 0 {
+			statusCode = http.StatusMultiStatus
+		}
+		if wantsCSV() {
+			rows := make([][]string, 0, len(results))
+			for _, result := range results {
+				rows = append(rows, []string{strconv.Itoa(result.Index), result.UserId, strconv.FormatBool(result.Success), result.Error})
+			}
+			config.sendCSVResponse(statusCode, code, []string{"index", "user_id", "success", "error"}, rows)
+			return
+		}
+		data := map[string]interface{}{
+			"results":   results,
+			"succeeded": len(results) - failed,
+			"failed":    failed,
+		}
+		if failed == 0 {
+			config.sendJSONResponse(statusCode, code, "bulk update successful", true, data)
+		} else {
+			config.sendJSONResponse(statusCode, code, fmt.Sprintf("bulk update partially failed, %d of %d entries failed", failed, len(results)), false, data)
+		}
+	}
+	for i, entry := range entries {
+		i, entry := i, entry
+		results[i] = bulkEntryResult{Index: i, UserId: entry.userId}
+		err := update(entry, func(response resp.Value) {
+			if err := response.Error(); err != nil {
+				log.Warnf("Bulk update of user %s failed: %v", maskUserId(entry.userId), err)
+				results[i].Error = fmt.Sprintf("redis error:%v", err)
+			} else {
+				results[i].Success = true
+			}
+			pending--
+			if pending == 0 {
+				finish()
+			}
+		})
+		if err != nil {
+			log.Warnf("Bulk update of user %s failed: %v", maskUserId(entry.userId), err)
+			results[i].Error = fmt.Sprintf("redis error:%v", err)
+			pending--
+			continue
+		}
+		dispatched = true
+	}
+	if !dispatched {
+		finish()
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
+
+// bulkRefreshQuota sets the total quota of every {"user_id", "quota"} entry, as a
+// compare-and-set for entries with an "expected" value
+func bulkRefreshQuota(config QuotaConfig, body string, log wrapper.Log) types.Action {
+	return bulkUpdateQuota(config, body, "quota", "ai-gateway.bulkrefreshquota", func(entry bulkEntry, callback wrapper.RedisResponseCallback) error {
+		return config.setQuotaKey(config.totalKey(entry.userId), entry.amount, entry.expected, entry.compare, callback)
+	}, log)
+}
+
+// bulkDeltaQuota grants (or takes back, when negative) the "value" of every entry
+func bulkDeltaQuota(config QuotaConfig, body string, log wrapper.Log) types.Action {
+	return bulkUpdateQuota(config, body, "value", "ai-gateway.bulkdeltaquota", func(entry bulkEntry, callback wrapper.RedisResponseCallback) error {
+		if config.RequireExistingUser {
+			return config.redisClient.Eval(incrByExistingScript, 1, []interface{}{config.totalKey(entry.userId)}, []interface{}{entry.amount}, callback)
+		}
+		return config.redisClient.IncrBy(config.totalKey(entry.userId), entry.amount, callback)
+	}, log)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/resp"
+)
+
+// checkOnlyHeader asks for the quota decision of a completion request without proxying it
+const checkOnlyHeader = "x-quota-check-only"
+
+// checkOnlyScript makes the decision of the deduction scripts without deducting. KEYS are
+// (total, used) pairs. In 'all' mode every pair must cover the weight, pairs after the
+// first only when their total is set, as for team members and their sub-limit. In 'first'
+// mode the first pair with a positive total decides, or the last one, as for org members.
+// Returns {allowed, remaining}.
+const checkOnlyScript = `
+	local weight = tonumber(ARGV[1])
+	local remaining = nil
+	for i = 1, #KEYS, 2 do
+		local total = tonumber(redis.call('get', KEYS[i]))
+		local left = (total or 0) - (tonumber(redis.call('get', KEYS[i + 1])) or 0)
+		if ARGV[2] == 'first' then
+			if (total and total > 0) or i + 1 == #KEYS then
+				return {left >= weight and 1 or 0, left}
+			end
+		elseif total or i == 1 then
+			if left < weight then
+				return {0, left}
+			end
+			remaining = remaining or left
+		end
+	end
+	return {1, remaining}
+`
+
+func checkOnlyRequested() bool {
+	value, err := proxywasm.GetHttpRequestHeader(checkOnlyHeader)
+	return err == nil && strings.EqualFold(strings.TrimSpace(value), "true")
+}
+
+// checkQuotaOnly answers whether the quota covers the request, reading the counters the
+// request would be charged to, and never sends it upstream
+func checkQuotaOnly(ctx wrapper.HttpContext, config QuotaConfig, userId string, quotaWeight int, modelName string, log wrapper.Log) types.Action {
+	if config.FreeDailyAllowance > 0 {
+		err := config.redisClient.Get(config.freeAllowanceKey(userId, time.Now()), func(response resp.Value) {
+			if wrapper.IsRedisErrorResponse(response) {
+				sendCheckOnlyError(config, userId, wrapper.GetRedisErrorFromResponse(response), log)
+				return
+			}
+			used, _ := strconv.Atoi(response.String())
+			if free := config.FreeDailyAllowance - used; free >= quotaWeight {
+				sendCheckOnlyResult(config, userId, modelName, quotaWeight, true, free, log)
+				return
+			}
+			checkPaidQuotaOnly(ctx, config, userId, quotaWeight, modelName, log)
+		})
+		if err != nil {
+			sendCheckOnlyError(config, userId, err, log)
+			return types.ActionContinue
+		}
+		return types.ActionPause
+	}
+	if err := checkPaidQuotaOnly(ctx, config, userId, quotaWeight, modelName, log); err != nil {
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
+
+// checkPaidQuotaOnly reads the same counters as deductPaidQuota would charge
+func checkPaidQuotaOnly(ctx wrapper.HttpContext, config QuotaConfig, userId string, quotaWeight int, modelName string, log wrapper.Log) error {
+	keys := []interface{}{config.modelTotalKey(userId, modelName), config.modelUsedKey(userId, modelName)}
+	mode := "all"
+	if team, exists := config.UserTeams[userId]; exists {
+		keys = []interface{}{config.teamKey(team), config.teamUsedKey(team), config.modelTotalKey(userId, modelName), config.modelUsedKey(userId, modelName)}
+	} else if org, exists := userOrg(ctx, config, userId); exists {
+		keys = append(keys, config.orgKey(org), config.orgUsedKey(org))
+		mode = "first"
+	}
+	err := config.redisClient.Eval(checkOnlyScript, len(keys), keys, []interface{}{quotaWeight, mode}, func(response resp.Value) {
+		if wrapper.IsRedisErrorResponse(response) || len(response.Array()) < 2 {
+			sendCheckOnlyError(config, userId, wrapper.GetRedisErrorFromResponse(response), log)
+			return
+		}
+		result := response.Array()
+		sendCheckOnlyResult(config, userId, modelName, quotaWeight, result[0].Integer() == 1, result[1].Integer(), log)
+	})
+	if err != nil {
+		sendCheckOnlyError(config, userId, err, log)
+	}
+	return err
+}
+
+func sendCheckOnlyResult(config QuotaConfig, userId string, modelName string, quotaWeight int, allowed bool, remaining int, log wrapper.Log) {
+	log.Debugf("Check only request of user %s for model %s: would_allow=%t, remaining=%s, cost=%s",
+		maskUserId(userId), modelName, allowed, config.formatQuota(remaining), config.formatQuota(quotaWeight))
+	data := map[string]interface{}{
+		"would_allow": allowed,
+		"remaining":   json.Number(config.formatQuota(remaining)),
+		"cost":        json.Number(config.formatQuota(quotaWeight)),
+		"model":       modelName,
+	}
+	config.sendJSONResponse(http.StatusOK, "quota-check.check_only", "quota check only, request not sent", true, data)
+}
+
+// sendCheckOnlyDenied refuses a model in the user's denied models set without reading the
+// counters, giving the deny message the real request would be rejected with
+func sendCheckOnlyDenied(config QuotaConfig, userId string, modelName string, quotaWeight int, message string, log wrapper.Log) {
+	log.Debugf("Check only request of user %s for model %s: model denied", maskUserId(userId), modelName)
+	data := map[string]interface{}{
+		"would_allow": false,
+		"cost":        json.Number(config.formatQuota(quotaWeight)),
+		"model":       modelName,
+		"reason":      message,
+	}
+	config.sendJSONResponse(http.StatusOK, "quota-check.check_only", "quota check only, request not sent", true, data)
+}
+
+func sendCheckOnlyError(config QuotaConfig, userId string, err error, log wrapper.Log) {
+	log.Errorf("Failed to check the quota of user %s: %v", maskUserId(userId), err)
+	config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+)
+
+// quotaConflictPrefix starts the error returned when a compare-and-set finds another
+// value, followed by that value or "unset"
+const quotaConflictPrefix = "CONFLICT current value is "
+
+// compareAndSetScript sets KEYS[1] only while it still holds the expected value ARGV[1],
+// where an empty expected value means the key must not exist yet
+const compareAndSetScript = `
+	local current = redis.call('get', KEYS[1])
+	if ARGV[1] == '' then
+		if current then
+			return redis.error_reply('CONFLICT current value is ' .. current)
+		end
+	elseif not current or tonumber(current) ~= tonumber(ARGV[1]) then
+		return redis.error_reply('CONFLICT current value is ' .. (current or 'unset'))
+	end
+	return redis.call('set', KEYS[1], ARGV[2])
+`
+
+// parseExpectedQuota reads the optional expected value of a refresh, sending the 400 and
+// returning false when it is malformed or required but missing. An empty expected value
+// asks for the key not to exist yet.
+func parseExpectedQuota(config QuotaConfig, queryValues url.Values) (string, bool, bool) {
+	if _, exists := queryValues["expected"]; !exists {
+		if config.RequireCompareAndSet {
+			config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. expected is required when require_compare_and_set is enabled.", false, nil)
+			return "", false, false
+		}
+		return "", false, true
+	}
+	raw := queryValues.Get("expected")
+	if raw == "" {
+		return "", true, true
+	}
+	expected, err := config.parseQuota(raw)
+	if err != nil {
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. expected must be empty or a number within quota_precision decimal places.", false, nil)
+		return "", false, false
+	}
+	return strconv.Itoa(expected), true, true
+}
+
+// parseBulkExpected reads the optional "expected" value of a bulk refresh entry, null
+// asking for the key not to exist yet
+func parseBulkExpected(config QuotaConfig, item gjson.Result, index int) (string, bool, error) {
+	expectedJson := item.Get("expected")
+	if !expectedJson.Exists() {
+		if config.RequireCompareAndSet {
+			return "", false, fmt.Errorf("entry %d: expected is required when require_compare_and_set is enabled", index)
+		}
+		return "", false, nil
+	}
+	if expectedJson.Type == gjson.Null {
+		return "", true, nil
+	}
+	if expectedJson.Type != gjson.Number {
+		return "", false, fmt.Errorf("entry %d: expected must be a number or null", index)
+	}
+	expected, err := config.parseQuota(expectedJson.Raw)
+	if err != nil {
+		return "", false, fmt.Errorf("entry %d: expected must be a number within quota_precision decimal places", index)
+	}
+	return strconv.Itoa(expected), true, nil
+}
+
+// setQuotaKey sets the quota, as a compare-and-set against expected when compare is true
+func (c QuotaConfig) setQuotaKey(key string, quota int, expected string, compare bool, callback wrapper.RedisResponseCallback) error {
+	if !compare {
+		return c.redisClient.Set(key, quota, callback)
+	}
+	return c.redisClient.Eval(compareAndSetScript, 1, []interface{}{key}, []interface{}{expected, quota}, callback)
+}
+
+func isQuotaConflict(err error) bool {
+	return strings.HasPrefix(err.Error(), quotaConflictPrefix)
+}
+
+// sendQuotaWriteError answers a failed refresh, with 409 and the current value when a
+// compare-and-set lost against a concurrent write
+func sendQuotaWriteError(config QuotaConfig, err error) {
+	if isQuotaConflict(err) {
+		var current interface{}
+		if value, parseErr := strconv.Atoi(strings.TrimPrefix(err.Error(), quotaConflictPrefix)); parseErr == nil {
+			current = json.Number(config.formatQuota(value))
+		}
+		config.sendJSONResponse(http.StatusConflict, "ai-gateway.quota_conflict",
+			"Quota was changed concurrently, query it and retry with the current value as expected", false, map[string]interface{}{
+				"current": current,
+			})
+		return
+	}
+	config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+}
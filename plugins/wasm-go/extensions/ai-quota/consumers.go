@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/tidwall/gjson"
+)
+
+var errUnknownConsumer = errors.New("unknown consumer")
+
+func parseConsumers(json gjson.Result, config *QuotaConfig) error {
+	config.Consumers = make([]Consumer, 0)
+	config.consumerUsers = make(map[string]string)
+	for _, consumerJson := range json.Get("consumers").Array() {
+		consumer := Consumer{
+			Name:       consumerJson.Get("name").String(),
+			Credential: consumerJson.Get("credential").String(),
+			UserId:     consumerJson.Get("user_id").String(),
+		}
+		if consumer.Name == "" || consumer.UserId == "" {
+			return errors.New("consumer name and user_id must not be empty")
+		}
+		if _, exists := config.consumerUsers[consumer.Name]; exists {
+			return fmt.Errorf("duplicate consumer: %s", consumer.Name)
+		}
+		config.Consumers = append(config.Consumers, consumer)
+		config.consumerUsers[consumer.Name] = consumer.UserId
+	}
+	return nil
+}
+
+// queryUserId returns the user id a query endpoint addresses, given directly by user_id or
+// through the name of a configured consumer. It is empty when neither is given.
+func (c QuotaConfig) queryUserId(query url.Values) (string, error) {
+	if userId := query.Get("user_id"); userId != "" {
+		return userId, nil
+	}
+	consumer := query.Get("consumer")
+	if consumer == "" {
+		return "", nil
+	}
+	userId, exists := c.consumerUsers[consumer]
+	if !exists {
+		return "", errUnknownConsumer
+	}
+	return userId, nil
+}
+
+func sendUnknownConsumer(config QuotaConfig, query url.Values) {
+	config.sendJSONResponse(http.StatusNotFound, "ai-gateway.unknown_consumer",
+		fmt.Sprintf("Request denied by ai quota check. Unknown consumer: %s", query.Get("consumer")), false, nil)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/resp"
+)
+
+const (
+	CostRoundingCeil    = "ceil"
+	CostRoundingFloor   = "floor"
+	CostRoundingNearest = "nearest"
+)
+
+// costRoundingPrecision drops floating point noise before rounding, so that e.g. 3 * 1.1
+// is charged as 3.3 rather than 3.3000000000000003 and ceil gives the same result everywhere
+const costRoundingPrecision = 1e9
+
+// roundCost rounds a fractional cost to whole quota units following cost_rounding
+func (c QuotaConfig) roundCost(cost float64) int {
+	cost = math.Round(cost*costRoundingPrecision) / costRoundingPrecision
+	switch c.CostRounding {
+	case CostRoundingFloor:
+		return int(math.Floor(cost))
+	case CostRoundingNearest:
+		return int(math.Round(cost))
+	default:
+		return int(math.Ceil(cost))
+	}
+}
+
+// resolveQuotaWeight returns the quota units charged for a request to the model:
+// the model weight (0 when not configured) scaled by the HTTP method multiplier
+// and capped at max_weight
+func resolveQuotaWeight(config QuotaConfig, modelName string, method string, log wrapper.Log) int {
+	quotaWeight := 0
+	if weight, pricedAs, exists := config.modelWeight(modelName); exists {
+		if pricedAs != modelName {
+			log.Debugf("Model %s has no quota weight, pricing it as %s", modelName, pricedAs)
+		}
+		quotaWeight = weight
+	}
+	// Scale by the HTTP method multiplier, rounding to whole quota units
+	if multiplier, exists := config.MethodWeights[method]; exists {
+		quotaWeight = config.roundCost(float64(quotaWeight) * multiplier)
+	}
+	if config.MaxWeight > 0 && quotaWeight > config.MaxWeight {
+		log.Warnf("Quota weight %d for model %s exceeds max_weight, capping it at %d", quotaWeight, modelName, config.MaxWeight)
+		quotaWeight = config.MaxWeight
+	}
+	return quotaWeight
+}
+
+// rejectOversizedDeduction refuses a deduction above max_deduction_per_request. Such a
+// charge points at a misconfigured weight or a broken override, so it is logged as an
+// error instead of silently draining the user's balance.
+func rejectOversizedDeduction(config QuotaConfig, userId string, modelName string, amount int, log wrapper.Log) bool {
+	if config.MaxDeductionPerRequest == 0 || amount <= config.MaxDeductionPerRequest {
+		return false
+	}
+	log.Errorf("Refusing to deduct %s from user %s for model %s, it exceeds max_deduction_per_request %s",
+		config.formatQuota(amount), maskUserId(userId), modelName, config.formatQuota(config.MaxDeductionPerRequest))
+	config.sendJSONResponse(http.StatusForbidden, "quota-check.deduction_too_large",
+		fmt.Sprintf("Request cost %s exceeds the per-request limit of %s", config.formatQuota(amount), config.formatQuota(config.MaxDeductionPerRequest)), false, nil)
+	return true
+}
+
+// resolveMappedModel resolves the model through modelMapping the way the provider does:
+// exact match first, then the longest matching prefix pattern, then the "*" catch-all
+func resolveMappedModel(config QuotaConfig, modelName string) (string, bool) {
+	if mapped, exists := config.Provider.ModelMapping[modelName]; exists && mapped != "" {
+		return mapped, true
+	}
+	bestPrefix, bestMapped := "", ""
+	for pattern, mapped := range config.Provider.ModelMapping {
+		if pattern == wildcard || !strings.HasSuffix(pattern, wildcard) || mapped == "" {
+			continue
+		}
+		prefix := strings.TrimSuffix(pattern, wildcard)
+		if strings.HasPrefix(modelName, prefix) && len(prefix) > len(bestPrefix) {
+			bestPrefix, bestMapped = prefix, mapped
+		}
+	}
+	if bestMapped != "" {
+		return bestMapped, true
+	}
+	if mapped := config.Provider.ModelMapping[wildcard]; mapped != "" {
+		return mapped, true
+	}
+	return "", false
+}
+
+// queryCost previews the quota a completion for the model would be charged, and the
+// user's remaining quota when user_id is given, without deducting anything
+func queryCost(config QuotaConfig, url *url.URL, log wrapper.Log) types.Action {
+	modelName := url.Query().Get("model")
+	if modelName == "" {
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. model can't be empty.", false, nil)
+		return types.ActionContinue
+	}
+	// Completions are always POST requests
+	quotaWeight := resolveQuotaWeight(config, modelName, http.MethodPost, log)
+	data := map[string]interface{}{
+		"model":  modelName,
+		"weight": json.Number(config.formatQuota(quotaWeight)),
+	}
+	if mapped, exists := resolveMappedModel(config, modelName); exists {
+		data["mapped_model"] = mapped
+	}
+
+	userId, err := config.queryUserId(url.Query())
+	if err != nil {
+		sendUnknownConsumer(config, url.Query())
+		return types.ActionContinue
+	}
+	if userId == "" {
+		config.sendJSONResponse(http.StatusOK, "ai-gateway.querycost", "query cost successful", true, data)
+		return types.ActionContinue
+	}
+	keys := []string{config.modelTotalKey(userId, modelName), config.modelUsedKey(userId, modelName)}
+	err = config.replicaRead(func(client wrapper.RedisClient, callback wrapper.RedisResponseCallback) error {
+		return client.MGet(keys, callback)
+	}, func(response resp.Value) {
+		if wrapper.IsRedisErrorResponse(response) || len(response.Array()) < 2 {
+			redisErr := wrapper.GetRedisErrorFromResponse(response)
+			log.Errorf("Failed to query remaining quota for user %s: %v", maskUserId(userId), redisErr)
+			config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.redis_error", fmt.Sprintf("redis error:%v", redisErr), false, nil)
+			return
+		}
+		// Missing keys count as 0, as in the quota check
+		values := response.Array()
+		remaining := values[0].Integer() - values[1].Integer()
+		data["user_id"] = userId
+		data["remaining"] = json.Number(config.formatQuota(remaining))
+		data["sufficient"] = remaining >= quotaWeight
+		config.sendJSONResponse(http.StatusOK, "ai-gateway.querycost", "query cost successful", true, data)
+	}, log)
+	if err != nil {
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
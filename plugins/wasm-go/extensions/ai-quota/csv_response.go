@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/url"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-quota/util"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+)
+
+// wantsCSV reports whether the admin request asked for CSV, with an Accept: text/csv
+// header or the format=csv query parameter, for importing results into spreadsheets
+func wantsCSV() bool {
+	if accept, err := proxywasm.GetHttpRequestHeader("accept"); err == nil && strings.Contains(accept, util.MimeTypeTextCsv) {
+		return true
+	}
+	path, err := proxywasm.GetHttpRequestHeader(":path")
+	if err != nil {
+		return false
+	}
+	parsed, err := url.Parse(path)
+	return err == nil && parsed.Query().Get("format") == "csv"
+}
+
+// sendCSVResponse sends rows under a header row. Like sendJSONResponse, the request id
+// is echoed and the code is used as the response code details.
+func (c QuotaConfig) sendCSVResponse(statusCode uint32, code string, header []string, rows [][]string) error {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(header)
+	writer.WriteAll(rows)
+	if err := writer.Error(); err != nil {
+		return err
+	}
+	contentType := util.MimeTypeTextCsv + "; charset=utf-8"
+	if requestId, err := proxywasm.GetHttpRequestHeader(c.RequestIdHeader); err == nil && requestId != "" {
+		return util.SendResponse(statusCode, code, contentType, buf.String(), c.RequestIdHeader, requestId)
+	}
+	return util.SendResponse(statusCode, code, contentType, buf.String())
+}
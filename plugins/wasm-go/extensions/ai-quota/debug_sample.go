@@ -0,0 +1,62 @@
+package main
+
+import (
+	"hash/fnv"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+)
+
+// debugSampleBuckets is the resolution of debug_sample_rate
+const debugSampleBuckets = 10000
+
+// sampledLog raises debug and trace messages to info level, so a sampled
+// request's full quota decision path shows up without enabling debug logging
+type sampledLog struct {
+	wrapper.Log
+	requestId string
+}
+
+func (l sampledLog) Trace(msg string) {
+	l.Log.Infof("[sampled %s] %s", l.requestId, msg)
+}
+
+func (l sampledLog) Tracef(format string, args ...interface{}) {
+	l.Log.Infof("[sampled "+l.requestId+"] "+format, args...)
+}
+
+func (l sampledLog) Debug(msg string) {
+	l.Log.Infof("[sampled %s] %s", l.requestId, msg)
+}
+
+func (l sampledLog) Debugf(format string, args ...interface{}) {
+	l.Log.Infof("[sampled "+l.requestId+"] "+format, args...)
+}
+
+// sampleLog returns a verbose logger for the fraction of requests selected by
+// debug_sample_rate. Selection hashes the request id, so it is deterministic
+// and stays the same across the phases of a request.
+func sampleLog(ctx wrapper.HttpContext, config QuotaConfig, log wrapper.Log) wrapper.Log {
+	if config.DebugSampleRate <= 0 {
+		return log
+	}
+	if sampled, ok := ctx.GetContext("debugSampled").(bool); ok {
+		if sampled {
+			return sampledLog{Log: log, requestId: ctx.GetStringContext("debugSampleRequestId", "")}
+		}
+		return log
+	}
+	requestId := ctx.GetStringContext("requestId", "")
+	sampled := requestId != "" && isDebugSampled(requestId, config.DebugSampleRate)
+	ctx.SetContext("debugSampled", sampled)
+	ctx.SetContext("debugSampleRequestId", requestId)
+	if sampled {
+		return sampledLog{Log: log, requestId: requestId}
+	}
+	return log
+}
+
+func isDebugSampled(requestId string, rate float64) bool {
+	h := fnv.New32a()
+	h.Write([]byte(requestId))
+	return float64(h.Sum32()%debugSampleBuckets) < rate*debugSampleBuckets
+}
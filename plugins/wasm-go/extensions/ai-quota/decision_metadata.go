@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+// DecisionMetadata names the filter state property the whole quota decision is written
+// to as one JSON object, for wasm filters later in the chain to read with GetProperty
+type DecisionMetadata struct {
+	Enabled  bool   `yaml:"enabled" json:"enabled"`
+	Property string `yaml:"property" json:"property"`
+}
+
+// decisionObject is the JSON written to the decision metadata property
+type decisionObject struct {
+	Allowed   bool         `json:"allowed"`
+	UserId    string       `json:"user_id"`
+	Model     string       `json:"model,omitempty"`
+	Weight    *json.Number `json:"weight,omitempty"`
+	Remaining *json.Number `json:"remaining,omitempty"`
+	Reason    string       `json:"reason"`
+}
+
+// allowedDecisions are the quota decisions that let the request through
+var allowedDecisions = map[string]bool{
+	QuotaDecisionAllowed:       true,
+	QuotaDecisionFreeAllowance: true,
+	QuotaDecisionZeroWeight:    true,
+	QuotaDecisionWarmupGrace:   true,
+}
+
+func parseDecisionMetadata(json gjson.Result, config *QuotaConfig) {
+	metadata := json.Get("decision_metadata")
+	config.DecisionMetadata = DecisionMetadata{
+		Enabled:  metadata.Get("enabled").Bool(),
+		Property: metadata.Get("property").String(),
+	}
+	if config.DecisionMetadata.Property == "" {
+		config.DecisionMetadata.Property = "ai_quota_decision"
+	}
+}
+
+// emitDecisionObject writes the quota decision as one JSON object. Failures are only
+// logged, they never affect the request.
+func emitDecisionObject(config QuotaConfig, d quotaDecision, log wrapper.Log) {
+	if !config.DecisionMetadata.Enabled {
+		return
+	}
+	object := decisionObject{
+		Allowed: allowedDecisions[d.decision],
+		UserId:  maskUserId(d.userId),
+		Model:   d.modelName,
+		Reason:  d.decision,
+	}
+	// Decisions taken before the model is known carry no weight
+	if d.modelName != "" {
+		weight := json.Number(config.formatQuota(d.weight))
+		object.Weight = &weight
+	}
+	if d.hasRemaining {
+		remaining := json.Number(config.formatQuota(d.remaining))
+		object.Remaining = &remaining
+	}
+	body, _ := json.Marshal(object)
+	if err := proxywasm.SetProperty([]string{config.DecisionMetadata.Property}, body); err != nil {
+		log.Warnf("Failed to set quota decision metadata %s: %v", config.DecisionMetadata.Property, err)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+// quotaDecisionMetrics counts the outcomes of the quota check since the plugin started,
+// per VM like the Redis metrics
+type quotaDecisionMetrics struct {
+	Allowed                 int64 `json:"allowed"`
+	DeniedInsufficientQuota int64 `json:"denied_insufficient_quota"`
+	DeniedStar              int64 `json:"denied_star"`
+	Exempted                int64 `json:"exempted"`
+	ZeroWeightSkipped       int64 `json:"zero_weight_skipped"`
+	WarmupGraceAllowed      int64 `json:"warmup_grace_allowed"`
+	FreeAllowanceAllowed    int64 `json:"free_allowance_allowed"`
+	UnknownModel            int64 `json:"unknown_model"`
+	// Comparisons of the quota check with shadow_strategy
+	ShadowAgreed   int64 `json:"shadow_agreed"`
+	ShadowDiverged int64 `json:"shadow_diverged"`
+	ShadowFailed   int64 `json:"shadow_failed"`
+}
+
+var decisionMetrics quotaDecisionMetrics
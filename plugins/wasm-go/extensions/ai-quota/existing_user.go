@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/resp"
+)
+
+// incrByExistingScript increments the key only when the user's total quota key exists
+const incrByExistingScript = `
+	if redis.call('exists', KEYS[1]) == 0 then
+		return redis.error_reply('user not found')
+	end
+	return redis.call('incrby', KEYS[1], ARGV[1])
+`
+
+// requireExistingUser runs next only when the user has a total quota key, when
+// require_existing_user is set, so increments can't create phantom users from typos
+func requireExistingUser(config QuotaConfig, userId string, log wrapper.Log, next func() types.Action) types.Action {
+	if !config.RequireExistingUser {
+		return next()
+	}
+	err := config.redisClient.Exists(config.totalKey(userId), func(response resp.Value) {
+		if err := response.Error(); err != nil {
+			config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+			return
+		}
+		if response.Integer() == 0 {
+			log.Warnf("Rejecting admin operation on user %s without a total quota", maskUserId(userId))
+			config.sendJSONResponse(http.StatusNotFound, "ai-gateway.user_not_found",
+				fmt.Sprintf("User %s has no quota, refresh its quota first", maskUserId(userId)), false, nil)
+			return
+		}
+		next()
+	})
+	if err != nil {
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+func parseFreeAllowanceConfig(json gjson.Result, config *QuotaConfig) error {
+	if allowance := json.Get("free_daily_allowance"); allowance.Exists() {
+		var err error
+		config.FreeDailyAllowance, err = config.parseQuota(strconv.FormatFloat(allowance.Float(), 'f', -1, 64))
+		if err != nil {
+			return fmt.Errorf("invalid free_daily_allowance: %v", err)
+		}
+		if config.FreeDailyAllowance < 0 {
+			return errors.New("free_daily_allowance must not be negative")
+		}
+	}
+	config.RedisFreePrefix = json.Get("redis_free_prefix").String()
+	if config.RedisFreePrefix == "" {
+		config.RedisFreePrefix = "chat_quota_free:"
+	}
+	return nil
+}
+
+// freeAllowanceKey is the user's free allowance counter of the UTC day
+func (c QuotaConfig) freeAllowanceKey(userId string, now time.Time) string {
+	return buildKey(c.FreeKeyTemplate, keyParts{user: userId, window: now.UTC().Format("20060102")})
+}
+
+// secondsUntilNextDay is how long today's free allowance counter is kept, with a
+// minute of slack so the counter never expires before the day's key stops being used
+func secondsUntilNextDay(now time.Time) int {
+	now = now.UTC()
+	nextDay := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return int(nextDay.Sub(now).Seconds()) + 60
+}
+
+// checkFreeAllowance draws the request from the user's free daily allowance, calling
+// next to charge the paid quota instead when the allowance is used up. A request is
+// never split between the two.
+func checkFreeAllowance(ctx wrapper.HttpContext, config QuotaConfig, userId string, quotaWeight int, modelName string, log wrapper.Log, next func()) {
+	now := time.Now()
+	freeKey := config.freeAllowanceKey(userId, now)
+	// A single counter is drawn from in full or not at all; it expires after the day ends
+	counters := []wrapper.QuotaCounter{{UsedKey: freeKey, Limit: config.FreeDailyAllowance, TTLSeconds: secondsUntilNextDay(now)}}
+	err := config.redisClient.MultiCounterDeduct(counters, quotaWeight, wrapper.DeductSpill, func(response resp.Value) {
+		result, redisErr := wrapper.ParseMultiCounterDeduct(response, len(counters))
+		if redisErr != nil {
+			// Redis error - fall through to the paid quota, which reports its own errors
+			log.Warnf("Failed to check free allowance for user %s: %v. Charging paid quota.", maskUserId(userId), redisErr)
+			next()
+			return
+		}
+		if !result.Allowed {
+			log.Debugf("Free allowance of user %s used up (%d/%d), charging paid quota", maskUserId(userId), config.FreeDailyAllowance-result.Remaining, config.FreeDailyAllowance)
+			next()
+			return
+		}
+		log.Infof("Drew %d quota for user %s, model %s from the free allowance. Free used today: %d/%d",
+			quotaWeight, maskUserId(userId), modelName, config.FreeDailyAllowance-result.Left[0], config.FreeDailyAllowance)
+		decisionMetrics.Allowed++
+		decisionMetrics.FreeAllowanceAllowed++
+		emitQuotaDecision(config, quotaDecision{userId: userId, modelName: modelName, weight: quotaWeight,
+			decision: QuotaDecisionFreeAllowance}, log)
+		recordCharge(ctx, config, userId, modelName, quotaWeight, freeKey)
+		appendUsageLog(config, userId, modelName, quotaWeight, UsageSourceFree, ctx.GetStringContext("requestId", ""), log)
+		resumeAfterWriteAck(ctx, config, userId, log)
+	})
+	if err != nil {
+		log.Warnf("Failed to dispatch free allowance check for user %s: %v. Charging paid quota.", maskUserId(userId), err)
+		next()
+	}
+}
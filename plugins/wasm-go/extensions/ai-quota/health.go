@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+// healthChecker caches the result of a periodic Redis PING, so health probes are
+// answered locally without a Redis round trip
+type healthChecker struct {
+	client wrapper.RedisClient
+	// checked is false until the first PING completes
+	checked bool
+	healthy bool
+}
+
+func parseHealthConfig(json gjson.Result, config *QuotaConfig) error {
+	config.HealthPath = json.Get("health_path").String()
+	if config.HealthPath == "" {
+		return nil
+	}
+	config.HealthCheckIntervalMs = int(json.Get("health_check_interval_ms").Int())
+	if config.HealthCheckIntervalMs < 0 {
+		return errors.New("health_check_interval_ms must not be negative")
+	}
+	if config.HealthCheckIntervalMs == 0 {
+		config.HealthCheckIntervalMs = 5000
+	}
+	config.health = &healthChecker{client: config.redisClient}
+	wrapper.RegisteTickFunc(int64(config.HealthCheckIntervalMs), config.health.ping)
+	return nil
+}
+
+func (h *healthChecker) ping() {
+	err := h.client.Command([]interface{}{"ping"}, func(response resp.Value) {
+		h.checked = true
+		h.healthy = !wrapper.IsRedisErrorResponse(response) && response.String() == "PONG"
+		if !h.healthy {
+			proxywasm.LogWarnf("Redis health check failed: %v", wrapper.GetRedisErrorFromResponse(response))
+		}
+	})
+	if err != nil {
+		h.checked = true
+		h.healthy = false
+		proxywasm.LogWarnf("Failed to dispatch Redis health check: %v", err)
+	}
+}
+
+// respondHealth answers the health probe. It carries no user or config data, so the
+// path is safe to expose without the admin key.
+func respondHealth(config QuotaConfig) {
+	redisStatus := "ok"
+	if !config.health.checked {
+		// Not probed yet, the plugin itself is initialized
+		redisStatus = "unknown"
+	} else if !config.health.healthy {
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.unhealthy", "redis unreachable", false,
+			map[string]string{"redis": "unreachable"})
+		return
+	}
+	config.sendJSONResponse(http.StatusOK, "ai-gateway.health", "healthy", true, map[string]string{"redis": redisStatus})
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/go-jose/go-jose/v3"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+// JWKSConfig configures JWT signature verification against a remote JWKS endpoint
+type JWKSConfig struct {
+	ServiceName     string `yaml:"service_name" json:"service_name"`
+	ServicePort     int    `yaml:"service_port" json:"service_port"`
+	Uri             string `yaml:"uri" json:"uri"`
+	RefreshInterval int    `yaml:"refresh_interval" json:"refresh_interval"` // seconds
+	Timeout         int    `yaml:"timeout" json:"timeout"`                   // milliseconds
+}
+
+// jwksCache holds the most recently fetched key set. It is shared by all
+// requests through a pointer since the plugin config is passed by value.
+type jwksCache struct {
+	client wrapper.HttpClient
+	uri    string
+	// timeout for the JWKS request in milliseconds
+	timeout uint32
+	keySet  *jose.JSONWebKeySet
+}
+
+func parseJWKSConfig(json gjson.Result, config *QuotaConfig) error {
+	jwksConfig := json.Get("jwks")
+	if !jwksConfig.Exists() {
+		return nil
+	}
+	config.JWKS.ServiceName = jwksConfig.Get("service_name").String()
+	if config.JWKS.ServiceName == "" {
+		return errors.New("jwks service_name must not be empty")
+	}
+	config.JWKS.ServicePort = int(jwksConfig.Get("service_port").Int())
+	if config.JWKS.ServicePort == 0 {
+		if strings.HasSuffix(config.JWKS.ServiceName, ".static") {
+			config.JWKS.ServicePort = 80
+		} else {
+			config.JWKS.ServicePort = 443
+		}
+	}
+	config.JWKS.Uri = jwksConfig.Get("uri").String()
+	if config.JWKS.Uri == "" {
+		return errors.New("jwks uri must not be empty")
+	}
+	config.JWKS.RefreshInterval = int(jwksConfig.Get("refresh_interval").Int())
+	if config.JWKS.RefreshInterval <= 0 {
+		config.JWKS.RefreshInterval = 300
+	}
+	config.JWKS.Timeout = int(jwksConfig.Get("timeout").Int())
+	if config.JWKS.Timeout <= 0 {
+		config.JWKS.Timeout = 2000
+	}
+
+	config.jwks = &jwksCache{
+		client: wrapper.NewClusterClient(wrapper.FQDNCluster{
+			FQDN: config.JWKS.ServiceName,
+			Port: int64(config.JWKS.ServicePort),
+		}),
+		uri:     config.JWKS.Uri,
+		timeout: uint32(config.JWKS.Timeout),
+	}
+	// The first tick fires immediately, so keys are loaded right after start
+	jwks := config.jwks
+	wrapper.RegisteTickFunc(int64(config.JWKS.RefreshInterval)*1000, func() {
+		jwks.refresh()
+	})
+	return nil
+}
+
+// refresh fetches the key set, keeping the previous keys when the fetch fails
+func (c *jwksCache) refresh() {
+	err := c.client.Get(c.uri, nil, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+		if statusCode != http.StatusOK {
+			proxywasm.LogWarnf("Failed to refresh JWKS from %s: status %d, keeping %d cached keys", c.uri, statusCode, c.keyCount())
+			return
+		}
+		var keySet jose.JSONWebKeySet
+		if err := json.Unmarshal(responseBody, &keySet); err != nil {
+			proxywasm.LogWarnf("Failed to parse JWKS from %s: %v, keeping %d cached keys", c.uri, err, c.keyCount())
+			return
+		}
+		if len(keySet.Keys) == 0 {
+			proxywasm.LogWarnf("JWKS from %s contains no keys, keeping %d cached keys", c.uri, c.keyCount())
+			return
+		}
+		c.keySet = &keySet
+		proxywasm.LogDebugf("Refreshed JWKS from %s, %d keys loaded", c.uri, len(keySet.Keys))
+	}, c.timeout)
+	if err != nil {
+		proxywasm.LogWarnf("Failed to dispatch JWKS refresh to %s: %v", c.uri, err)
+	}
+}
+
+func (c *jwksCache) keyCount() int {
+	if c.keySet == nil {
+		return 0
+	}
+	return len(c.keySet.Keys)
+}
+
+// verifyClaims verifies the token signature with the key matching its kid header
+// and extracts the claims into dest
+func (c *jwksCache) verifyClaims(token *jwt.JSONWebToken, dest interface{}) error {
+	if c.keySet == nil {
+		return errors.New("no JWKS keys loaded yet")
+	}
+	kid := ""
+	if len(token.Headers) > 0 {
+		kid = token.Headers[0].KeyID
+	}
+	var candidates []jose.JSONWebKey
+	if kid != "" {
+		candidates = c.keySet.Key(kid)
+		if len(candidates) == 0 {
+			return fmt.Errorf("no JWKS key found for kid %s", kid)
+		}
+	} else {
+		// Without a kid, try every key in the set
+		candidates = c.keySet.Keys
+	}
+	var lastErr error
+	for _, key := range candidates {
+		if lastErr = token.Claims(key.Key, dest); lastErr == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to verify JWT signature: %w", lastErr)
+}
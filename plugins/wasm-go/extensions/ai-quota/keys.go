@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/tidwall/gjson"
+)
+
+// Placeholders available in key templates
+const (
+	keyPlaceholderUser   = "{user}"
+	keyPlaceholderModel  = "{model}"
+	keyPlaceholderWindow = "{window}"
+	keyPlaceholderTeam   = "{team}"
+	keyPlaceholderGroup  = "{group}"
+	keyPlaceholderSource = "{source}"
+	keyPlaceholderOrg    = "{org}"
+	keyPlaceholderId     = "{id}"
+)
+
+var keyPlaceholders = []string{
+	keyPlaceholderUser, keyPlaceholderModel, keyPlaceholderWindow,
+	keyPlaceholderTeam, keyPlaceholderGroup, keyPlaceholderSource, keyPlaceholderOrg, keyPlaceholderId,
+}
+
+// keyParts are the values filled into the placeholders of a key template
+type keyParts struct {
+	user   string
+	model  string
+	window string
+	team   string
+	group  string
+	source string
+	org    string
+	id     string
+}
+
+// parseKeyTemplates reads the key templates, defaulting to the configured prefixes
+// followed by the ids the keys used before, so the existing key layout is kept. It runs
+// once every prefix has been parsed.
+func parseKeyTemplates(json gjson.Result, config *QuotaConfig) error {
+	templates := []struct {
+		name         string
+		target       *string
+		defaultValue string
+		required     []string
+		optional     []string
+	}{
+		{"total_key_template", &config.TotalKeyTemplate, config.RedisKeyPrefix + keyPlaceholderUser, []string{keyPlaceholderUser}, nil},
+		{"used_key_template", &config.UsedKeyTemplate, config.RedisUsedPrefix + keyPlaceholderUser, []string{keyPlaceholderUser}, nil},
+		{"star_key_template", &config.StarKeyTemplate, config.RedisStarPrefix + keyPlaceholderUser, []string{keyPlaceholderUser}, nil},
+		{"rate_limit_key_template", &config.RateLimitKeyTemplate, config.RedisRateLimitPrefix + keyPlaceholderUser + ":" + keyPlaceholderModel,
+			[]string{keyPlaceholderUser}, []string{keyPlaceholderModel, keyPlaceholderWindow}},
+		{"team_key_template", &config.TeamKeyTemplate, config.RedisTeamPrefix + keyPlaceholderTeam, []string{keyPlaceholderTeam}, nil},
+		{"team_used_key_template", &config.TeamUsedKeyTemplate, config.RedisTeamUsedPrefix + keyPlaceholderTeam, []string{keyPlaceholderTeam}, nil},
+		{"group_key_template", &config.GroupKeyTemplate, config.RedisGroupPrefix + keyPlaceholderGroup + ":" + keyPlaceholderUser,
+			[]string{keyPlaceholderGroup, keyPlaceholderUser}, nil},
+		{"group_used_key_template", &config.GroupUsedKeyTemplate, config.RedisGroupUsedPrefix + keyPlaceholderGroup + ":" + keyPlaceholderUser,
+			[]string{keyPlaceholderGroup, keyPlaceholderUser}, nil},
+		{"org_key_template", &config.OrgKeyTemplate, config.RedisOrgPrefix + keyPlaceholderOrg, []string{keyPlaceholderOrg}, nil},
+		{"org_used_key_template", &config.OrgUsedKeyTemplate, config.RedisOrgUsedPrefix + keyPlaceholderOrg, []string{keyPlaceholderOrg}, nil},
+		{"free_key_template", &config.FreeKeyTemplate, config.RedisFreePrefix + keyPlaceholderUser + ":" + keyPlaceholderWindow,
+			[]string{keyPlaceholderUser, keyPlaceholderWindow}, nil},
+		{"denied_models_key_template", &config.DeniedModelsKeyTemplate, config.RedisDeniedModelsPrefix + keyPlaceholderUser, []string{keyPlaceholderUser}, nil},
+		{"refill_key_template", &config.RefillKeyTemplate, config.RedisRefillPrefix + keyPlaceholderUser, []string{keyPlaceholderUser}, nil},
+		{"usage_log_key_template", &config.UsageLogKeyTemplate, config.RedisUsageLogPrefix + keyPlaceholderUser, []string{keyPlaceholderUser}, nil},
+		{"concurrency_key_template", &config.ConcurrencyKeyTemplate, config.RedisConcurrencyPrefix + keyPlaceholderModel, []string{keyPlaceholderModel}, nil},
+		{"token_failure_key_template", &config.TokenFailureKeyTemplate, config.RedisTokenFailurePrefix + keyPlaceholderSource, []string{keyPlaceholderSource}, nil},
+		{"selftest_key_template", &config.SelfTestKeyTemplate, "chat_quota_selftest:" + keyPlaceholderId, []string{keyPlaceholderId}, nil},
+	}
+	for _, t := range templates {
+		template := json.Get(t.name).String()
+		if template == "" {
+			template = t.defaultValue
+		}
+		for _, placeholder := range t.required {
+			if !strings.Contains(template, placeholder) {
+				return fmt.Errorf("%s must contain %s", t.name, placeholder)
+			}
+		}
+		for _, placeholder := range keyPlaceholders {
+			if strings.Contains(template, placeholder) && !containsString(t.required, placeholder) && !containsString(t.optional, placeholder) {
+				return fmt.Errorf("%s does not support %s", t.name, placeholder)
+			}
+		}
+		*t.target = template
+	}
+	if config.TotalKeyTemplate == config.UsedKeyTemplate {
+		return errors.New("total_key_template and used_key_template must differ")
+	}
+	if config.TeamKeyTemplate == config.TeamUsedKeyTemplate {
+		return errors.New("team_key_template and team_used_key_template must differ")
+	}
+	if config.GroupKeyTemplate == config.GroupUsedKeyTemplate {
+		return errors.New("group_key_template and group_used_key_template must differ")
+	}
+	if config.OrgKeyTemplate == config.OrgUsedKeyTemplate {
+		return errors.New("org_key_template and org_used_key_template must differ")
+	}
+	return nil
+}
+
+// buildKey is the single place Redis keys are built from templates
+func buildKey(template string, parts keyParts) string {
+	return strings.NewReplacer(
+		keyPlaceholderUser, parts.user,
+		keyPlaceholderModel, parts.model,
+		keyPlaceholderWindow, parts.window,
+		keyPlaceholderTeam, parts.team,
+		keyPlaceholderGroup, parts.group,
+		keyPlaceholderSource, parts.source,
+		keyPlaceholderOrg, parts.org,
+		keyPlaceholderId, parts.id,
+	).Replace(template)
+}
+
+func (c QuotaConfig) totalKey(userId string) string {
+	return buildKey(c.TotalKeyTemplate, keyParts{user: userId})
+}
+
+func (c QuotaConfig) usedKey(userId string) string {
+	return buildKey(c.UsedKeyTemplate, keyParts{user: userId})
+}
+
+func (c QuotaConfig) starKey(userId string) string {
+	return buildKey(c.StarKeyTemplate, keyParts{user: userId})
+}
+
+func (c QuotaConfig) rateLimitKey(userId string, modelName string, window int) string {
+	return buildKey(c.RateLimitKeyTemplate, keyParts{user: userId, model: modelName, window: strconv.Itoa(window)})
+}
+
+func (c QuotaConfig) teamKey(team string) string {
+	return buildKey(c.TeamKeyTemplate, keyParts{team: team})
+}
+
+func (c QuotaConfig) teamUsedKey(team string) string {
+	return buildKey(c.TeamUsedKeyTemplate, keyParts{team: team})
+}
+
+func (c QuotaConfig) groupKey(group string, userId string) string {
+	return buildKey(c.GroupKeyTemplate, keyParts{group: group, user: userId})
+}
+
+func (c QuotaConfig) groupUsedKey(group string, userId string) string {
+	return buildKey(c.GroupUsedKeyTemplate, keyParts{group: group, user: userId})
+}
+
+func (c QuotaConfig) orgKey(org string) string {
+	return buildKey(c.OrgKeyTemplate, keyParts{org: org})
+}
+
+func (c QuotaConfig) orgUsedKey(org string) string {
+	return buildKey(c.OrgUsedKeyTemplate, keyParts{org: org})
+}
+
+func (c QuotaConfig) deniedModelsKey(userId string) string {
+	return buildKey(c.DeniedModelsKeyTemplate, keyParts{user: userId})
+}
+
+func (c QuotaConfig) refillKey(userId string) string {
+	return buildKey(c.RefillKeyTemplate, keyParts{user: userId})
+}
+
+func (c QuotaConfig) usageLogKey(userId string) string {
+	return buildKey(c.UsageLogKeyTemplate, keyParts{user: userId})
+}
+
+func (c QuotaConfig) selfTestKey(id string) string {
+	return buildKey(c.SelfTestKeyTemplate, keyParts{id: id})
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
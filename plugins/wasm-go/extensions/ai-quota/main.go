@@ -1,14 +1,16 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/alibaba/higress/plugins/wasm-go/extensions/ai-quota/util"
 	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
@@ -57,7 +59,13 @@ type ModelsResponse struct {
 }
 
 // sendJSONResponse 发送JSON格式的响应
-func sendJSONResponse(statusCode uint32, code string, message string, success bool, data any) error {
+func (c QuotaConfig) sendJSONResponse(statusCode uint32, code string, message string, success bool, data any) error {
+	return sendJSONResponseWithRequestId(c.RequestIdHeader, statusCode, code, message, success, data)
+}
+
+// sendJSONResponseWithRequestId echoes the request id found in requestIdHeader, so users
+// can reference the request when reporting quota problems
+func sendJSONResponseWithRequestId(requestIdHeader string, statusCode uint32, code string, message string, success bool, data any) error {
 	response := ResponseData{
 		Code:    code,
 		Message: message,
@@ -68,6 +76,9 @@ func sendJSONResponse(statusCode uint32, code string, message string, success bo
 	if err != nil {
 		return err
 	}
+	if requestId, err := proxywasm.GetHttpRequestHeader(requestIdHeader); err == nil && requestId != "" {
+		return util.SendResponse(statusCode, code, util.MimeTypeApplicationJson, string(body), requestIdHeader, requestId)
+	}
 	return util.SendResponse(statusCode, code, util.MimeTypeApplicationJson, string(body))
 }
 
@@ -79,6 +90,13 @@ const (
 	ChatModeNone       ChatMode = "none"
 )
 
+type AuthMode string
+
+const (
+	AuthModeJWT  AuthMode = "jwt"
+	AuthModeMTLS AuthMode = "mtls"
+)
+
 type AdminMode string
 
 const (
@@ -90,52 +108,267 @@ const (
 	AdminModeUsedDelta   AdminMode = "used_delta"
 	AdminModeStarQuery   AdminMode = "star_query"
 	AdminModeStarSet     AdminMode = "star_set"
+	AdminModeConfig      AdminMode = "config"
+	AdminModeMetrics     AdminMode = "metrics"
+	AdminModeCost        AdminMode = "cost"
+	AdminModeBatchDeduct AdminMode = "batch_deduct"
+	AdminModeBulkRefresh AdminMode = "bulk_refresh"
+	AdminModeBulkDelta   AdminMode = "bulk_delta"
+	AdminModeRename      AdminMode = "rename"
+	AdminModeSelfTest    AdminMode = "selftest"
+	AdminModeMemory      AdminMode = "memory"
+	AdminModeUsageLog    AdminMode = "usage_log"
 	AdminModeNone        AdminMode = "none"
 )
 
 // AuthUser struct for parsing user info from JWT
 type AuthUser struct {
-	ID string `json:"universal_id"`
+	ID   string `json:"universal_id"`
+	Tier string `json:"-"` // resolved from the tier_claim claim
+	Org  string `json:"-"` // resolved from the org_claim claim
 }
 
 func main() {
 	wrapper.SetCtx(
 		pluginName,
 		wrapper.ParseConfigBy(parseConfig),
-		wrapper.ProcessRequestHeadersBy(onHttpRequestHeaders),
-		wrapper.ProcessRequestBodyBy(onHttpRequestBody),
+		wrapper.ProcessRequestHeadersBy(guardRequestHeaders(onHttpRequestHeaders)),
+		wrapper.ProcessRequestBodyBy(guardRequestBody(onHttpRequestBody)),
+		wrapper.ProcessResponseHeadersBy(onHttpResponseHeaders),
 		wrapper.ProcessStreamingResponseBodyBy(onHttpStreamingResponseBody),
+		wrapper.ProcessStreamDoneBy(onHttpStreamDone),
 	)
 }
 
 // ProviderConfig contains provider type and model mapping configuration
 type ProviderConfig struct {
-	Type         string            `yaml:"type"`         // Provider type (openai, qwen, claude, etc.)
-	ModelMapping map[string]string `yaml:"modelMapping"` // Model name mapping
+	Type         string            `yaml:"type" json:"type"`                 // Provider type (openai, qwen, claude, etc.)
+	ModelMapping map[string]string `yaml:"modelMapping" json:"modelMapping"` // Model name mapping
 }
 
 type QuotaConfig struct {
 	redisInfo         RedisInfo      `yaml:"redis"`
-	RedisKeyPrefix    string         `yaml:"redis_key_prefix"`
-	RedisUsedPrefix   string         `yaml:"redis_used_prefix"`
-	RedisStarPrefix   string         `yaml:"redis_star_prefix"`
-	CheckGithubStar   bool           `yaml:"check_github_star"`
-	TokenHeader       string         `yaml:"token_header"`
-	AdminHeader       string         `yaml:"admin_header"`
-	AdminKey          string         `yaml:"admin_key"`
-	AdminPath         string         `yaml:"admin_path"`
-	DeductHeader      string         `yaml:"deduct_header"`
-	DeductHeaderValue string         `yaml:"deduct_header_value"`
-	ModelQuotaWeights map[string]int `yaml:"model_quota_weights"`
+	RedisKeyPrefix    string         `yaml:"redis_key_prefix" json:"redis_key_prefix"`
+	RedisUsedPrefix   string         `yaml:"redis_used_prefix" json:"redis_used_prefix"`
+	RedisStarPrefix   string         `yaml:"redis_star_prefix" json:"redis_star_prefix"`
+	CheckGithubStar   bool           `yaml:"check_github_star" json:"check_github_star"`
+	TokenHeader       string         `yaml:"token_header" json:"token_header"`
+	AuthMode          AuthMode       `yaml:"auth_mode" json:"auth_mode"`
+	MTLSHeader        string         `yaml:"mtls_header" json:"mtls_header"`
+	MTLSUserField     string         `yaml:"mtls_user_field" json:"mtls_user_field"`
+	JWKS              JWKSConfig     `yaml:"jwks" json:"jwks"`
+	AdminHeader       string         `yaml:"admin_header" json:"admin_header"`
+	AdminKey          string         `yaml:"admin_key" json:"admin_key"`
+	AdminPath         string         `yaml:"admin_path" json:"admin_path"`
+	DeductHeader      string         `yaml:"deduct_header" json:"deduct_header"`
+	DeductHeaderValue string         `yaml:"deduct_header_value" json:"deduct_header_value"`
+	ModelQuotaWeights map[string]int `yaml:"model_quota_weights" json:"model_quota_weights"` // In quota units, see QuotaPrecision
+	// Weights taking effect at scheduled times, overriding ModelQuotaWeights once effective
+	ModelWeightSchedules map[string][]weightChange `yaml:"model_weight_schedules" json:"model_weight_schedules"`
+	// Models without a weight are priced like their base model, stripping version suffixes
+	ModelWeightFallback bool `yaml:"model_weight_fallback" json:"model_weight_fallback"`
+	// Multipliers applied to model weights by HTTP method, e.g. {"POST": 1, "PUT": 2}
+	MethodWeights map[string]float64 `yaml:"method_weights" json:"method_weights"`
+	// How fractional costs are rounded to whole quota units: ceil, floor or nearest
+	CostRounding string `yaml:"cost_rounding" json:"cost_rounding"`
+	// Pricing mode, weight or currency. Currency mode charges ModelPrices (micro-dollars) against a balance in dollars
+	PricingMode string         `yaml:"pricing_mode" json:"pricing_mode"`
+	ModelPrices map[string]int `yaml:"model_prices" json:"model_prices"`
+	// Paths the plugin ignores entirely; a trailing * matches by prefix
+	ExemptPaths []string `yaml:"exempt_paths" json:"exempt_paths"`
+	// Paths where requests with a missing or invalid token share the AnonymousQuotaUser quota
+	AllowAnonymousPaths []string `yaml:"allow_anonymous_paths" json:"allow_anonymous_paths"`
+	AnonymousQuotaUser  string   `yaml:"anonymous_quota_user" json:"anonymous_quota_user"`
+	// Paths of realtime session setups, charged once per session for the model named by
+	// the RealtimeModelParam query parameter
+	RealtimePaths      []string `yaml:"realtime_paths" json:"realtime_paths"`
+	RealtimeModelParam string   `yaml:"realtime_model_param" json:"realtime_model_param"`
+	// Reject completions whose body is not valid JSON instead of charging them nothing
+	RejectInvalidBody bool `yaml:"reject_invalid_body" json:"reject_invalid_body"`
+	// Rewrite the model of completion bodies to the model it maps to through modelMapping
+	RewriteMappedModel bool `yaml:"rewrite_mapped_model" json:"rewrite_mapped_model"`
+	// Model charged for completions without a model, also written into their body with InjectDefaultModel
+	DefaultModel       string `yaml:"default_model" json:"default_model"`
+	InjectDefaultModel bool   `yaml:"inject_default_model" json:"inject_default_model"`
+	// Upper bound for the weight charged per request, in quota units; 0 means no cap
+	MaxWeight int `yaml:"max_weight" json:"max_weight"`
+	// Requests costing more than this are rejected rather than capped, in quota units; 0 means no limit
+	MaxDeductionPerRequest int `yaml:"max_deduction_per_request" json:"max_deduction_per_request"`
+	// How requests for models without a weight are handled: free, block or log_only
+	UnknownModelPolicy string `yaml:"unknown_model_policy" json:"unknown_model_policy"`
+	// Quota per 1000 tokens of models billed by token usage, settled once the response ends
+	ModelTokenWeights map[string]int `yaml:"model_token_weights" json:"model_token_weights"`
+	// Separate quota per 1000 input and output tokens, for models whose output costs more
+	ModelTokenRates map[string]tokenRate `yaml:"model_token_rates" json:"model_token_rates"`
+	// Usage extraction strategy per provider type, openai or anthropic
+	UsageStrategies map[string]string `yaml:"usage_strategies" json:"usage_strategies"`
+	// Quota units every user may spend per UTC day before their paid quota is charged, 0 disables
+	FreeDailyAllowance int    `yaml:"free_daily_allowance" json:"free_daily_allowance"`
+	RedisFreePrefix    string `yaml:"redis_free_prefix" json:"redis_free_prefix"`
+	// Number of decimal places quota amounts carry; Redis stores them scaled by 10^QuotaPrecision
+	QuotaPrecision int `yaml:"quota_precision" json:"quota_precision"`
+	// Per-model request rate caps enforced in addition to quota weights
+	ModelRateLimits map[string]ModelRateLimit `yaml:"model_rate_limits" json:"model_rate_limits"`
+	// Global cap on in-flight requests per model across all users
+	ModelConcurrencyLimits map[string]int `yaml:"model_concurrency_limits" json:"model_concurrency_limits"`
+	RedisConcurrencyPrefix string         `yaml:"redis_concurrency_prefix" json:"redis_concurrency_prefix"`
+	RedisRateLimitPrefix   string         `yaml:"redis_rate_limit_prefix" json:"redis_rate_limit_prefix"`
+	// Models sharing one quota bucket per user, keyed by group name
+	ModelGroups          map[string][]string `yaml:"model_groups" json:"model_groups"`
+	RedisGroupPrefix     string              `yaml:"redis_group_prefix" json:"redis_group_prefix"`
+	RedisGroupUsedPrefix string              `yaml:"redis_group_used_prefix" json:"redis_group_used_prefix"`
+	modelGroupOf         map[string]string   `yaml:"-"`
+	// Consumers addressable by name in the query endpoints
+	Consumers     []Consumer        `yaml:"consumers" json:"consumers"`
+	consumerUsers map[string]string `yaml:"-"`
+	// Envoy cluster webhook calls are sent through, configured with the TLS settings of the
+	// endpoints, and whether webhook urls must use https
+	WebhookCluster      string `yaml:"webhook_cluster" json:"webhook_cluster"`
+	WebhookRequireHttps bool   `yaml:"webhook_require_https" json:"webhook_require_https"`
+	// Endpoint notified when a user runs out of quota, at most once per ExhaustionWebhookInterval seconds per user
+	ExhaustionWebhookUrl      string             `yaml:"exhaustion_webhook_url" json:"exhaustion_webhook_url"`
+	ExhaustionWebhookInterval int                `yaml:"exhaustion_webhook_interval" json:"exhaustion_webhook_interval"`
+	exhaustionWebhook         *exhaustionWebhook `yaml:"-"`
+	// Endpoint asked to top up a user whose remaining quota drops below AutoRefillThreshold
+	// after a deduction, at most once per AutoRefillInterval seconds per user
+	AutoRefillWebhookUrl string         `yaml:"auto_refill_webhook_url" json:"auto_refill_webhook_url"`
+	AutoRefillThreshold  int            `yaml:"auto_refill_threshold" json:"auto_refill_threshold"`
+	AutoRefillInterval   int            `yaml:"auto_refill_interval" json:"auto_refill_interval"`
+	RedisRefillPrefix    string         `yaml:"redis_refill_prefix" json:"redis_refill_prefix"`
+	refillWebhook        *refillWebhook `yaml:"-"`
+	// Deduct and admin headers are only honored from these sources when configured
+	TrustedProxy TrustedProxy `yaml:"trusted_proxy" json:"trusted_proxy"`
+	// Trusted requests may set their quota weight, up to WeightOverrideMax, with WeightOverrideHeader
+	WeightOverrideHeader string `yaml:"weight_override_header" json:"weight_override_header"`
+	WeightOverrideMax    int    `yaml:"weight_override_max" json:"weight_override_max"`
+	// Insufficient quota is answered with 402 and a top-up URL when enabled
+	PaymentRequired PaymentRequired `yaml:"payment_required" json:"payment_required"`
+	// Messages for models that can be denied per user through the denied models set
+	ModelDenyMessages       map[string]string `yaml:"model_deny_messages" json:"model_deny_messages"`
+	RedisDeniedModelsPrefix string            `yaml:"redis_denied_models_prefix" json:"redis_denied_models_prefix"`
+	// Key templates with placeholders for the ids in the key, defaulting to the prefix followed by them
+	TotalKeyTemplate        string `yaml:"total_key_template" json:"total_key_template"`
+	UsedKeyTemplate         string `yaml:"used_key_template" json:"used_key_template"`
+	StarKeyTemplate         string `yaml:"star_key_template" json:"star_key_template"`
+	RateLimitKeyTemplate    string `yaml:"rate_limit_key_template" json:"rate_limit_key_template"`
+	TeamKeyTemplate         string `yaml:"team_key_template" json:"team_key_template"`
+	TeamUsedKeyTemplate     string `yaml:"team_used_key_template" json:"team_used_key_template"`
+	GroupKeyTemplate        string `yaml:"group_key_template" json:"group_key_template"`
+	GroupUsedKeyTemplate    string `yaml:"group_used_key_template" json:"group_used_key_template"`
+	OrgKeyTemplate          string `yaml:"org_key_template" json:"org_key_template"`
+	OrgUsedKeyTemplate      string `yaml:"org_used_key_template" json:"org_used_key_template"`
+	FreeKeyTemplate         string `yaml:"free_key_template" json:"free_key_template"`
+	DeniedModelsKeyTemplate string `yaml:"denied_models_key_template" json:"denied_models_key_template"`
+	RefillKeyTemplate       string `yaml:"refill_key_template" json:"refill_key_template"`
+	UsageLogKeyTemplate     string `yaml:"usage_log_key_template" json:"usage_log_key_template"`
+	ConcurrencyKeyTemplate  string `yaml:"concurrency_key_template" json:"concurrency_key_template"`
+	TokenFailureKeyTemplate string `yaml:"token_failure_key_template" json:"token_failure_key_template"`
+	SelfTestKeyTemplate     string `yaml:"selftest_key_template" json:"selftest_key_template"`
+	// Team members draw from a shared pool, with their own total quota acting as an optional sub-limit
+	UserTeams           map[string]string `yaml:"user_teams" json:"user_teams"`
+	RedisTeamPrefix     string            `yaml:"redis_team_prefix" json:"redis_team_prefix"`
+	RedisTeamUsedPrefix string            `yaml:"redis_team_used_prefix" json:"redis_team_used_prefix"`
+	// Org members without a quota of their own inherit their org's shared quota. The org is
+	// read from the OrgClaim JWT claim or else from UserOrgs
+	UserOrgs           map[string]string `yaml:"user_orgs" json:"user_orgs"`
+	OrgClaim           string            `yaml:"org_claim" json:"org_claim"`
+	RedisOrgPrefix     string            `yaml:"redis_org_prefix" json:"redis_org_prefix"`
+	RedisOrgUsedPrefix string            `yaml:"redis_org_used_prefix" json:"redis_org_used_prefix"`
+	// Response header telling org members which level served the request
+	QuotaLevelHeader string `yaml:"quota_level_header" json:"quota_level_header"`
 	// Provider configuration for /ai-gateway/api/v1/models endpoint
-	Provider    ProviderConfig      `yaml:"provider"` // Provider configuration
-	redisClient wrapper.RedisClient `yaml:"-"`
-	starCache   map[string]bool     `yaml:"-"` // Simple star status cache
+	Provider       ProviderConfig `yaml:"provider" json:"provider"`               // Provider configuration
+	WildcardModels []string       `yaml:"wildcard_models" json:"wildcard_models"` // Models advertised when only wildcard mappings exist
+	// Transform the advertised model ids without touching the underlying mapping
+	ModelsIdStripPrefix string `yaml:"models_id_strip_prefix" json:"models_id_strip_prefix"`
+	ModelsIdAddPrefix   string `yaml:"models_id_add_prefix" json:"models_id_add_prefix"`
+	// Cache-Control for the models endpoint; when set, an ETag is sent and If-None-Match is honored
+	ModelsCacheControl string `yaml:"models_cache_control" json:"models_cache_control"`
+	// Models responses of at least this size are gzipped for clients accepting it, negative disables
+	ModelsGzipMinBytes int                 `yaml:"models_gzip_min_bytes" json:"models_gzip_min_bytes"`
+	redisClient        wrapper.RedisClient `yaml:"-"`
+	replicaClient      wrapper.RedisClient `yaml:"-"`
+	// Maintenance mode rejects all completion requests without touching Redis
+	MaintenanceMode    bool   `yaml:"maintenance_mode" json:"maintenance_mode"`
+	MaintenanceMessage string `yaml:"maintenance_message" json:"maintenance_message"`
+	// Replication acknowledgment for quota deductions, disabled when WriteAckReplicas is 0
+	WriteAckReplicas  int        `yaml:"write_ack_replicas" json:"write_ack_replicas"`
+	WriteAckTimeoutMs int        `yaml:"write_ack_timeout_ms" json:"write_ack_timeout_ms"`
+	StarCacheMaxSize  int        `yaml:"star_cache_max_size" json:"star_cache_max_size"`
+	starCache         *starCache `yaml:"-"` // LRU star status cache
+	// Cached star statuses expire after StarCacheTTL seconds (0 never expires), overridden per
+	// user tier read from the TierClaim JWT claim
+	StarCacheTTL      int            `yaml:"star_cache_ttl" json:"star_cache_ttl"`
+	StarCacheTierTTLs map[string]int `yaml:"star_cache_tier_ttls" json:"star_cache_tier_ttls"`
+	TierClaim         string         `yaml:"tier_claim" json:"tier_claim"`
+	jwks              *jwksCache     `yaml:"-"` // JWKS keys used to verify tokens, nil when verification is disabled
+	// Sources sending TokenFailureThreshold consecutive invalid tokens within TokenFailureWindow
+	// seconds are rejected with 429 for TokenFailureCooldown seconds, 0 disables the cooldown
+	TokenFailureThreshold   int            `yaml:"token_failure_threshold" json:"token_failure_threshold"`
+	TokenFailureWindow      int            `yaml:"token_failure_window" json:"token_failure_window"`
+	TokenFailureCooldown    int            `yaml:"token_failure_cooldown" json:"token_failure_cooldown"`
+	RedisTokenFailurePrefix string         `yaml:"redis_token_failure_prefix" json:"redis_token_failure_prefix"`
+	tokenFailures           *tokenFailures `yaml:"-"`
+	// Filter state properties the quota decision is written to for the access log
+	AccessLogMetadata AccessLogMetadata `yaml:"access_log_metadata" json:"access_log_metadata"`
+	// Quota decision written as one JSON object for downstream filters
+	DecisionMetadata DecisionMetadata `yaml:"decision_metadata" json:"decision_metadata"`
+	// Refresh operations must carry the expected current value and are only applied while
+	// the key still holds it
+	RequireCompareAndSet bool `yaml:"require_compare_and_set" json:"require_compare_and_set"`
+	// Delta operations are refused with a 404 for users without a total quota key
+	RequireExistingUser bool `yaml:"require_existing_user" json:"require_existing_user"`
+	// Admin request bodies larger than MaxAdminBodyBytes are rejected with a 413, 0 disables the limit
+	MaxAdminBodyBytes int `yaml:"max_admin_body_bytes" json:"max_admin_body_bytes"`
+	// Requests are let through unmetered while Redis is not ready within this many seconds after the config is loaded
+	WarmupGraceSeconds int   `yaml:"warmup_grace_seconds" json:"warmup_grace_seconds"`
+	warmupDeadline     int64 `yaml:"-"`
+	// Counter growth is added to the RedisMetricsKey hash every MetricsFlushIntervalMs, 0 disables it
+	MetricsFlushIntervalMs int    `yaml:"metrics_flush_interval_ms" json:"metrics_flush_interval_ms"`
+	RedisMetricsKey        string `yaml:"redis_metrics_key" json:"redis_metrics_key"`
+	// Paused requests are failed with a 503 after PauseTimeoutMs without a Redis callback
+	PauseTimeoutMs int `yaml:"pause_timeout_ms" json:"pause_timeout_ms"`
+	// Header carrying the request id, echoed in every response of the plugin
+	RequestIdHeader string `yaml:"request_id_header" json:"request_id_header"`
+	// Retries of failed Redis reads allowed per request, shared by all of its calls; 0 disables retries
+	RedisRetryBudget int `yaml:"redis_retry_budget" json:"redis_retry_budget"`
+	// Newest UsageLogSize charged requests kept per user for the usage log endpoint, 0 disables the log
+	UsageLogSize        int    `yaml:"usage_log_size" json:"usage_log_size"`
+	UsageLogTTL         int    `yaml:"usage_log_ttl" json:"usage_log_ttl"`
+	RedisUsageLogPrefix string `yaml:"redis_usage_log_prefix" json:"redis_usage_log_prefix"`
+	// Streams cancelled by the client are refunded the share of the charge that wasn't delivered,
+	// measured against max_tokens of the request or RefundReservedTokens without one
+	RefundOnCancel       bool `yaml:"refund_on_cancel" json:"refund_on_cancel"`
+	RefundReservedTokens int  `yaml:"refund_reserved_tokens" json:"refund_reserved_tokens"`
+	// Strategy run alongside the read-then-write quota check to count disagreements, never enforced
+	ShadowStrategy string `yaml:"shadow_strategy" json:"shadow_strategy"`
+	// Configs of the tenants sharing the plugin, keyed by host or by the TenantHeader value
+	TenantHeader string                  `yaml:"tenant_header" json:"tenant_header"`
+	Tenants      map[string]*QuotaConfig `yaml:"-" json:"-"`
+	isTenant     bool                    `yaml:"-"`
+	// Replace user ids in logs, access log metadata and error responses with a salted hash
+	MaskUserIds     bool   `yaml:"mask_user_ids" json:"mask_user_ids"`
+	MaskUserIdsSalt string `yaml:"mask_user_ids_salt" json:"-"`
+	// Fraction of requests, selected by request id, that log their quota decision path verbosely
+	DebugSampleRate float64     `yaml:"debug_sample_rate" json:"debug_sample_rate"`
+	pauseGuard      *pauseGuard `yaml:"-"`
+	// Unauthenticated health probe path, disabled when empty
+	HealthPath            string         `yaml:"health_path" json:"health_path"`
+	HealthCheckIntervalMs int            `yaml:"health_check_interval_ms" json:"health_check_interval_ms"`
+	health                *healthChecker `yaml:"-"`
+}
+
+// ModelRateLimit caps the number of requests a user can send to a model within a window
+type ModelRateLimit struct {
+	Requests int `yaml:"requests" json:"requests"`
+	Window   int `yaml:"window" json:"window"` // seconds
 }
 
 type Consumer struct {
-	Name       string `yaml:"name"`
-	Credential string `yaml:"credential"`
+	Name       string `yaml:"name" json:"name"`
+	Credential string `yaml:"credential" json:"-"`
+	// User id the consumer's quota is stored under
+	UserId string `yaml:"user_id" json:"user_id"`
 }
 
 type RedisInfo struct {
@@ -145,10 +378,25 @@ type RedisInfo struct {
 	Password    string `required:"false" yaml:"password" json:"password"`
 	Timeout     int    `required:"false" yaml:"timeout" json:"timeout"`
 	Database    int    `required:"false" yaml:"database" json:"database"`
+	// Highest database index the server offers, databases beyond it are rejected at config time
+	MaxDatabase int `required:"false" yaml:"max_database" json:"max_database"`
+	MaxInFlight int `required:"false" yaml:"max_in_flight" json:"max_in_flight"`
+	// Backends tried in order when the primary is unreachable
+	Failover []RedisBackend `required:"false" yaml:"failover" json:"failover"`
+	// Backend serving the read-only query endpoints
+	Replica *RedisBackend `required:"false" yaml:"replica" json:"replica,omitempty"`
+}
+
+type RedisBackend struct {
+	ServiceName string `yaml:"service_name" json:"service_name"`
+	ServicePort int    `yaml:"service_port" json:"service_port"`
 }
 
 func parseConfig(json gjson.Result, config *QuotaConfig, log wrapper.Log) error {
 	log.Debugf("parse config()")
+	if err := parseTenantConfigs(json, config, log); err != nil {
+		return err
+	}
 
 	// admin path
 	config.AdminPath = json.Get("admin_path").String()
@@ -162,6 +410,31 @@ func parseConfig(json gjson.Result, config *QuotaConfig, log wrapper.Log) error
 		config.TokenHeader = "authorization"
 	}
 
+	// auth mode, jwt by default
+	config.AuthMode = AuthMode(json.Get("auth_mode").String())
+	if config.AuthMode == "" {
+		config.AuthMode = AuthModeJWT
+	}
+	if config.AuthMode != AuthModeJWT && config.AuthMode != AuthModeMTLS {
+		return fmt.Errorf("invalid auth_mode: %s, must be jwt or mtls", config.AuthMode)
+	}
+
+	// client certificate header injected by the proxy and the field holding the user id
+	config.MTLSHeader = json.Get("mtls_header").String()
+	if config.MTLSHeader == "" {
+		config.MTLSHeader = "x-forwarded-client-cert"
+	}
+
+	config.MTLSUserField = json.Get("mtls_user_field").String()
+	if config.MTLSUserField == "" {
+		config.MTLSUserField = "CN"
+	}
+
+	// JWKS signature verification
+	if err := parseJWKSConfig(json, config); err != nil {
+		return err
+	}
+
 	// admin header name and key
 	config.AdminHeader = json.Get("admin_header").String()
 	if config.AdminHeader == "" {
@@ -184,14 +457,129 @@ func parseConfig(json gjson.Result, config *QuotaConfig, log wrapper.Log) error
 		config.DeductHeaderValue = "user"
 	}
 
-	// Parse model quota weights
+	if err := parsePricingMode(json, config); err != nil {
+		return err
+	}
+	config.QuotaPrecision = int(json.Get("quota_precision").Int())
+	if config.PricingMode == PricingModeCurrency {
+		config.QuotaPrecision = currencyPrecision
+	}
+	if config.QuotaPrecision < 0 || config.QuotaPrecision > maxQuotaPrecision {
+		return fmt.Errorf("quota_precision must be between 0 and %d", maxQuotaPrecision)
+	}
+
+	if err := parseFreeAllowanceConfig(json, config); err != nil {
+		return err
+	}
+
+	if maxWeight := json.Get("max_weight"); maxWeight.Exists() {
+		var err error
+		config.MaxWeight, err = config.parseQuota(strconv.FormatFloat(maxWeight.Float(), 'f', -1, 64))
+		if err != nil {
+			return fmt.Errorf("invalid max_weight: %v", err)
+		}
+		if config.MaxWeight < 0 {
+			return errors.New("max_weight must not be negative")
+		}
+	}
+	if maxDeduction := json.Get("max_deduction_per_request"); maxDeduction.Exists() {
+		var err error
+		config.MaxDeductionPerRequest, err = config.parseQuota(strconv.FormatFloat(maxDeduction.Float(), 'f', -1, 64))
+		if err != nil {
+			return fmt.Errorf("invalid max_deduction_per_request: %v", err)
+		}
+		if config.MaxDeductionPerRequest < 0 {
+			return errors.New("max_deduction_per_request must not be negative")
+		}
+	}
+
+	// Parse model quota weights, converting them into quota units
 	config.ModelQuotaWeights = make(map[string]int)
-	modelWeights := json.Get("model_quota_weights")
-	if modelWeights.Exists() {
-		modelWeights.ForEach(func(key, value gjson.Result) bool {
-			config.ModelQuotaWeights[key.String()] = int(value.Int())
-			return true
-		})
+	var weightErr error
+	json.Get("model_quota_weights").ForEach(func(key, value gjson.Result) bool {
+		weight, err := config.parseQuota(strconv.FormatFloat(value.Float(), 'f', -1, 64))
+		if err != nil {
+			weightErr = fmt.Errorf("invalid quota weight for model %s: %v", key.String(), err)
+			return false
+		}
+		// A negative weight would add quota back on every request
+		if weight < 0 {
+			weightErr = fmt.Errorf("quota weight for model %s must not be negative", key.String())
+			return false
+		}
+		if weight == 0 {
+			log.Warnf("Model %s has a zero quota weight, its requests will not be charged", key.String())
+		}
+		if config.MaxWeight > 0 && weight > config.MaxWeight {
+			log.Warnf("Quota weight %s for model %s exceeds max_weight, capping it at %s",
+				config.formatQuota(weight), key.String(), config.formatQuota(config.MaxWeight))
+			weight = config.MaxWeight
+		}
+		config.ModelQuotaWeights[key.String()] = weight
+		return true
+	})
+	if weightErr != nil {
+		return weightErr
+	}
+	if err := parseModelPrices(json, config); err != nil {
+		return err
+	}
+	if err := parseWeightSchedules(json, config); err != nil {
+		return err
+	}
+	config.ModelWeightFallback = json.Get("model_weight_fallback").Bool()
+	if err := parseUsageConfig(json, config); err != nil {
+		return err
+	}
+	if err := parseUnknownModelPolicy(json, config); err != nil {
+		return err
+	}
+
+	// Parse HTTP method multipliers
+	config.MethodWeights = make(map[string]float64)
+	var methodWeightErr error
+	json.Get("method_weights").ForEach(func(key, value gjson.Result) bool {
+		if value.Float() < 0 {
+			methodWeightErr = fmt.Errorf("method weight for %s must not be negative", key.String())
+			return false
+		}
+		config.MethodWeights[strings.ToUpper(key.String())] = value.Float()
+		return true
+	})
+	if methodWeightErr != nil {
+		return methodWeightErr
+	}
+	config.CostRounding = json.Get("cost_rounding").String()
+	if config.CostRounding == "" {
+		config.CostRounding = CostRoundingCeil
+	}
+	if config.CostRounding != CostRoundingCeil && config.CostRounding != CostRoundingFloor && config.CostRounding != CostRoundingNearest {
+		return fmt.Errorf("invalid cost_rounding: %s, must be ceil, floor or nearest", config.CostRounding)
+	}
+
+	// Parse model rate limits
+	config.ModelRateLimits = make(map[string]ModelRateLimit)
+	var rateLimitErr error
+	json.Get("model_rate_limits").ForEach(func(key, value gjson.Result) bool {
+		rateLimit := ModelRateLimit{
+			Requests: int(value.Get("requests").Int()),
+			Window:   int(value.Get("window").Int()),
+		}
+		if rateLimit.Window == 0 {
+			rateLimit.Window = 60
+		}
+		if rateLimit.Requests <= 0 || rateLimit.Window < 0 {
+			rateLimitErr = fmt.Errorf("invalid model_rate_limits for model %s: requests and window must be positive", key.String())
+			return false
+		}
+		config.ModelRateLimits[key.String()] = rateLimit
+		return true
+	})
+	if rateLimitErr != nil {
+		return rateLimitErr
+	}
+	if err := parseModelConcurrencyLimits(json, config); err != nil {
+		return err
 	}
 
 	// Parse provider configuration
@@ -219,6 +607,21 @@ func parseConfig(json gjson.Result, config *QuotaConfig, log wrapper.Log) error
 		config.Provider.ModelMapping = make(map[string]string)
 	}
 
+	// Parse models advertised for wildcard-only mappings
+	config.WildcardModels = make([]string, 0)
+	for _, model := range json.Get("wildcard_models").Array() {
+		if modelName := model.String(); modelName != "" {
+			config.WildcardModels = append(config.WildcardModels, modelName)
+		}
+	}
+	config.ModelsIdStripPrefix = json.Get("models_id_strip_prefix").String()
+	config.ModelsIdAddPrefix = json.Get("models_id_add_prefix").String()
+	config.ModelsCacheControl = json.Get("models_cache_control").String()
+	config.ModelsGzipMinBytes = defaultModelsGzipMinBytes
+	if minBytes := json.Get("models_gzip_min_bytes"); minBytes.Exists() {
+		config.ModelsGzipMinBytes = int(minBytes.Int())
+	}
+
 	// Redis
 	config.RedisKeyPrefix = json.Get("redis_key_prefix").String()
 	if config.RedisKeyPrefix == "" {
@@ -234,11 +637,154 @@ func parseConfig(json gjson.Result, config *QuotaConfig, log wrapper.Log) error
 	if config.RedisStarPrefix == "" {
 		config.RedisStarPrefix = "chat_quota_star:"
 	}
+	// Total, used and star counters sharing a prefix would overwrite each other
+	quotaPrefixes := []struct{ name, value string }{
+		{"redis_key_prefix", config.RedisKeyPrefix},
+		{"redis_used_prefix", config.RedisUsedPrefix},
+		{"redis_star_prefix", config.RedisStarPrefix},
+	}
+	for i, a := range quotaPrefixes {
+		for _, b := range quotaPrefixes[i+1:] {
+			if a.value == b.value {
+				return fmt.Errorf("%s and %s must be distinct, both are %q", a.name, b.name, a.value)
+			}
+		}
+	}
+
+	config.RedisRateLimitPrefix = json.Get("redis_rate_limit_prefix").String()
+	if config.RedisRateLimitPrefix == "" {
+		config.RedisRateLimitPrefix = "chat_quota_rate:"
+	}
+
+	parseTeamConfig(json, config)
+	parseOrgConfig(json, config)
+	parseModelDenyConfig(json, config)
+	if err := parseModelGroups(json, config); err != nil {
+		return err
+	}
+	if err := parsePaymentRequired(json, config); err != nil {
+		return err
+	}
+	parseWebhookTLS(json, config)
+	if err := parseExhaustionWebhook(json, config); err != nil {
+		return err
+	}
+	if err := parseAutoRefillWebhook(json, config); err != nil {
+		return err
+	}
+	if err := parseConsumers(json, config); err != nil {
+		return err
+	}
+	if err := parseTrustedProxy(json, config); err != nil {
+		return err
+	}
+	if err := parseWeightOverrideConfig(json, config); err != nil {
+		return err
+	}
 
 	config.CheckGithubStar = json.Get("check_github_star").Bool()
 
-	// Initialize simple star cache
-	config.starCache = make(map[string]bool)
+	config.ExemptPaths = make([]string, 0)
+	for _, exemptPath := range json.Get("exempt_paths").Array() {
+		if exemptPath.String() != "" {
+			config.ExemptPaths = append(config.ExemptPaths, exemptPath.String())
+		}
+	}
+
+	parseAnonymousConfig(json, config)
+	parseRealtimeConfig(json, config)
+	if err := parseAccessLogMetadata(json, config); err != nil {
+		return err
+	}
+	parseDecisionMetadata(json, config)
+	if err := parseTokenFailureConfig(json, config); err != nil {
+		return err
+	}
+	config.RequireExistingUser = json.Get("require_existing_user").Bool()
+	config.RequireCompareAndSet = json.Get("require_compare_and_set").Bool()
+
+	config.RewriteMappedModel = json.Get("rewrite_mapped_model").Bool()
+	config.DefaultModel = json.Get("default_model").String()
+	config.InjectDefaultModel = json.Get("inject_default_model").Bool()
+	if config.InjectDefaultModel && config.DefaultModel == "" {
+		return errors.New("inject_default_model requires default_model")
+	}
+	config.RejectInvalidBody = true
+	if rejectInvalidBody := json.Get("reject_invalid_body"); rejectInvalidBody.Exists() {
+		config.RejectInvalidBody = rejectInvalidBody.Bool()
+	}
+
+	config.MaintenanceMode = json.Get("maintenance_mode").Bool()
+	config.MaintenanceMessage = json.Get("maintenance_message").String()
+	if config.MaintenanceMessage == "" {
+		config.MaintenanceMessage = "The service is under maintenance, please try again later."
+	}
+
+	config.WriteAckReplicas = int(json.Get("write_ack_replicas").Int())
+	if config.WriteAckReplicas < 0 {
+		return errors.New("write_ack_replicas must not be negative")
+	}
+	config.WriteAckTimeoutMs = int(json.Get("write_ack_timeout_ms").Int())
+	if config.WriteAckTimeoutMs <= 0 {
+		config.WriteAckTimeoutMs = 100
+	}
+
+	// Initialize bounded star cache
+	config.StarCacheMaxSize = 10000
+	if starCacheMaxSize := json.Get("star_cache_max_size"); starCacheMaxSize.Exists() {
+		config.StarCacheMaxSize = int(starCacheMaxSize.Int())
+	}
+	if config.StarCacheMaxSize < 0 {
+		return errors.New("star_cache_max_size must not be negative")
+	}
+	config.starCache = newStarCache(config.StarCacheMaxSize)
+	if err := parseStarCacheTTLConfig(json, config); err != nil {
+		return err
+	}
+
+	config.RequestIdHeader = strings.ToLower(json.Get("request_id_header").String())
+	if config.RequestIdHeader == "" {
+		config.RequestIdHeader = "x-request-id"
+	}
+	config.PauseTimeoutMs = int(json.Get("pause_timeout_ms").Int())
+	if config.PauseTimeoutMs <= 0 {
+		config.PauseTimeoutMs = 5000
+	}
+	// Tenants share the guard of the root config, see withPauseGuard
+	if !config.isTenant {
+		config.pauseGuard = newPauseGuard(int64(config.PauseTimeoutMs), config.RequestIdHeader)
+	}
+
+	if err := parseMaxAdminBodyConfig(json, config); err != nil {
+		return err
+	}
+	if err := parseWarmupConfig(json, config); err != nil {
+		return err
+	}
+
+	if err := parseUserMaskConfig(json, config); err != nil {
+		return err
+	}
+	if err := parseRetryBudgetConfig(json, config); err != nil {
+		return err
+	}
+	if err := parseUsageLogConfig(json, config); err != nil {
+		return err
+	}
+	if err := parseRefundConfig(json, config); err != nil {
+		return err
+	}
+	if err := parseShadowConfig(json, config); err != nil {
+		return err
+	}
+	if err := parseKeyTemplates(json, config); err != nil {
+		return err
+	}
+
+	config.DebugSampleRate = json.Get("debug_sample_rate").Float()
+	if config.DebugSampleRate < 0 || config.DebugSampleRate > 1 {
+		return errors.New("debug_sample_rate must be between 0 and 1")
+	}
 
 	redisConfig := json.Get("redis")
 	if !redisConfig.Exists() {
@@ -264,31 +810,84 @@ func parseConfig(json gjson.Result, config *QuotaConfig, log wrapper.Log) error
 		timeout = 1000
 	}
 	database := int(redisConfig.Get("database").Int())
+	maxDatabase := 15
+	if value := redisConfig.Get("max_database"); value.Exists() {
+		maxDatabase = int(value.Int())
+		if maxDatabase < 0 {
+			return errors.New("redis max_database must not be negative")
+		}
+	}
+	// The connection would only fail once SELECT is refused, with a less telling error
+	if database < 0 || database > maxDatabase {
+		return fmt.Errorf("redis database %d is out of the allowed range 0-%d, raise redis max_database if the server has more databases", database, maxDatabase)
+	}
+	maxInFlight := int(redisConfig.Get("max_in_flight").Int())
+	if maxInFlight < 0 {
+		return errors.New("redis max_in_flight must not be negative")
+	}
 	config.redisInfo.ServiceName = serviceName
 	config.redisInfo.ServicePort = servicePort
 	config.redisInfo.Username = username
 	config.redisInfo.Password = password
 	config.redisInfo.Timeout = timeout
 	config.redisInfo.Database = database
+	config.redisInfo.MaxDatabase = maxDatabase
+	config.redisInfo.MaxInFlight = maxInFlight
 	config.redisClient = wrapper.NewRedisClusterClient(wrapper.FQDNCluster{
 		FQDN: serviceName,
 		Port: int64(servicePort),
 	})
 
-	return config.redisClient.Init(username, password, int64(timeout), wrapper.WithDataBase(database))
+	var failoverClusters []wrapper.Cluster
+	for _, backendConfig := range redisConfig.Get("failover").Array() {
+		backend := RedisBackend{
+			ServiceName: backendConfig.Get("service_name").String(),
+			ServicePort: int(backendConfig.Get("service_port").Int()),
+		}
+		if backend.ServiceName == "" {
+			return errors.New("redis failover service name must not be empty")
+		}
+		if backend.ServicePort == 0 {
+			if strings.HasSuffix(backend.ServiceName, ".static") {
+				backend.ServicePort = 80
+			} else {
+				backend.ServicePort = 6379
+			}
+		}
+		config.redisInfo.Failover = append(config.redisInfo.Failover, backend)
+		failoverClusters = append(failoverClusters, wrapper.FQDNCluster{
+			FQDN: backend.ServiceName,
+			Port: int64(backend.ServicePort),
+		})
+	}
+
+	if err := parseReplicaConfig(redisConfig, config, username, password, timeout, database); err != nil {
+		return err
+	}
+	if err := parseHealthConfig(json, config); err != nil {
+		return err
+	}
+	if err := parseMetricsFlushConfig(json, config); err != nil {
+		return err
+	}
+
+	return config.redisClient.Init(username, password, int64(timeout), wrapper.WithDataBase(database), wrapper.WithMaxInFlight(maxInFlight), wrapper.WithFailover(failoverClusters...))
 }
 
-// parseUserInfoFromToken parses user info from JWT token
-func parseUserInfoFromToken(accessToken string) (*AuthUser, error) {
-	// use ParseSigned method to parse JWT token without signature verification
+// parseUserInfoFromToken parses user info from JWT token, verifying its signature when JWKS is configured
+func parseUserInfoFromToken(accessToken string, jwks *jwksCache, tierClaim string, orgClaim string) (*AuthUser, error) {
 	token, err := jwt.ParseSigned(accessToken)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse JWT token: %w", err)
 	}
 
-	// get unverified claims
 	var customClaims map[string]interface{}
-	err = token.UnsafeClaimsWithoutVerification(&customClaims)
+	if jwks != nil {
+		err = jwks.verifyClaims(token, &customClaims)
+	} else {
+		// get unverified claims
+		err = token.UnsafeClaimsWithoutVerification(&customClaims)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract claims: %w", err)
 	}
@@ -303,16 +902,38 @@ func parseUserInfoFromToken(accessToken string) (*AuthUser, error) {
 	if err := json.Unmarshal(jsonBytes, &userInfo); err != nil {
 		return nil, fmt.Errorf("failed to deserialize user info: %w", err)
 	}
+	if tier, ok := customClaims[tierClaim].(string); ok {
+		userInfo.Tier = tier
+	}
+	if org, ok := customClaims[orgClaim].(string); ok && orgClaim != "" {
+		userInfo.Org = org
+	}
 
 	return &userInfo, nil
 }
 
 func onHttpRequestHeaders(context wrapper.HttpContext, config QuotaConfig, log wrapper.Log) types.Action {
+	config = resolveTenantConfig(context, config, log)
+	// Exempt paths skip the plugin entirely, before any other work
+	if config.isExemptPath(context.Path()) {
+		decisionMetrics.Exempted++
+		context.DontReadRequestBody()
+		return types.ActionContinue
+	}
+	ensureRequestId(context, config, log)
+	log = sampleLog(context, config, log)
 	log.Debugf("onHttpRequestHeaders()")
+	stripUntrustedHeaders(config, log)
 
 	rawPath := context.Path()
 	path, _ := url.Parse(rawPath)
 
+	if config.HealthPath != "" && path.Path == config.HealthPath {
+		context.DontReadRequestBody()
+		respondHealth(config)
+		return types.ActionContinue
+	}
+
 	// Handle /ai-gateway/api/v1/models request locally first
 	if path.Path == "/ai-gateway/api/v1/models" {
 		log.Debugf("[onHttpRequestHeaders] handling /ai-gateway/api/v1/models request locally")
@@ -322,7 +943,7 @@ func onHttpRequestHeaders(context wrapper.HttpContext, config QuotaConfig, log w
 		responseBody, err := config.BuildModelsResponse()
 		if err != nil {
 			log.Errorf("failed to build models response: %v", err)
-			_ = sendJSONResponse(500, "ai-quota.build_models_failed", "Failed to build models response", false, nil)
+			_ = config.sendJSONResponse(500, "ai-quota.build_models_failed", "Failed to build models response", false, nil)
 			return types.ActionContinue
 		}
 
@@ -330,18 +951,53 @@ func onHttpRequestHeaders(context wrapper.HttpContext, config QuotaConfig, log w
 		headers := [][2]string{
 			{"content-type", "application/json"},
 		}
-		err = proxywasm.SendHttpResponse(200, headers, responseBody, -1)
+		if requestId := context.GetStringContext("requestId", ""); requestId != "" {
+			headers = append(headers, [2]string{config.RequestIdHeader, requestId})
+		}
+		log.Debugf("[onHttpRequestHeaders] models response: %s", string(responseBody))
+		etag := modelsResponseETag(responseBody)
+		if config.ModelsGzipMinBytes >= 0 {
+			headers = append(headers, [2]string{"vary", "accept-encoding"})
+		}
+		acceptEncoding, _ := proxywasm.GetHttpRequestHeader("accept-encoding")
+		if config.shouldGzipModels(responseBody, acceptEncoding) {
+			compressed, err := gzipBytes(responseBody)
+			if err != nil {
+				log.Warnf("failed to gzip models response, sending it uncompressed: %v", err)
+			} else {
+				log.Debugf("[onHttpRequestHeaders] gzipped models response from %d to %d bytes", len(responseBody), len(compressed))
+				headers = append(headers, [2]string{"content-encoding", "gzip"})
+				responseBody = compressed
+				// The compressed representation needs its own validator
+				etag = strings.TrimSuffix(etag, `"`) + `-gzip"`
+			}
+		}
+		statusCode := uint32(http.StatusOK)
+		if config.ModelsCacheControl != "" {
+			headers = append(headers, [2]string{"cache-control", config.ModelsCacheControl}, [2]string{"etag", etag})
+			ifNoneMatch, _ := proxywasm.GetHttpRequestHeader("if-none-match")
+			if etagMatches(ifNoneMatch, etag) {
+				statusCode = http.StatusNotModified
+				responseBody = nil
+			}
+		}
+		err = proxywasm.SendHttpResponse(statusCode, headers, responseBody, -1)
 		if err != nil {
 			log.Errorf("failed to send response: %v", err)
-			_ = sendJSONResponse(500, "ai-quota.send_models_response_failed", "Failed to send models response", false, nil)
+			_ = config.sendJSONResponse(500, "ai-quota.send_models_response_failed", "Failed to send models response", false, nil)
 			return types.ActionContinue
 		}
 
-		log.Debugf("[onHttpRequestHeaders] models response sent: %s", string(responseBody))
+		log.Debugf("[onHttpRequestHeaders] models response sent with status %d", statusCode)
 		return types.ActionContinue
 	}
 
 	chatMode, adminMode := getOperationMode(path.Path, config.AdminPath, log)
+	// Realtime sessions name their model when they are set up, not per message
+	if chatMode == ChatModeNone && matchesPath(config.RealtimePaths, rawPath) {
+		chatMode = ChatModeCompletion
+		context.SetContext("realtimeModel", path.Query().Get(config.RealtimeModelParam))
+	}
 	context.SetContext("chatMode", chatMode)
 	context.SetContext("adminMode", adminMode)
 	log.Debugf("chatMode:%s, adminMode:%s", chatMode, adminMode)
@@ -354,15 +1010,38 @@ func onHttpRequestHeaders(context wrapper.HttpContext, config QuotaConfig, log w
 		// for admin operations, check admin header and key
 		adminKey, err := proxywasm.GetHttpRequestHeader(config.AdminHeader)
 		if err != nil || adminKey != config.AdminKey {
-			sendJSONResponse(http.StatusForbidden, "ai-gateway.unauthorized", "Request denied by ai quota check. Unauthorized admin operation.", false, nil)
+			config.sendJSONResponse(http.StatusForbidden, "ai-gateway.unauthorized", "Request denied by ai quota check. Unauthorized admin operation.", false, nil)
 			return types.ActionContinue
 		}
 
+		if adminMode == AdminModeConfig {
+			return queryEffectiveConfig(config, log)
+		}
+		if adminMode == AdminModeMetrics {
+			return queryMetrics(config, log)
+		}
+		if adminMode == AdminModeSelfTest {
+			return selfTest(config, log)
+		}
+		if adminMode == AdminModeMemory {
+			return queryMemoryUsage(config, path, log)
+		}
+		if adminMode == AdminModeUsageLog {
+			return queryUsageLog(config, path, log)
+		}
+		if adminMode == AdminModeCost {
+			return queryCost(config, path, log)
+		}
+
 		// query quota, used quota or star status
 		if adminMode == AdminModeQuery || adminMode == AdminModeUsedQuery || adminMode == AdminModeStarQuery {
 			return queryQuota(context, config, path, adminMode, log)
 		}
-		if adminMode == AdminModeRefresh || adminMode == AdminModeDelta || adminMode == AdminModeUsedRefresh || adminMode == AdminModeUsedDelta || adminMode == AdminModeStarSet {
+		if adminMode == AdminModeRefresh || adminMode == AdminModeDelta || adminMode == AdminModeUsedRefresh || adminMode == AdminModeUsedDelta || adminMode == AdminModeStarSet || adminMode == AdminModeBatchDeduct ||
+			adminMode == AdminModeBulkRefresh || adminMode == AdminModeBulkDelta || adminMode == AdminModeRename {
+			if rejectOversizedAdminBody(config) {
+				return types.ActionContinue
+			}
 			context.BufferRequestBody()
 			return types.HeaderStopIteration
 		}
@@ -370,40 +1049,132 @@ func onHttpRequestHeaders(context wrapper.HttpContext, config QuotaConfig, log w
 	}
 
 	// for completion mode, need to get userId from token and read request body to extract model
+	if config.AuthMode == AuthModeMTLS {
+		userId, ok := resolveUserFromClientCert(config, log)
+		if !ok {
+			return types.ActionContinue
+		}
+		context.SetContext("userId", userId)
+		return readCompletionBody(context, config, log)
+	}
+
+	if rejectTokenCooldown(config, log) {
+		return types.ActionContinue
+	}
+
 	// get token
 	tokenHeader, err := proxywasm.GetHttpRequestHeader(config.TokenHeader)
 	if err != nil || tokenHeader == "" {
-		sendJSONResponse(http.StatusUnauthorized, "ai-gateway.no_token", "Request denied by ai quota check. No token found.", false, nil)
+		if useAnonymousUser(context, config, "no token", log) {
+			return readCompletionBody(context, config, log)
+		}
+		config.sendJSONResponse(http.StatusUnauthorized, "ai-gateway.no_token", "Request denied by ai quota check. No token found.", false, nil)
 		return types.ActionContinue
 	}
 
 	// extract token (remove Bearer prefix etc.)
 	token := extractTokenFromHeader(tokenHeader)
 	if token == "" {
-		sendJSONResponse(http.StatusUnauthorized, "ai-gateway.invalid_token", "Request denied by ai quota check. Invalid token format.", false, nil)
-		return types.ActionContinue
+		if useAnonymousUser(context, config, "an invalid token", log) {
+			return readCompletionBody(context, config, log)
+		}
+		return rejectInvalidToken(config, "ai-gateway.invalid_token", "Invalid token format.", log)
 	}
 
 	// parse token to get userId
-	userInfo, err := parseUserInfoFromToken(token)
+	userInfo, err := parseUserInfoFromToken(token, config.jwks, config.TierClaim, config.OrgClaim)
 	if err != nil {
 		log.Warnf("Failed to parse token: %v", err)
-		sendJSONResponse(http.StatusUnauthorized, "ai-gateway.token_parse_failed", "Request denied by ai quota check. Token parse failed.", false, nil)
-		return types.ActionContinue
+		if useAnonymousUser(context, config, "an unparseable token", log) {
+			return readCompletionBody(context, config, log)
+		}
+		return rejectInvalidToken(config, "ai-gateway.token_parse_failed", "Token parse failed.", log)
 	}
 
 	if userInfo.ID == "" {
-		sendJSONResponse(http.StatusUnauthorized, "ai-gateway.no_userid", "Request denied by ai quota check. No user ID found in token.", false, nil)
-		return types.ActionContinue
+		if useAnonymousUser(context, config, "no user id in its token", log) {
+			return readCompletionBody(context, config, log)
+		}
+		return rejectInvalidToken(config, "ai-gateway.no_userid", "No user ID found in token.", log)
 	}
+	resetTokenFailures(config, log)
 
 	context.SetContext("userId", userInfo.ID)
+	context.SetContext("userTier", userInfo.Tier)
+	context.SetContext("userOrg", userInfo.Org)
 
 	// Buffer request body to extract model info
-	// Note: ai-proxy plugin (priority 100) may have already buffered the request body
-	// This call is safe and won't conflict with existing buffering
-	context.BufferRequestBody()
-	return types.HeaderStopIteration
+	return readCompletionBody(context, config, log)
+}
+
+// resolveUserFromClientCert extracts the user id from the verified client certificate
+// details injected by the proxy, sending an error response when it can't be resolved
+func resolveUserFromClientCert(config QuotaConfig, log wrapper.Log) (string, bool) {
+	certHeader, err := proxywasm.GetHttpRequestHeader(config.MTLSHeader)
+	if err != nil || certHeader == "" {
+		config.sendJSONResponse(http.StatusUnauthorized, "ai-gateway.no_client_cert", "Request denied by ai quota check. No client certificate found.", false, nil)
+		return "", false
+	}
+
+	userId := extractUserFromClientCert(certHeader, config.MTLSUserField)
+	if userId == "" {
+		log.Warnf("Failed to extract %s from client certificate: %s", config.MTLSUserField, certHeader)
+		config.sendJSONResponse(http.StatusUnauthorized, "ai-gateway.no_userid", "Request denied by ai quota check. No user ID found in client certificate.", false, nil)
+		return "", false
+	}
+	return userId, true
+}
+
+// extractUserFromClientCert reads a field from an x-forwarded-client-cert style header.
+// The field is either a top level key (e.g. URI, DNS, Hash) or an attribute of the
+// certificate subject (e.g. CN, OU). The last element is used since it was appended
+// by the proxy closest to this gateway.
+func extractUserFromClientCert(header string, field string) string {
+	elements := splitQuoted(header, ',')
+	if len(elements) == 0 {
+		return ""
+	}
+	pairs := make(map[string]string)
+	for _, pair := range splitQuoted(elements[len(elements)-1], ';') {
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			continue
+		}
+		pairs[strings.ToLower(strings.TrimSpace(key))] = strings.Trim(strings.TrimSpace(value), "\"")
+	}
+	if value, ok := pairs[strings.ToLower(field)]; ok {
+		return value
+	}
+	for _, attribute := range splitQuoted(pairs["subject"], ',') {
+		key, value, found := strings.Cut(attribute, "=")
+		if found && strings.EqualFold(strings.TrimSpace(key), field) {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+// splitQuoted splits s by sep, ignoring separators inside double quotes
+func splitQuoted(s string, sep rune) []string {
+	var parts []string
+	var current strings.Builder
+	inQuotes := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(r)
+		case r == sep && !inQuotes:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
 }
 
 // extractTokenFromHeader extracts token from header
@@ -417,6 +1188,8 @@ func extractTokenFromHeader(header string) string {
 }
 
 func onHttpRequestBody(ctx wrapper.HttpContext, config QuotaConfig, body []byte, log wrapper.Log) types.Action {
+	config = tenantConfig(ctx, config)
+	log = sampleLog(ctx, config, log)
 	log.Debugf("onHttpRequestBody()")
 	chatMode, ok := ctx.GetContext("chatMode").(ChatMode)
 	if !ok {
@@ -437,6 +1210,12 @@ func onHttpRequestBody(ctx wrapper.HttpContext, config QuotaConfig, body []byte,
 		return types.ActionContinue
 	}
 
+	// Bodies sent without content-length are only known once buffered
+	if config.adminBodyTooLarge(len(body)) {
+		sendAdminBodyTooLarge(config)
+		return types.ActionContinue
+	}
+
 	if adminMode == AdminModeRefresh {
 		return refreshQuota(ctx, config, string(body), log)
 	}
@@ -452,43 +1231,74 @@ func onHttpRequestBody(ctx wrapper.HttpContext, config QuotaConfig, body []byte,
 	if adminMode == AdminModeStarSet {
 		return setStarStatus(ctx, config, string(body), log)
 	}
+	if adminMode == AdminModeBatchDeduct {
+		return batchDeductQuota(ctx, config, string(body), log)
+	}
+	if adminMode == AdminModeBulkRefresh {
+		return bulkRefreshQuota(config, string(body), log)
+	}
+	if adminMode == AdminModeBulkDelta {
+		return bulkDeltaQuota(config, string(body), log)
+	}
+	if adminMode == AdminModeRename {
+		return renameUser(config, string(body), log)
+	}
 
 	return types.ActionContinue
 }
 
 func handleCompletionQuota(ctx wrapper.HttpContext, config QuotaConfig, body []byte, log wrapper.Log) types.Action {
+	// Reject completions during planned maintenance instead of letting them through unmetered
+	if config.MaintenanceMode {
+		log.Debugf("Maintenance mode is enabled, rejecting completion request")
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.maintenance", config.MaintenanceMessage, false, nil)
+		return types.ActionContinue
+	}
+
 	// Get user ID from context first
 	userId, ok := ctx.GetContext("userId").(string)
 	if !ok {
-		sendJSONResponse(http.StatusUnauthorized, "ai-gateway.no_userid", "Request denied by ai quota check. No user ID found.", false, nil)
+		config.sendJSONResponse(http.StatusUnauthorized, "ai-gateway.no_userid", "Request denied by ai quota check. No user ID found.", false, nil)
+		return types.ActionContinue
+	}
+
+	// Fail open during the warm-up grace period while Redis is not ready yet
+	if config.inWarmupGrace() {
+		log.Warnf("Redis is not ready during warm-up, allowing request of user %s without quota check", maskUserId(userId))
+		decisionMetrics.WarmupGraceAllowed++
+		emitQuotaDecision(config, quotaDecision{userId: userId, decision: QuotaDecisionWarmupGrace}, log)
 		return types.ActionContinue
 	}
 
 	// Check GitHub star status first if enabled
 	if config.CheckGithubStar {
-		log.Debugf("GitHub star check is enabled, checking star status for user: %s", userId)
+		log.Debugf("GitHub star check is enabled, checking star status for user: %s", maskUserId(userId))
+		// Users authenticated without a tier claim use the default star cache TTL
+		tier, _ := ctx.GetContext("userTier").(string)
 
 		// First check local cache
 		if cached, hasStar := config.checkStarCache(userId); cached {
-			log.Debugf("Star status found in cache for user %s: %t", userId, hasStar)
+			log.Debugf("Star status found in cache for user %s: %t", maskUserId(userId), hasStar)
 			if hasStar {
-				log.Debugf("User %s has starred the project (cached), proceeding with quota check", userId)
+				log.Debugf("User %s has starred the project (cached), proceeding with quota check", maskUserId(userId))
 				// Star check passed, continue with quota logic
 				processQuotaLogic(ctx, config, body, userId, log)
 			} else {
-				log.Debugf("User %s has not starred the project (cached)", userId)
-				sendJSONResponse(http.StatusForbidden, "ai-gateway.star_required", "Please star the project first: https://github.com/zgsm-ai/zgsm", false, nil)
+				log.Debugf("User %s has not starred the project (cached)", maskUserId(userId))
+				decisionMetrics.DeniedStar++
+				emitQuotaDecision(config, quotaDecision{userId: userId, decision: QuotaDecisionDeniedStar}, log)
+				config.sendJSONResponse(http.StatusForbidden, "ai-gateway.star_required", "Please star the project first: https://github.com/zgsm-ai/zgsm", false, nil)
 			}
 			return types.ActionPause
 		}
 
 		// Cache miss, check Redis
-		log.Debugf("Star status not in cache, checking Redis for user: %s", userId)
-		starKey := config.RedisStarPrefix + userId
+		log.Debugf("Star status not in cache, checking Redis for user: %s", maskUserId(userId))
+		starKey := config.starKey(userId)
 		config.redisClient.Get(starKey, func(starResponse resp.Value) {
 			// Check if there's a Redis error
 			if err := starResponse.Error(); err != nil {
-				log.Warnf("Redis error when checking star status for user %s: %v. Allowing request to pass through.", userId, err)
+				log.Warnf("Redis error when checking star status for user %s: %v. Allowing request to pass through.", maskUserId(userId), err)
 				// Redis error - allow request to pass through for better user experience
 				processQuotaLogic(ctx, config, body, userId, log)
 				return
@@ -497,21 +1307,23 @@ func handleCompletionQuota(ctx wrapper.HttpContext, config QuotaConfig, body []b
 			// No Redis error, check the actual value
 			hasStar := false
 			if !starResponse.IsNull() && starResponse.String() == "true" {
-				log.Debugf("User %s has starred the project (from Redis)", userId)
+				log.Debugf("User %s has starred the project (from Redis)", maskUserId(userId))
 				hasStar = true
 			} else {
-				log.Debugf("User %s has not starred the project (confirmed from Redis)", userId)
+				log.Debugf("User %s has not starred the project (confirmed from Redis)", maskUserId(userId))
 			}
 
 			// Only cache true status
 			if hasStar {
-				config.setStarCache(userId, hasStar)
-				log.Debugf("Cached star status for user %s: %t", userId, hasStar)
+				config.setStarCache(userId, tier, hasStar)
+				log.Debugf("Cached star status for user %s: %t", maskUserId(userId), hasStar)
 				// Star check passed, continue with quota logic
 				processQuotaLogic(ctx, config, body, userId, log)
 			} else {
-				log.Debugf("User %s has not starred, not caching false status", userId)
-				sendJSONResponse(http.StatusForbidden, "ai-gateway.star_required", "Please star the project first: https://github.com/zgsm-ai/zgsm", false, nil)
+				log.Debugf("User %s has not starred, not caching false status", maskUserId(userId))
+				decisionMetrics.DeniedStar++
+				emitQuotaDecision(config, quotaDecision{userId: userId, decision: QuotaDecisionDeniedStar}, log)
+				config.sendJSONResponse(http.StatusForbidden, "ai-gateway.star_required", "Please star the project first: https://github.com/zgsm-ai/zgsm", false, nil)
 			}
 		})
 		return types.ActionPause
@@ -523,71 +1335,190 @@ func handleCompletionQuota(ctx wrapper.HttpContext, config QuotaConfig, body []b
 }
 
 func processQuotaLogic(ctx wrapper.HttpContext, config QuotaConfig, body []byte, userId string, log wrapper.Log) types.Action {
+	// gjson reads nothing from malformed JSON, which would make the request free
+	if config.RejectInvalidBody && !isRealtime(ctx) && !gjson.ValidBytes(body) {
+		log.Warnf("Rejecting completion request of user %s with an invalid JSON body", maskUserId(userId))
+		config.sendJSONResponse(http.StatusBadRequest, "quota-check.invalid_body", "Request body is not valid JSON", false, nil)
+		return types.ActionContinue
+	}
+
 	// Extract model from request body
-	modelName := gjson.GetBytes(body, "model").String()
+	modelName := requestModelName(ctx, body)
 	log.Debugf("Extracted model name: %s", modelName)
+	if modelName == "" && config.DefaultModel != "" {
+		modelName = config.DefaultModel
+		if !isRealtime(ctx) {
+			body = injectDefaultModel(config, body, log)
+		}
+	}
 
-	// Get quota weight for this model, default to 0 if not configured
-	quotaWeight := 0
-	if weight, exists := config.ModelQuotaWeights[modelName]; exists {
+	quotaWeight := resolveQuotaWeight(config, modelName, ctx.Method(), log)
+	// A trusted orchestrator may know the real cost better than the weight table
+	if weight, exists, err := config.weightOverride(); err != nil {
+		log.Warnf("Rejecting request of user %s: %v", maskUserId(userId), err)
+		config.sendJSONResponse(http.StatusBadRequest, "quota-check.invalid_weight_override", err.Error(), false, nil)
+		return types.ActionContinue
+	} else if exists {
+		log.Debugf("Quota weight of model %s overridden from %s to %s", modelName, config.formatQuota(quotaWeight), config.formatQuota(weight))
 		quotaWeight = weight
+	} else if _, _, priced := config.modelWeight(modelName); !priced {
+		if !allowUnknownModel(config, userId, modelName, log) {
+			return types.ActionContinue
+		}
 	}
-
 	log.Debugf("Model %s quota weight: %d", modelName, quotaWeight)
+	if rejectOversizedDeduction(config, userId, modelName, quotaWeight, log) {
+		return types.ActionContinue
+	}
+	// Pre-flight requests get the decision instead of being proxied, nothing is deducted
+	if checkOnlyRequested() {
+		if message, exists := config.ModelDenyMessages[modelName]; exists {
+			lookupModelDenied(config, userId, modelName, log, func(denied bool) {
+				if denied {
+					sendCheckOnlyDenied(config, userId, modelName, quotaWeight, message, log)
+					return
+				}
+				checkQuotaOnly(ctx, config, userId, quotaWeight, modelName, log)
+			})
+			return types.ActionPause
+		}
+		return checkQuotaOnly(ctx, config, userId, quotaWeight, modelName, log)
+	}
+	recordReservedTokens(ctx, config, body)
+
+	// Rewrite while the body is still being processed, weights keep using the requested model
+	if !isRealtime(ctx) {
+		rewriteMappedModel(config, body, modelName, log)
+	}
+
+	// Denied models are rejected with their own message before any counting
+	if message, exists := config.ModelDenyMessages[modelName]; exists {
+		checkModelDenied(ctx, config, userId, modelName, message, log, func() {
+			enforceModelQuota(ctx, config, userId, quotaWeight, modelName, log)
+		})
+		return types.ActionPause
+	}
+
+	return enforceModelQuota(ctx, config, userId, quotaWeight, modelName, log)
+}
+
+// enforceModelQuota applies the model's rate cap and concurrency limit, then deducts its weight
+func enforceModelQuota(ctx wrapper.HttpContext, config QuotaConfig, userId string, quotaWeight int, modelName string, log wrapper.Log) types.Action {
+	// Enforce the model's request rate cap before touching the quota
+	if rateLimit, exists := config.ModelRateLimits[modelName]; exists {
+		checkModelRateLimit(ctx, config, userId, modelName, rateLimit, log, func() {
+			applyModelConcurrency(ctx, config, userId, quotaWeight, modelName, log)
+		})
+		return types.ActionPause
+	}
+
+	return applyModelConcurrency(ctx, config, userId, quotaWeight, modelName, log)
+}
 
+// applyQuotaWeight deducts the model's weight from the user's quota, resuming directly for zero weight models
+func applyQuotaWeight(ctx wrapper.HttpContext, config QuotaConfig, userId string, quotaWeight int, modelName string, log wrapper.Log) types.Action {
 	// If quota weight is 0, no deduction needed, allow request to continue
 	if quotaWeight == 0 {
 		log.Debugf("Model %s has zero quota weight, skipping quota check", modelName)
-		proxywasm.ResumeHttpRequest()
+		decisionMetrics.ZeroWeightSkipped++
+		emitQuotaDecision(config, quotaDecision{userId: userId, modelName: modelName, decision: QuotaDecisionZeroWeight}, log)
+		resumeHttpRequest(ctx, config)
 		return types.ActionContinue
 	}
 
+	// The free daily allowance is drawn down before the paid quota
+	if config.FreeDailyAllowance > 0 {
+		checkFreeAllowance(ctx, config, userId, quotaWeight, modelName, log, func() {
+			deductPaidQuota(ctx, config, userId, quotaWeight, modelName, log)
+		})
+		return types.ActionPause
+	}
+
+	deductPaidQuota(ctx, config, userId, quotaWeight, modelName, log)
+	return types.ActionPause
+}
+
+// deductPaidQuota deducts the weight from the team pool or the user's own quota
+func deductPaidQuota(ctx wrapper.HttpContext, config QuotaConfig, userId string, quotaWeight int, modelName string, log wrapper.Log) {
+	// Team members are checked against the team pool and their own sub-limit
+	if team, exists := config.UserTeams[userId]; exists {
+		doTeamQuotaCheck(ctx, config, userId, team, quotaWeight, modelName, log)
+		return
+	}
+
+	// Org members fall back to the org's quota when they have none of their own
+	if org, exists := userOrg(ctx, config, userId); exists {
+		doOrgQuotaCheck(ctx, config, userId, org, quotaWeight, modelName, log)
+		return
+	}
+
 	// Check and deduct quota
 	doQuotaCheck(ctx, config, userId, quotaWeight, modelName, log)
-	return types.ActionPause
+}
+
+// modelRateLimitScript counts a request in the current fixed window, refusing to
+// count it once the limit is reached. Returns {allowed, count, ttl}.
+const modelRateLimitScript = `
+	local count = tonumber(redis.call('get', KEYS[1])) or 0
+	local limit = tonumber(ARGV[1])
+	if count >= limit then
+		return {0, count, redis.call('ttl', KEYS[1])}
+	end
+	count = redis.call('incr', KEYS[1])
+	if count == 1 then
+		redis.call('expire', KEYS[1], ARGV[2])
+	end
+	return {1, count, redis.call('ttl', KEYS[1])}
+`
+
+// checkModelRateLimit atomically counts the request against the per-user-per-model rate limit,
+// calling next when the request is allowed and rejecting it with 429 otherwise
+func checkModelRateLimit(ctx wrapper.HttpContext, config QuotaConfig, userId string, modelName string, rateLimit ModelRateLimit, log wrapper.Log, next func()) {
+	rateKey := config.rateLimitKey(userId, modelName, rateLimit.Window)
+	err := config.redisClient.Eval(modelRateLimitScript, 1, []interface{}{rateKey}, []interface{}{rateLimit.Requests, rateLimit.Window}, func(response resp.Value) {
+		if wrapper.IsRedisErrorResponse(response) || len(response.Array()) < 3 {
+			// Redis error - the rate cap is best effort, quota is still enforced
+			log.Warnf("Failed to check rate limit for user %s, model %s: %v. Allowing request to pass through.", maskUserId(userId), modelName, wrapper.GetRedisErrorFromResponse(response))
+			next()
+			return
+		}
+		result := response.Array()
+		if result[0].Integer() != 1 {
+			log.Warnf("Rate limit exceeded for user %s, model %s: %d requests per %ds", maskUserId(userId), modelName, rateLimit.Requests, rateLimit.Window)
+			config.sendJSONResponse(http.StatusTooManyRequests, "quota-check.model_rate_limited",
+				fmt.Sprintf("Rate limit exceeded for model %s. Limit: %d requests per %d seconds, retry after %d seconds", modelName, rateLimit.Requests, rateLimit.Window, result[2].Integer()), false, nil)
+			return
+		}
+		log.Debugf("Rate limit check passed for user %s, model %s: %d/%d", maskUserId(userId), modelName, result[1].Integer(), rateLimit.Requests)
+		next()
+	})
+	if err != nil {
+		log.Warnf("Failed to dispatch rate limit check for user %s, model %s: %v. Allowing request to pass through.", maskUserId(userId), modelName, err)
+		next()
+	}
 }
 
 func doQuotaCheck(ctx wrapper.HttpContext, config QuotaConfig, userId string, quotaWeight int, modelName string, log wrapper.Log) {
-	totalKey := config.RedisKeyPrefix + userId
-	usedKey := config.RedisUsedPrefix + userId
-
-	// Check if we need to deduct quota based on header
-	deductHeaderValue, err := proxywasm.GetHttpRequestHeader(config.DeductHeader)
-	shouldDeduct := err == nil && deductHeaderValue == config.DeductHeaderValue
-
-	// Use enhanced error handling with retries for critical quota operations
-	retryConfig := wrapper.RetryConfig{
-		MaxRetries:    2, // Limit retries for latency-sensitive operations
-		InitialDelay:  50 * time.Millisecond,
-		MaxDelay:      500 * time.Millisecond,
-		BackoffFactor: 2.0,
-		EnableJitter:  true,
-	}
-
-	if shouldDeduct {
-		// For now, use regular get operations until AtomicQuotaCheck is implemented
-		config.redisClient.Get(totalKey, func(totalResponse resp.Value) {
-			handleTotalQuotaResponseWithRetry(ctx, config, usedKey, totalResponse, userId, quotaWeight, modelName, log, retryConfig)
-		})
-	} else {
-		// Use regular GET for quota checking
-		config.redisClient.Get(totalKey, func(totalResponse resp.Value) {
-			handleTotalQuotaResponseWithRetry(ctx, config, usedKey, totalResponse, userId, quotaWeight, modelName, log, retryConfig)
-		})
+	// Grouped models share the group's counters instead of the user's own
+	totalKey := config.modelTotalKey(userId, modelName)
+	usedKey := config.modelUsedKey(userId, modelName)
+	startShadowCheck(ctx, config, userId, totalKey, usedKey, quotaWeight, modelName, log)
+
+	// Reads are retried within the request's retry budget
+	err := retryingGet(ctx, config, userId, totalKey, log, func(totalResponse resp.Value) {
+		handleTotalQuotaResponseWithRetry(ctx, config, usedKey, totalResponse, userId, quotaWeight, modelName, log)
+	})
+	if err != nil {
+		log.Errorf("Failed to dispatch total quota read for user %s: %v", maskUserId(userId), err)
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
 	}
 }
 
-func handleTotalQuotaResponseWithRetry(ctx wrapper.HttpContext, config QuotaConfig, usedKey string, totalResponse resp.Value, userId string, quotaWeight int, modelName string, log wrapper.Log, retryConfig wrapper.RetryConfig) {
+func handleTotalQuotaResponseWithRetry(ctx wrapper.HttpContext, config QuotaConfig, usedKey string, totalResponse resp.Value, userId string, quotaWeight int, modelName string, log wrapper.Log) {
 	if wrapper.IsRedisErrorResponse(totalResponse) {
 		redisErr := wrapper.GetRedisErrorFromResponse(totalResponse)
-		log.Errorf("Failed to get total quota for user %s: %v", userId, redisErr)
-
-		// Check if it's a retryable error
-		if wrapper.IsRetryableError(redisErr) {
-			log.Warnf("Retryable error encountered, quota check will be retried for user %s", userId)
-		}
-
-		sendJSONResponse(http.StatusForbidden, "quota-check.total_quota_error",
+		log.Errorf("Failed to get total quota for user %s: %v", maskUserId(userId), redisErr)
+		config.sendJSONResponse(http.StatusForbidden, "quota-check.total_quota_error",
 			fmt.Sprintf("Failed to retrieve total quota: %s", redisErr.Error()), false, nil)
 		return
 	}
@@ -600,41 +1531,40 @@ func handleTotalQuotaResponseWithRetry(ctx wrapper.HttpContext, config QuotaConf
 	if totalQuotaStr != "" {
 		totalQuota, parseErr = strconv.Atoi(totalQuotaStr)
 		if parseErr != nil {
-			log.Errorf("Invalid total quota format for user %s: %s", userId, totalQuotaStr)
-			sendJSONResponse(http.StatusInternalServerError, "quota-check.invalid_total_quota",
+			log.Errorf("Invalid total quota format for user %s: %s", maskUserId(userId), totalQuotaStr)
+			config.sendJSONResponse(http.StatusInternalServerError, "quota-check.invalid_total_quota",
 				"Invalid total quota format", false, nil)
 			return
 		}
 
 		// Validate that total quota is non-negative
 		if totalQuota < 0 {
-			log.Errorf("Invalid total quota value for user %s: %d (cannot be negative)", userId, totalQuota)
-			sendJSONResponse(http.StatusInternalServerError, "quota-check.invalid_total_quota",
+			log.Errorf("Invalid total quota value for user %s: %d (cannot be negative)", maskUserId(userId), totalQuota)
+			config.sendJSONResponse(http.StatusInternalServerError, "quota-check.invalid_total_quota",
 				"Invalid total quota value", false, nil)
 			return
 		}
 	} else {
 		// Key doesn't exist or is empty, log for monitoring
-		log.Infof("No total quota found for user %s (key does not exist or is empty), defaulting to 0", userId)
+		log.Infof("No total quota found for user %s (key does not exist or is empty), defaulting to 0", maskUserId(userId))
 	}
 
 	// Get used quota
-	config.redisClient.Get(usedKey, func(usedResponse resp.Value) {
+	err := retryingGet(ctx, config, userId, usedKey, log, func(usedResponse resp.Value) {
 		handleUsedQuotaResponseWithRetry(ctx, config, usedResponse, userId, quotaWeight, modelName, totalQuota, log)
 	})
+	if err != nil {
+		log.Errorf("Failed to dispatch used quota read for user %s: %v", maskUserId(userId), err)
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+	}
 }
 
 func handleUsedQuotaResponseWithRetry(ctx wrapper.HttpContext, config QuotaConfig, usedResponse resp.Value, userId string, quotaWeight int, modelName string, totalQuota int, log wrapper.Log) {
 	if wrapper.IsRedisErrorResponse(usedResponse) {
 		redisErr := wrapper.GetRedisErrorFromResponse(usedResponse)
-		log.Errorf("Failed to get used quota for user %s: %v", userId, redisErr)
+		log.Errorf("Failed to get used quota for user %s: %v", maskUserId(userId), redisErr)
 
-		// Check if it's a retryable error
-		if wrapper.IsRetryableError(redisErr) {
-			log.Warnf("Retryable error encountered, used quota check will be retried for user %s", userId)
-		}
-
-		sendJSONResponse(http.StatusForbidden, "quota-check.used_quota_error",
+		config.sendJSONResponse(http.StatusForbidden, "quota-check.used_quota_error",
 			fmt.Sprintf("Failed to retrieve used quota: %s", redisErr.Error()), false, nil)
 		return
 	}
@@ -647,16 +1577,16 @@ func handleUsedQuotaResponseWithRetry(ctx wrapper.HttpContext, config QuotaConfi
 		var parseErr error
 		usedQuota, parseErr = strconv.Atoi(usedQuotaStr)
 		if parseErr != nil {
-			log.Errorf("Invalid used quota format for user %s: %s", userId, usedQuotaStr)
-			sendJSONResponse(http.StatusInternalServerError, "quota-check.invalid_used_quota",
+			log.Errorf("Invalid used quota format for user %s: %s", maskUserId(userId), usedQuotaStr)
+			config.sendJSONResponse(http.StatusInternalServerError, "quota-check.invalid_used_quota",
 				"Invalid used quota format", false, nil)
 			return
 		}
 
 		// Validate that used quota is non-negative
 		if usedQuota < 0 {
-			log.Errorf("Invalid used quota value for user %s: %d (cannot be negative)", userId, usedQuota)
-			sendJSONResponse(http.StatusInternalServerError, "quota-check.invalid_used_quota",
+			log.Errorf("Invalid used quota value for user %s: %d (cannot be negative)", maskUserId(userId), usedQuota)
+			config.sendJSONResponse(http.StatusInternalServerError, "quota-check.invalid_used_quota",
 				"Invalid used quota value", false, nil)
 			return
 		}
@@ -665,11 +1595,11 @@ func handleUsedQuotaResponseWithRetry(ctx wrapper.HttpContext, config QuotaConfi
 		// (Allow some tolerance for concurrent operations)
 		if usedQuota > totalQuota+quotaWeight {
 			log.Warnf("Used quota (%d) significantly exceeds total quota (%d) for user %s. This may indicate data inconsistency.",
-				usedQuota, totalQuota, userId)
+				usedQuota, totalQuota, maskUserId(userId))
 		}
 	} else {
 		// Key doesn't exist or is empty, log for monitoring
-		log.Infof("No used quota found for user %s (key does not exist or is empty), defaulting to 0", userId)
+		log.Infof("No used quota found for user %s (key does not exist or is empty), defaulting to 0", maskUserId(userId))
 	}
 
 	// Calculate remaining quota
@@ -677,27 +1607,31 @@ func handleUsedQuotaResponseWithRetry(ctx wrapper.HttpContext, config QuotaConfi
 
 	// Log quota status for debugging
 	log.Debugf("Quota status for user %s: total=%d, used=%d, remaining=%d, required=%d",
-		userId, totalQuota, usedQuota, remainingQuota, quotaWeight)
+		maskUserId(userId), totalQuota, usedQuota, remainingQuota, quotaWeight)
 
 	// Check if sufficient quota is available
+	recordPrimaryDecision(ctx, config, remainingQuota >= quotaWeight, remainingQuota, log)
 	if remainingQuota >= quotaWeight {
 		// Use regular IncrBy for quota deduction
-		usedKey := config.RedisUsedPrefix + userId
+		usedKey := config.modelUsedKey(userId, modelName)
 		config.redisClient.IncrBy(usedKey, quotaWeight, func(incrResponse resp.Value) {
-			handleQuotaDeductionResponse(ctx, incrResponse, userId, quotaWeight, modelName, remainingQuota, log)
+			handleQuotaDeductionResponse(ctx, config, incrResponse, userId, quotaWeight, modelName, remainingQuota, log)
 		})
 	} else {
-		log.Warnf("Insufficient quota for user %s: remaining=%d, required=%d", userId, remainingQuota, quotaWeight)
-		sendJSONResponse(http.StatusForbidden, "quota-check.insufficient_quota",
-			fmt.Sprintf("Insufficient quota. Required: %d, Available: %d", quotaWeight, remainingQuota), false, nil)
+		log.Warnf("Insufficient quota for user %s: remaining=%d, required=%d", maskUserId(userId), remainingQuota, quotaWeight)
+		decisionMetrics.DeniedInsufficientQuota++
+		emitQuotaDecision(config, quotaDecision{userId: userId, modelName: modelName, weight: quotaWeight,
+			remaining: remainingQuota, hasRemaining: true, decision: QuotaDecisionInsufficientQuota}, log)
+		sendInsufficientQuota(config, userId, modelName, "quota-check.insufficient_quota",
+			fmt.Sprintf("Insufficient quota. Required: %s, Available: %s", config.formatQuota(quotaWeight), config.formatQuota(remainingQuota)))
 	}
 }
 
-func handleQuotaDeductionResponse(ctx wrapper.HttpContext, incrResponse resp.Value, userId string, quotaWeight int, modelName string, remainingQuota int, log wrapper.Log) {
+func handleQuotaDeductionResponse(ctx wrapper.HttpContext, config QuotaConfig, incrResponse resp.Value, userId string, quotaWeight int, modelName string, remainingQuota int, log wrapper.Log) {
 	if wrapper.IsRedisErrorResponse(incrResponse) {
 		redisErr := wrapper.GetRedisErrorFromResponse(incrResponse)
-		log.Errorf("Failed to deduct quota for user %s: %v", userId, redisErr)
-		sendJSONResponse(http.StatusInternalServerError, "quota-check.deduction_failed",
+		log.Errorf("Failed to deduct quota for user %s: %v", maskUserId(userId), redisErr)
+		config.sendJSONResponse(http.StatusInternalServerError, "quota-check.deduction_failed",
 			fmt.Sprintf("Quota deduction failed: %s", redisErr.Error()), false, nil)
 		return
 	}
@@ -708,8 +1642,8 @@ func handleQuotaDeductionResponse(ctx wrapper.HttpContext, incrResponse resp.Val
 	// Sanity check: the new used quota should be reasonable
 	if newUsedQuota < quotaWeight {
 		log.Errorf("Unexpected used quota after deduction for user %s: got %d, expected at least %d",
-			userId, newUsedQuota, quotaWeight)
-		sendJSONResponse(http.StatusInternalServerError, "quota-check.deduction_inconsistent",
+			maskUserId(userId), newUsedQuota, quotaWeight)
+		config.sendJSONResponse(http.StatusInternalServerError, "quota-check.deduction_inconsistent",
 			"Quota deduction resulted in inconsistent state", false, nil)
 		return
 	}
@@ -719,16 +1653,53 @@ func handleQuotaDeductionResponse(ctx wrapper.HttpContext, incrResponse resp.Val
 
 	// Log quota deduction details for audit and debugging
 	log.Infof("Successfully deducted %d quota for user %s, model %s. Previous used: %d, New used: %d",
-		quotaWeight, userId, modelName, expectedPreviousUsed, newUsedQuota)
+		quotaWeight, maskUserId(userId), modelName, expectedPreviousUsed, newUsedQuota)
+	decisionMetrics.Allowed++
+	emitQuotaDecision(config, quotaDecision{userId: userId, modelName: modelName, weight: quotaWeight,
+		remaining: remainingQuota - quotaWeight, hasRemaining: true, decision: QuotaDecisionAllowed}, log)
+	recordCharge(ctx, config, userId, modelName, quotaWeight, config.modelUsedKey(userId, modelName))
+	appendUsageLog(config, userId, modelName, quotaWeight, UsageSourceUser, ctx.GetStringContext("requestId", ""), log)
+	checkAutoRefill(config, userId, modelName, remainingQuota-quotaWeight, log)
 
 	// Additional debug information
 	log.Debugf("Quota deduction details for user %s: deducted=%d, new_used=%d, expected_previous=%d",
-		userId, quotaWeight, newUsedQuota, expectedPreviousUsed)
+		maskUserId(userId), quotaWeight, newUsedQuota, expectedPreviousUsed)
+
+	resumeAfterWriteAck(ctx, config, userId, log)
+}
 
-	proxywasm.ResumeHttpRequest()
+// resumeAfterWriteAck resumes the request once the quota deduction has been replicated
+// to the configured number of replicas, or immediately when acknowledgment is disabled
+func resumeAfterWriteAck(ctx wrapper.HttpContext, config QuotaConfig, userId string, log wrapper.Log) {
+	if config.WriteAckReplicas == 0 {
+		resumeHttpRequest(ctx, config)
+		return
+	}
+	err := config.redisClient.Wait(config.WriteAckReplicas, config.WriteAckTimeoutMs, func(response resp.Value) {
+		if wrapper.IsRedisErrorResponse(response) {
+			redisErr := wrapper.GetRedisErrorFromResponse(response)
+			log.Errorf("Failed to wait for quota deduction replication for user %s: %v", maskUserId(userId), redisErr)
+			config.sendJSONResponse(http.StatusServiceUnavailable, "quota-check.write_ack_failed",
+				fmt.Sprintf("Quota deduction replication failed: %s", redisErr.Error()), false, nil)
+			return
+		}
+		if acked := response.Integer(); acked < config.WriteAckReplicas {
+			log.Errorf("Quota deduction for user %s acknowledged by %d replicas, required %d", maskUserId(userId), acked, config.WriteAckReplicas)
+			config.sendJSONResponse(http.StatusServiceUnavailable, "quota-check.write_ack_failed",
+				fmt.Sprintf("Quota deduction acknowledged by %d of %d replicas", acked, config.WriteAckReplicas), false, nil)
+			return
+		}
+		resumeHttpRequest(ctx, config)
+	})
+	if err != nil {
+		log.Errorf("Failed to dispatch replication wait for user %s: %v", maskUserId(userId), err)
+		config.sendJSONResponse(http.StatusServiceUnavailable, "quota-check.write_ack_failed",
+			fmt.Sprintf("Quota deduction replication failed: %v", err), false, nil)
+	}
 }
 
 func onHttpStreamingResponseBody(ctx wrapper.HttpContext, config QuotaConfig, data []byte, endOfStream bool, log wrapper.Log) []byte {
+	config = tenantConfig(ctx, config)
 	chatMode, ok := ctx.GetContext("chatMode").(ChatMode)
 	if !ok {
 		return data
@@ -737,12 +1708,22 @@ func onHttpStreamingResponseBody(ctx wrapper.HttpContext, config QuotaConfig, da
 		return data
 	}
 
-	// chat completion mode - no longer need to deduct quota here as it's handled in request headers
+	// Quota is deducted in the request phase, models billed by tokens settle it here
+	trackUsage(ctx, config, data, endOfStream, log)
 	return data
 }
 
 func getOperationMode(path string, adminPath string, log wrapper.Log) (ChatMode, AdminMode) {
 	fullAdminPath := "/v1/chat/completions" + adminPath
+	if strings.HasSuffix(path, fullAdminPath+"/refresh/bulk") {
+		return ChatModeAdmin, AdminModeBulkRefresh
+	}
+	if strings.HasSuffix(path, fullAdminPath+"/delta/bulk") {
+		return ChatModeAdmin, AdminModeBulkDelta
+	}
+	if strings.HasSuffix(path, fullAdminPath+"/rename") {
+		return ChatModeAdmin, AdminModeRename
+	}
 	if strings.HasSuffix(path, fullAdminPath+"/refresh") {
 		return ChatModeAdmin, AdminModeRefresh
 	}
@@ -764,6 +1745,27 @@ func getOperationMode(path string, adminPath string, log wrapper.Log) (ChatMode,
 	if strings.HasSuffix(path, fullAdminPath+"/star") {
 		return ChatModeAdmin, AdminModeStarQuery
 	}
+	if strings.HasSuffix(path, fullAdminPath+"/config") {
+		return ChatModeAdmin, AdminModeConfig
+	}
+	if strings.HasSuffix(path, fullAdminPath+"/metrics") {
+		return ChatModeAdmin, AdminModeMetrics
+	}
+	if strings.HasSuffix(path, fullAdminPath+"/selftest") {
+		return ChatModeAdmin, AdminModeSelfTest
+	}
+	if strings.HasSuffix(path, fullAdminPath+"/memory") {
+		return ChatModeAdmin, AdminModeMemory
+	}
+	if strings.HasSuffix(path, fullAdminPath+"/usage/log") {
+		return ChatModeAdmin, AdminModeUsageLog
+	}
+	if strings.HasSuffix(path, fullAdminPath+"/deduct") {
+		return ChatModeAdmin, AdminModeBatchDeduct
+	}
+	if strings.HasSuffix(path, fullAdminPath+"/cost") {
+		return ChatModeAdmin, AdminModeCost
+	}
 	if strings.HasSuffix(path, fullAdminPath) {
 		return ChatModeAdmin, AdminModeQuery
 	}
@@ -780,22 +1782,26 @@ func refreshQuota(ctx wrapper.HttpContext, config QuotaConfig, body string, log
 		values[k] = v[0]
 	}
 	userId := values["user_id"]
-	quota, err := strconv.Atoi(values["quota"])
+	quota, err := config.parseQuota(values["quota"])
 	if userId == "" || err != nil {
-		sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id can't be empty and quota must be integer.", false, nil)
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id can't be empty and quota must be a number within quota_precision decimal places.", false, nil)
+		return types.ActionContinue
+	}
+	expected, compare, ok := parseExpectedQuota(config, queryValues)
+	if !ok {
 		return types.ActionContinue
 	}
-	err2 := config.redisClient.Set(config.RedisKeyPrefix+userId, quota, func(response resp.Value) {
-		log.Debugf("Redis set key = %s quota = %d", config.RedisKeyPrefix+userId, quota)
+	err2 := config.setQuotaKey(config.totalKey(userId), quota, expected, compare, func(response resp.Value) {
+		log.Debugf("Redis set key = %s quota = %d", config.totalKey(maskUserId(userId)), quota)
 		if err := response.Error(); err != nil {
-			sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+			sendQuotaWriteError(config, err)
 			return
 		}
-		sendJSONResponse(http.StatusOK, "ai-gateway.refreshquota", "refresh quota successful", true, nil)
+		config.sendJSONResponse(http.StatusOK, "ai-gateway.refreshquota", "refresh quota successful", true, nil)
 	})
 
 	if err2 != nil {
-		sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
 		return types.ActionContinue
 	}
 
@@ -805,26 +1811,26 @@ func refreshQuota(ctx wrapper.HttpContext, config QuotaConfig, body string, log
 func queryQuota(ctx wrapper.HttpContext, config QuotaConfig, url *url.URL, adminMode AdminMode, log wrapper.Log) types.Action {
 	// check url
 	queryValues := url.Query()
-	values := make(map[string]string, len(queryValues))
-	for k, v := range queryValues {
-		values[k] = v[0]
+	userId, err := config.queryUserId(queryValues)
+	if err != nil {
+		sendUnknownConsumer(config, queryValues)
+		return types.ActionContinue
 	}
-	if values["user_id"] == "" {
-		sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id can't be empty.", false, nil)
+	if userId == "" {
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id or consumer can't be empty.", false, nil)
 		return types.ActionContinue
 	}
-	userId := values["user_id"]
 
 	// Determine which key to use based on admin mode
 	var redisKey string
 	var responseType string
 	if adminMode == AdminModeUsedQuery {
-		redisKey = config.RedisUsedPrefix + userId
+		redisKey = config.usedKey(userId)
 		responseType = "used_quota"
 	} else if adminMode == AdminModeStarQuery {
 		// Check cache first for star query
 		if cached, hasStar := config.checkStarCache(userId); cached {
-			log.Debugf("Star status found in cache for user %s: %t", userId, hasStar)
+			log.Debugf("Star status found in cache for user %s: %t", maskUserId(userId), hasStar)
 			starValue := "false"
 			if hasStar {
 				starValue = "true"
@@ -834,23 +1840,25 @@ func queryQuota(ctx wrapper.HttpContext, config QuotaConfig, url *url.URL, admin
 				"star_value": starValue,
 				"type":       "star_status",
 			}
-			sendJSONResponse(http.StatusOK, "ai-gateway.querystar", "query star status successful (cached)", true, data)
+			config.sendJSONResponse(http.StatusOK, "ai-gateway.querystar", "query star status successful (cached)", true, data)
 			return types.ActionContinue
 		}
 
-		redisKey = config.RedisStarPrefix + userId
+		redisKey = config.starKey(userId)
 		responseType = "star_status"
 	} else {
-		redisKey = config.RedisKeyPrefix + userId
+		redisKey = config.totalKey(userId)
 		responseType = "total_quota"
 	}
 
-	err := config.redisClient.Get(redisKey, func(response resp.Value) {
+	err = config.replicaRead(func(client wrapper.RedisClient, callback wrapper.RedisResponseCallback) error {
+		return client.Get(redisKey, callback)
+	}, func(response resp.Value) {
 		// Check for Redis errors first
 		if wrapper.IsRedisErrorResponse(response) {
 			redisErr := wrapper.GetRedisErrorFromResponse(response)
-			log.Errorf("Failed to query %s for user %s: %v", responseType, userId, redisErr)
-			sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.redis_error",
+			log.Errorf("Failed to query %s for user %s: %v", responseType, maskUserId(userId), redisErr)
+			config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.redis_error",
 				fmt.Sprintf("Redis error: %s", redisErr.Error()), false, nil)
 			return
 		}
@@ -864,19 +1872,19 @@ func queryQuota(ctx wrapper.HttpContext, config QuotaConfig, url *url.URL, admin
 				if starValueFromRedis == "true" || starValueFromRedis == "false" {
 					starValue = starValueFromRedis
 				} else {
-					log.Warnf("Invalid star status value for user %s: %s, defaulting to false", userId, starValueFromRedis)
+					log.Warnf("Invalid star status value for user %s: %s, defaulting to false", maskUserId(userId), starValueFromRedis)
 				}
 			} else {
-				log.Debugf("No star status found for user %s (key does not exist), defaulting to false", userId)
+				log.Debugf("No star status found for user %s (key does not exist), defaulting to false", maskUserId(userId))
 			}
 
 			// Only cache true status
 			hasStar := starValue == "true"
 			if hasStar {
-				config.setStarCache(userId, hasStar)
-				log.Debugf("Cached star status from Redis for user %s: %t", userId, hasStar)
+				config.setStarCache(userId, "", hasStar)
+				log.Debugf("Cached star status from Redis for user %s: %t", maskUserId(userId), hasStar)
 			} else {
-				log.Debugf("User %s has not starred, not caching false status", userId)
+				log.Debugf("User %s has not starred, not caching false status", maskUserId(userId))
 			}
 
 			data := map[string]string{
@@ -884,7 +1892,7 @@ func queryQuota(ctx wrapper.HttpContext, config QuotaConfig, url *url.URL, admin
 				"star_value": starValue,
 				"type":       responseType,
 			}
-			sendJSONResponse(http.StatusOK, "ai-gateway.querystar", "query star status successful", true, data)
+			config.sendJSONResponse(http.StatusOK, "ai-gateway.querystar", "query star status successful", true, data)
 		} else {
 			// Handle quota query (integer value)
 			quota := 0
@@ -895,39 +1903,106 @@ func queryQuota(ctx wrapper.HttpContext, config QuotaConfig, url *url.URL, admin
 					var parseErr error
 					quota, parseErr = strconv.Atoi(quotaStr)
 					if parseErr != nil {
-						log.Errorf("Invalid %s format for user %s: %s", responseType, userId, quotaStr)
-						sendJSONResponse(http.StatusInternalServerError, "ai-gateway.invalid_quota_format",
+						log.Errorf("Invalid %s format for user %s: %s", responseType, maskUserId(userId), quotaStr)
+						config.sendJSONResponse(http.StatusInternalServerError, "ai-gateway.invalid_quota_format",
 							fmt.Sprintf("Invalid %s format", responseType), false, nil)
 						return
 					}
 
 					// Validate that quota is non-negative
 					if quota < 0 {
-						log.Errorf("Invalid %s value for user %s: %d (cannot be negative)", responseType, userId, quota)
-						sendJSONResponse(http.StatusInternalServerError, "ai-gateway.invalid_quota_value",
+						log.Errorf("Invalid %s value for user %s: %d (cannot be negative)", responseType, maskUserId(userId), quota)
+						config.sendJSONResponse(http.StatusInternalServerError, "ai-gateway.invalid_quota_value",
 							fmt.Sprintf("Invalid %s value", responseType), false, nil)
 						return
 					}
 				}
 			} else {
-				log.Debugf("No %s found for user %s (key does not exist or is empty), defaulting to 0", responseType, userId)
+				log.Debugf("No %s found for user %s (key does not exist or is empty), defaulting to 0", responseType, maskUserId(userId))
 			}
 
 			data := map[string]interface{}{
 				"user_id": userId,
-				"quota":   quota,
+				"quota":   json.Number(config.formatQuota(quota)),
 				"type":    responseType,
 			}
-			sendJSONResponse(http.StatusOK, "ai-gateway.queryquota", "query quota successful", true, data)
+			config.sendJSONResponse(http.StatusOK, "ai-gateway.queryquota", "query quota successful", true, data)
 		}
-	})
+	}, log)
 	if err != nil {
-		sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
 		return types.ActionContinue
 	}
 	return types.ActionPause
 }
 
+// redactedValue replaces secrets in the effective config response
+const redactedValue = "******"
+
+// redactSecret hides a configured secret while still showing whether it is set
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// effectiveConfig is the JSON view of the parsed config returned by the config endpoint
+type effectiveConfig struct {
+	QuotaConfig
+	Redis RedisInfo `json:"redis"`
+}
+
+// queryEffectiveConfig returns the parsed config with defaults applied and secrets redacted
+func queryEffectiveConfig(config QuotaConfig, log wrapper.Log) types.Action {
+	view := effectiveConfig{
+		QuotaConfig: config,
+		Redis:       config.redisInfo,
+	}
+	view.AdminKey = redactSecret(view.AdminKey)
+	view.Redis.Password = redactSecret(view.Redis.Password)
+	log.Debugf("Returning effective config")
+	config.sendJSONResponse(http.StatusOK, "ai-gateway.queryconfig", "query config successful", true, view)
+	return types.ActionContinue
+}
+
+// queryMetrics returns the Redis operation metrics and cache statistics of this plugin instance
+func queryMetrics(config QuotaConfig, log wrapper.Log) types.Action {
+	redisMetrics := wrapper.GetRedisMetrics()
+	backends := make([]map[string]interface{}, 0)
+	for _, backend := range wrapper.GetBackendStates() {
+		backends = append(backends, map[string]interface{}{
+			"name":                 backend.Name,
+			"primary":              backend.Primary,
+			"state":                backend.State,
+			"consecutive_failures": backend.ConsecutiveFailures,
+			"total_failures":       backend.TotalFailures,
+			"active":               backend.Active,
+		})
+	}
+	data := map[string]interface{}{
+		"redis": map[string]interface{}{
+			"total_calls":      redisMetrics.TotalCalls,
+			"successful_calls": redisMetrics.SuccessfulCalls,
+			"failed_calls":     redisMetrics.FailedCalls,
+			"retry_attempts":   redisMetrics.RetryAttempts,
+			"in_flight_calls":  redisMetrics.InFlightCalls,
+			"rejected_calls":   redisMetrics.RejectedCalls,
+			"failover_calls":   redisMetrics.FailoverCalls,
+			"backend_calls":    redisMetrics.BackendCalls,
+		},
+		"redis_backends":  backends,
+		"quota_decisions": decisionMetrics,
+		"star_cache": map[string]int{
+			"size":     config.starCache.size(),
+			"max_size": config.StarCacheMaxSize,
+		},
+	}
+	log.Debugf("Returning metrics")
+	config.sendJSONResponse(http.StatusOK, "ai-gateway.querymetrics", "query metrics successful", true, data)
+	return types.ActionContinue
+}
+
 func deltaQuota(ctx wrapper.HttpContext, config QuotaConfig, body string, log wrapper.Log) types.Action {
 	queryValues, _ := url.ParseQuery(body)
 	values := make(map[string]string, len(queryValues))
@@ -935,41 +2010,43 @@ func deltaQuota(ctx wrapper.HttpContext, config QuotaConfig, body string, log wr
 		values[k] = v[0]
 	}
 	userId := values["user_id"]
-	value, err := strconv.Atoi(values["value"])
+	value, err := config.parseQuota(values["value"])
 	if userId == "" || err != nil {
-		sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id can't be empty and value must be integer.", false, nil)
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id can't be empty and value must be a number within quota_precision decimal places.", false, nil)
 		return types.ActionContinue
 	}
 
-	if value >= 0 {
-		err := config.redisClient.IncrBy(config.RedisKeyPrefix+userId, value, func(response resp.Value) {
-			log.Debugf("Redis Incr key = %s value = %d", config.RedisKeyPrefix+userId, value)
-			if err := response.Error(); err != nil {
-				sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
-				return
+	return requireExistingUser(config, userId, log, func() types.Action {
+		if value >= 0 {
+			err := config.redisClient.IncrBy(config.totalKey(userId), value, func(response resp.Value) {
+				log.Debugf("Redis Incr key = %s value = %d", config.totalKey(maskUserId(userId)), value)
+				if err := response.Error(); err != nil {
+					config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+					return
+				}
+				config.sendJSONResponse(http.StatusOK, "ai-gateway.deltaquota", "delta quota successful", true, nil)
+			})
+			if err != nil {
+				config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+				return types.ActionContinue
 			}
-			sendJSONResponse(http.StatusOK, "ai-gateway.deltaquota", "delta quota successful", true, nil)
-		})
-		if err != nil {
-			sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
-			return types.ActionContinue
-		}
-	} else {
-		err := config.redisClient.DecrBy(config.RedisKeyPrefix+userId, 0-value, func(response resp.Value) {
-			log.Debugf("Redis Decr key = %s value = %d", config.RedisKeyPrefix+userId, 0-value)
-			if err := response.Error(); err != nil {
-				sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
-				return
+		} else {
+			err := config.redisClient.DecrBy(config.totalKey(userId), 0-value, func(response resp.Value) {
+				log.Debugf("Redis Decr key = %s value = %d", config.totalKey(maskUserId(userId)), 0-value)
+				if err := response.Error(); err != nil {
+					config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+					return
+				}
+				config.sendJSONResponse(http.StatusOK, "ai-gateway.deltaquota", "delta quota successful", true, nil)
+			})
+			if err != nil {
+				config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+				return types.ActionContinue
 			}
-			sendJSONResponse(http.StatusOK, "ai-gateway.deltaquota", "delta quota successful", true, nil)
-		})
-		if err != nil {
-			sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
-			return types.ActionContinue
 		}
-	}
 
-	return types.ActionPause
+		return types.ActionPause
+	})
 }
 
 func refreshUsedQuota(ctx wrapper.HttpContext, config QuotaConfig, body string, log wrapper.Log) types.Action {
@@ -979,22 +2056,26 @@ func refreshUsedQuota(ctx wrapper.HttpContext, config QuotaConfig, body string,
 		values[k] = v[0]
 	}
 	userId := values["user_id"]
-	quota, err := strconv.Atoi(values["quota"])
+	quota, err := config.parseQuota(values["quota"])
 	if userId == "" || err != nil {
-		sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id can't be empty and quota must be integer.", false, nil)
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id can't be empty and quota must be a number within quota_precision decimal places.", false, nil)
 		return types.ActionContinue
 	}
-	err2 := config.redisClient.Set(config.RedisUsedPrefix+userId, quota, func(response resp.Value) {
-		log.Debugf("Redis set key = %s quota = %d", config.RedisUsedPrefix+userId, quota)
+	expected, compare, ok := parseExpectedQuota(config, queryValues)
+	if !ok {
+		return types.ActionContinue
+	}
+	err2 := config.setQuotaKey(config.usedKey(userId), quota, expected, compare, func(response resp.Value) {
+		log.Debugf("Redis set key = %s quota = %d", config.usedKey(maskUserId(userId)), quota)
 		if err := response.Error(); err != nil {
-			sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+			sendQuotaWriteError(config, err)
 			return
 		}
-		sendJSONResponse(http.StatusOK, "ai-gateway.refreshusedquota", "refresh used quota successful", true, nil)
+		config.sendJSONResponse(http.StatusOK, "ai-gateway.refreshusedquota", "refresh used quota successful", true, nil)
 	})
 
 	if err2 != nil {
-		sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
 		return types.ActionContinue
 	}
 
@@ -1008,41 +2089,44 @@ func deltaUsedQuota(ctx wrapper.HttpContext, config QuotaConfig, body string, lo
 		values[k] = v[0]
 	}
 	userId := values["user_id"]
-	value, err := strconv.Atoi(values["value"])
+	value, err := config.parseQuota(values["value"])
 	if userId == "" || err != nil {
-		sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id can't be empty and value must be integer.", false, nil)
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id can't be empty and value must be a number within quota_precision decimal places.", false, nil)
 		return types.ActionContinue
 	}
 
-	if value >= 0 {
-		err := config.redisClient.IncrBy(config.RedisUsedPrefix+userId, value, func(response resp.Value) {
-			log.Debugf("Redis Incr key = %s value = %d", config.RedisUsedPrefix+userId, value)
-			if err := response.Error(); err != nil {
-				sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
-				return
+	return requireExistingUser(config, userId, log, func() types.Action {
+		if value >= 0 {
+			err := config.redisClient.IncrBy(config.usedKey(userId), value, func(response resp.Value) {
+				log.Debugf("Redis Incr key = %s value = %d", config.usedKey(maskUserId(userId)), value)
+				if err := response.Error(); err != nil {
+					config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+					return
+				}
+				config.sendJSONResponse(http.StatusOK, "ai-gateway.deltausedquota", "delta used quota successful", true, nil)
+			})
+			if err != nil {
+				config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+				return types.ActionContinue
 			}
-			sendJSONResponse(http.StatusOK, "ai-gateway.deltausedquota", "delta used quota successful", true, nil)
-		})
-		if err != nil {
-			sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
-			return types.ActionContinue
-		}
-	} else {
-		err := config.redisClient.DecrBy(config.RedisUsedPrefix+userId, 0-value, func(response resp.Value) {
-			log.Debugf("Redis Decr key = %s value = %d", config.RedisUsedPrefix+userId, 0-value)
-			if err := response.Error(); err != nil {
-				sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
-				return
+		} else {
+			// Used quota never goes below zero, however large the decrement
+			err := config.redisClient.DecrByFloor(config.usedKey(userId), 0-value, 0, func(usedQuota int, clamped bool, err error) {
+				log.Debugf("Redis Decr key = %s value = %d, used = %d, clamped = %t", config.usedKey(maskUserId(userId)), 0-value, usedQuota, clamped)
+				if err != nil {
+					config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+					return
+				}
+				config.sendJSONResponse(http.StatusOK, "ai-gateway.deltausedquota", "delta used quota successful", true, nil)
+			})
+			if err != nil {
+				config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+				return types.ActionContinue
 			}
-			sendJSONResponse(http.StatusOK, "ai-gateway.deltausedquota", "delta used quota successful", true, nil)
-		})
-		if err != nil {
-			sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
-			return types.ActionContinue
 		}
-	}
 
-	return types.ActionPause
+		return types.ActionPause
+	})
 }
 
 func setStarStatus(ctx wrapper.HttpContext, config QuotaConfig, body string, log wrapper.Log) types.Action {
@@ -1056,34 +2140,34 @@ func setStarStatus(ctx wrapper.HttpContext, config QuotaConfig, body string, log
 	userId := values["user_id"]
 	starValue := values["star_value"]
 	if userId == "" || starValue == "" {
-		sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id and star_value can't be empty.", false, nil)
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id and star_value can't be empty.", false, nil)
 		return types.ActionContinue
 	}
 
 	// Validate star_value should be "true" or "false"
 	if starValue != "true" && starValue != "false" {
-		sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. star_value must be 'true' or 'false'.", false, nil)
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. star_value must be 'true' or 'false'.", false, nil)
 		return types.ActionContinue
 	}
 
-	redisKey := config.RedisStarPrefix + userId
+	redisKey := config.starKey(userId)
 
 	// Delete from local cache before setting to ensure fresh read
 	config.deleteStarCache(userId)
-	log.Debugf("Deleted star cache for user %s before setting", userId)
+	log.Debugf("Deleted star cache for user %s before setting", maskUserId(userId))
 
 	err := config.redisClient.Set(redisKey, starValue, func(response resp.Value) {
-		log.Debugf("Redis set key = %s star_value = %s", redisKey, starValue)
+		log.Debugf("Redis set key = %s star_value = %s", config.starKey(maskUserId(userId)), starValue)
 		if err := response.Error(); err != nil {
-			sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+			config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
 			return
 		}
 
-		sendJSONResponse(http.StatusOK, "ai-gateway.setstar", "set star status successful", true, nil)
+		config.sendJSONResponse(http.StatusOK, "ai-gateway.setstar", "set star status successful", true, nil)
 	})
 
 	if err != nil {
-		sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
 		return types.ActionContinue
 	}
 
@@ -1092,28 +2176,27 @@ func setStarStatus(ctx wrapper.HttpContext, config QuotaConfig, body string, log
 
 // checkStarCache checks if user star status is cached
 func (config *QuotaConfig) checkStarCache(userId string) (bool, bool) {
-	hasStar, exists := config.starCache[userId]
-	// Only return cache hit if the user has starred (true)
-	// If user hasn't starred, we should always check Redis
-	if exists && hasStar {
+	// Only starred users are cached, if user hasn't starred, we should always check Redis
+	if config.starCache.contains(userId) {
 		return true, true
 	}
 	return false, false
 }
 
-// setStarCache sets user star status in cache (only cache true status)
-func (config *QuotaConfig) setStarCache(userId string, hasStar bool) {
+// setStarCache sets user star status in cache (only cache true status), trusted for the
+// TTL of the user's tier
+func (config *QuotaConfig) setStarCache(userId string, tier string, hasStar bool) {
 	if hasStar {
-		config.starCache[userId] = hasStar
+		config.starCache.add(userId, config.starCacheTTL(tier))
 	} else {
 		// Don't cache false status, delete if exists
-		delete(config.starCache, userId)
+		config.starCache.remove(userId)
 	}
 }
 
 // deleteStarCache removes user star status from cache
 func (config *QuotaConfig) deleteStarCache(userId string) {
-	delete(config.starCache, userId)
+	config.starCache.remove(userId)
 }
 
 // BuildModelsResponse creates an OpenAI-compatible models list response based on modelMapping
@@ -1130,15 +2213,33 @@ func (config *QuotaConfig) BuildModelsResponse() ([]byte, error) {
 		return json.Marshal(response)
 	}
 
-	// Extract model names from modelMapping keys
-	for modelName, modelValue := range config.Provider.ModelMapping {
-		// Skip wildcard entries
-		if modelName == wildcard {
-			continue
+	// Determine the owner based on provider type
+	owner := config.getOwnerByProvider()
+
+	// Stripping prefixes may collapse several mapping keys into the same public id
+	seen := make(map[string]bool)
+	appendModel := func(modelName string) {
+		modelId := config.publicModelId(modelName)
+		if seen[modelId] {
+			return
 		}
+		seen[modelId] = true
+		models = append(models, ModelInfo{
+			Id:      modelId,
+			Object:  "model",
+			Created: 1686935002, // Fixed timestamp as requested
+			OwnedBy: owner,
+		})
+	}
 
-		// Skip prefix matching patterns (ending with *)
+	// Extract model names from modelMapping keys
+	hasWildcardMapping := false
+	for modelName, modelValue := range config.Provider.ModelMapping {
+		// Skip wildcard entries and prefix matching patterns (ending with *)
 		if strings.HasSuffix(modelName, wildcard) {
+			if modelValue != "" {
+				hasWildcardMapping = true
+			}
 			continue
 		}
 
@@ -1148,17 +2249,22 @@ func (config *QuotaConfig) BuildModelsResponse() ([]byte, error) {
 			continue
 		}
 
-		// Determine the owner based on provider type
-		owner := config.getOwnerByProvider()
+		appendModel(modelName)
+	}
 
-		models = append(models, ModelInfo{
-			Id:      modelName,
-			Object:  "model",
-			Created: 1686935002, // Fixed timestamp as requested
-			OwnedBy: owner,
-		})
+	// A catch-all only configuration has no concrete model names to list,
+	// so advertise the configured wildcard models instead
+	if len(models) == 0 && hasWildcardMapping {
+		for _, modelName := range config.WildcardModels {
+			appendModel(modelName)
+		}
 	}
 
+	// Mapping iteration order is random, keep the list stable so the ETag is too
+	sort.Slice(models, func(i, j int) bool {
+		return models[i].Id < models[j].Id
+	})
+
 	// Always return the same models slice (empty or with content)
 	// This ensures consistent JSON response: [] instead of null
 	response := ModelsResponse{
@@ -1169,6 +2275,49 @@ func (config *QuotaConfig) BuildModelsResponse() ([]byte, error) {
 	return json.Marshal(response)
 }
 
+// modelsResponseETag derives a strong ETag from the models response body
+func modelsResponseETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether an If-None-Match header value matches the ETag
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// isExemptPath matches the request path, without query string, against exempt_paths
+func (config *QuotaConfig) isExemptPath(rawPath string) bool {
+	return matchesPath(config.ExemptPaths, rawPath)
+}
+
+// matchesPath matches the request path, without query string, against paths where a
+// trailing * matches by prefix
+func matchesPath(paths []string, rawPath string) bool {
+	path, _, _ := strings.Cut(rawPath, "?")
+	for _, candidate := range paths {
+		if strings.HasSuffix(candidate, wildcard) {
+			if strings.HasPrefix(path, strings.TrimSuffix(candidate, wildcard)) {
+				return true
+			}
+		} else if path == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// publicModelId applies the configured strip/add prefixes to an advertised model id
+func (config *QuotaConfig) publicModelId(modelName string) string {
+	return config.ModelsIdAddPrefix + strings.TrimPrefix(modelName, config.ModelsIdStripPrefix)
+}
+
 // getOwnerByProvider returns the owner name based on provider type
 func (config *QuotaConfig) getOwnerByProvider() string {
 	switch config.Provider.Type {
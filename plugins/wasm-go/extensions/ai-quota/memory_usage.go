@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/resp"
+)
+
+type keyMemoryUsage struct {
+	Key string `json:"key"`
+	// Bytes is nil when the key doesn't exist
+	Bytes *int64 `json:"bytes"`
+}
+
+// isCommandUnavailable reports whether Redis refused a command it doesn't offer, because
+// it is renamed away, not permitted by the ACL or not supported by the server
+func isCommandUnavailable(err error) bool {
+	message := strings.ToLower(err.Error())
+	return strings.Contains(message, "unknown command") || strings.Contains(message, "noperm") ||
+		strings.Contains(message, "unknown subcommand")
+}
+
+// queryMemoryUsage reports the bytes Redis spends on each quota key of a user, to find
+// out whose quota data is heaviest
+func queryMemoryUsage(config QuotaConfig, url *url.URL, log wrapper.Log) types.Action {
+	queryValues := url.Query()
+	userId, err := config.queryUserId(queryValues)
+	if err != nil {
+		sendUnknownConsumer(config, queryValues)
+		return types.ActionContinue
+	}
+	if userId == "" {
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id or consumer can't be empty.", false, nil)
+		return types.ActionContinue
+	}
+
+	keys := config.userKeys(userId)
+	usages := make([]keyMemoryUsage, len(keys))
+	pending := len(keys)
+	var failure error
+	finish := func() {
+		if failure != nil {
+			if isCommandUnavailable(failure) {
+				config.sendJSONResponse(http.StatusNotImplemented, "ai-gateway.memory_usage_unavailable",
+					fmt.Sprintf("MEMORY USAGE is not available on the Redis server: %v", failure), false, nil)
+				return
+			}
+			config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", failure), false, nil)
+			return
+		}
+		var totalBytes int64
+		for _, usage := range usages {
+			if usage.Bytes != nil {
+				totalBytes += *usage.Bytes
+			}
+		}
+		data := map[string]interface{}{
+			"user_id":     userId,
+			"keys":        usages,
+			"total_bytes": totalBytes,
+		}
+		config.sendJSONResponse(http.StatusOK, "ai-gateway.querymemory", "query memory usage successful", true, data)
+	}
+	dispatched := false
+	for i, key := range keys {
+		i := i
+		usages[i].Key = key.(string)
+		err := config.redisClient.MemoryUsage(usages[i].Key, 0, func(response resp.Value) {
+			if err := response.Error(); err != nil {
+				log.Warnf("Failed to query memory usage of %s: %v", maskUserIdInKey(usages[i].Key, userId), err)
+				failure = err
+			} else if !response.IsNull() {
+				bytes := int64(response.Integer())
+				usages[i].Bytes = &bytes
+			}
+			pending--
+			if pending == 0 {
+				finish()
+			}
+		})
+		if err != nil {
+			failure = err
+			pending--
+			continue
+		}
+		dispatched = true
+	}
+	if !dispatched {
+		finish()
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+// metricsFlushTickMs is how often the flusher checks whether the flush interval has passed
+const metricsFlushTickMs = 1000
+
+// metricsFlushScript adds each field/delta pair of ARGV to the metrics hash in one round trip
+const metricsFlushScript = `
+	for i = 1, #ARGV, 2 do
+		redis.call('hincrby', KEYS[1], ARGV[i], ARGV[i + 1])
+	end
+	return 1
+`
+
+// metricsFlusher persists the growth of the per-VM counters to a shared Redis hash, so
+// cumulative stats survive restarts of the Wasm VM. The counters are global, so there
+// is a single flusher per VM whatever the number of configs, using the latest one.
+type metricsFlusher struct {
+	client     wrapper.RedisClient
+	key        string
+	intervalMs int64
+	lastFlush  int64
+	// counter values already added to the hash, only advanced once a flush succeeds
+	flushed  map[string]int64
+	inFlight bool
+}
+
+var globalMetricsFlusher *metricsFlusher
+
+func parseMetricsFlushConfig(json gjson.Result, config *QuotaConfig) error {
+	config.MetricsFlushIntervalMs = int(json.Get("metrics_flush_interval_ms").Int())
+	if config.MetricsFlushIntervalMs < 0 {
+		return errors.New("metrics_flush_interval_ms must not be negative")
+	}
+	config.RedisMetricsKey = json.Get("redis_metrics_key").String()
+	if config.RedisMetricsKey == "" {
+		config.RedisMetricsKey = "chat_quota_metrics"
+	}
+	if config.MetricsFlushIntervalMs == 0 {
+		return nil
+	}
+	if globalMetricsFlusher == nil {
+		globalMetricsFlusher = &metricsFlusher{flushed: make(map[string]int64)}
+		wrapper.RegisteTickFunc(metricsFlushTickMs, globalMetricsFlusher.tick)
+	}
+	globalMetricsFlusher.client = config.redisClient
+	globalMetricsFlusher.key = config.RedisMetricsKey
+	globalMetricsFlusher.intervalMs = int64(config.MetricsFlushIntervalMs)
+	return nil
+}
+
+// metricCounters snapshots the cumulative counters, named by their metrics endpoint path
+func metricCounters() map[string]int64 {
+	redisMetrics := wrapper.GetRedisMetrics()
+	counters := map[string]int64{
+		"redis.total_calls":      redisMetrics.TotalCalls,
+		"redis.successful_calls": redisMetrics.SuccessfulCalls,
+		"redis.failed_calls":     redisMetrics.FailedCalls,
+		"redis.retry_attempts":   redisMetrics.RetryAttempts,
+		"redis.rejected_calls":   redisMetrics.RejectedCalls,
+		"redis.failover_calls":   redisMetrics.FailoverCalls,
+	}
+	// Round trip through JSON so new decision counters are flushed without listing them here
+	decisions := make(map[string]int64)
+	if body, err := json.Marshal(decisionMetrics); err == nil {
+		_ = json.Unmarshal(body, &decisions)
+	}
+	for name, value := range decisions {
+		counters["quota_decisions."+name] = value
+	}
+	return counters
+}
+
+func (f *metricsFlusher) tick() {
+	now := time.Now().UnixMilli()
+	if f.inFlight || now-f.lastFlush < f.intervalMs {
+		return
+	}
+	f.lastFlush = now
+
+	counters := metricCounters()
+	args := make([]interface{}, 0)
+	for name, value := range counters {
+		if delta := value - f.flushed[name]; delta != 0 {
+			args = append(args, name, delta)
+		}
+	}
+	if len(args) == 0 {
+		return
+	}
+	f.inFlight = true
+	err := f.client.Eval(metricsFlushScript, 1, []interface{}{f.key}, args, func(response resp.Value) {
+		f.inFlight = false
+		if wrapper.IsRedisErrorResponse(response) {
+			// Not advancing flushed, the growth is sent again with the next flush
+			proxywasm.LogWarnf("Failed to flush metrics to %s: %v", f.key, wrapper.GetRedisErrorFromResponse(response))
+			return
+		}
+		f.flushed = counters
+	})
+	if err != nil {
+		f.inFlight = false
+		proxywasm.LogWarnf("Failed to dispatch metrics flush to %s: %v", f.key, err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+// modelConcurrencyKeyTTL clears a counter leaked by a worker that died with requests in
+// flight once the model has seen no new requests for that many seconds
+const modelConcurrencyKeyTTL = 3600
+
+// modelConcurrencyAcquireScript takes a slot of the model unless all are in use.
+// Returns {acquired, in flight}.
+const modelConcurrencyAcquireScript = `
+	local current = tonumber(redis.call('get', KEYS[1])) or 0
+	if current >= tonumber(ARGV[1]) then
+		return {0, current}
+	end
+	current = redis.call('incr', KEYS[1])
+	redis.call('expire', KEYS[1], ARGV[2])
+	return {1, current}
+`
+
+// modelConcurrencyReleaseScript gives a slot back, never going below zero after the
+// counter expired while the request was in flight
+const modelConcurrencyReleaseScript = `
+	if redis.call('decr', KEYS[1]) < 0 then
+		redis.call('del', KEYS[1])
+	end
+	return 1
+`
+
+func parseModelConcurrencyLimits(json gjson.Result, config *QuotaConfig) error {
+	config.ModelConcurrencyLimits = make(map[string]int)
+	var limitErr error
+	json.Get("model_concurrency_limits").ForEach(func(key, value gjson.Result) bool {
+		if value.Int() <= 0 {
+			limitErr = fmt.Errorf("invalid model_concurrency_limits for model %s: limit must be positive", key.String())
+			return false
+		}
+		config.ModelConcurrencyLimits[key.String()] = int(value.Int())
+		return true
+	})
+	if limitErr != nil {
+		return limitErr
+	}
+	config.RedisConcurrencyPrefix = json.Get("redis_concurrency_prefix").String()
+	if config.RedisConcurrencyPrefix == "" {
+		config.RedisConcurrencyPrefix = "chat_quota_concurrency:"
+	}
+	return nil
+}
+
+func (c QuotaConfig) modelConcurrencyKey(modelName string) string {
+	return buildKey(c.ConcurrencyKeyTemplate, keyParts{model: modelName})
+}
+
+// applyModelConcurrency takes a slot of the model's global concurrency limit before
+// deducting its weight, rejecting the request with 503 when the model is at capacity
+func applyModelConcurrency(ctx wrapper.HttpContext, config QuotaConfig, userId string, quotaWeight int, modelName string, log wrapper.Log) types.Action {
+	limit, exists := config.ModelConcurrencyLimits[modelName]
+	if !exists {
+		return applyQuotaWeight(ctx, config, userId, quotaWeight, modelName, log)
+	}
+	key := config.modelConcurrencyKey(modelName)
+	err := config.redisClient.Eval(modelConcurrencyAcquireScript, 1, []interface{}{key}, []interface{}{limit, modelConcurrencyKeyTTL}, func(response resp.Value) {
+		if wrapper.IsRedisErrorResponse(response) || len(response.Array()) < 2 {
+			// Redis error - the concurrency cap is best effort, quota is still enforced
+			log.Warnf("Failed to acquire concurrency slot of model %s for user %s: %v. Allowing request to pass through.", modelName, maskUserId(userId), wrapper.GetRedisErrorFromResponse(response))
+			applyQuotaWeight(ctx, config, userId, quotaWeight, modelName, log)
+			return
+		}
+		result := response.Array()
+		if result[0].Integer() != 1 {
+			log.Warnf("Model %s is at capacity: %d requests in flight, limit %d", modelName, result[1].Integer(), limit)
+			config.sendJSONResponse(http.StatusServiceUnavailable, "quota-check.model_at_capacity",
+				fmt.Sprintf("Model %s is at capacity, please retry later", modelName), false, nil)
+			return
+		}
+		// The request may have ended while the slot was being taken
+		if ctx.GetBoolContext("streamDone", false) {
+			releaseModelConcurrency(config, key, log)
+			return
+		}
+		ctx.SetContext("modelConcurrencyKey", key)
+		log.Debugf("Acquired concurrency slot of model %s for user %s: %d/%d", modelName, maskUserId(userId), result[1].Integer(), limit)
+		applyQuotaWeight(ctx, config, userId, quotaWeight, modelName, log)
+	})
+	if err != nil {
+		log.Warnf("Failed to dispatch concurrency check of model %s for user %s: %v. Allowing request to pass through.", modelName, maskUserId(userId), err)
+		return applyQuotaWeight(ctx, config, userId, quotaWeight, modelName, log)
+	}
+	return types.ActionPause
+}
+
+func releaseModelConcurrency(config QuotaConfig, key string, log wrapper.Log) {
+	err := config.redisClient.Eval(modelConcurrencyReleaseScript, 1, []interface{}{key}, nil, func(response resp.Value) {
+		if wrapper.IsRedisErrorResponse(response) {
+			log.Warnf("Failed to release concurrency slot %s: %v", key, wrapper.GetRedisErrorFromResponse(response))
+		}
+	})
+	if err != nil {
+		log.Warnf("Failed to dispatch release of concurrency slot %s: %v", key, err)
+	}
+}
+
+// onHttpStreamDone gives back the model concurrency slot of the request and refunds a
+// cancelled stream. It runs once the stream ends for any reason, including upstream errors
+// and client aborts.
+func onHttpStreamDone(ctx wrapper.HttpContext, config QuotaConfig, log wrapper.Log) {
+	config = tenantConfig(ctx, config)
+	ctx.SetContext("streamDone", true)
+	if key := ctx.GetStringContext("modelConcurrencyKey", ""); key != "" {
+		releaseModelConcurrency(config, key, log)
+	}
+	refundCancelledStream(ctx, config, log)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+func parseModelDenyConfig(json gjson.Result, config *QuotaConfig) {
+	config.ModelDenyMessages = make(map[string]string)
+	json.Get("model_deny_messages").ForEach(func(key, value gjson.Result) bool {
+		config.ModelDenyMessages[key.String()] = value.String()
+		return true
+	})
+	config.RedisDeniedModelsPrefix = json.Get("redis_denied_models_prefix").String()
+	if config.RedisDeniedModelsPrefix == "" {
+		config.RedisDeniedModelsPrefix = "chat_quota_denied_models:"
+	}
+}
+
+// checkModelDenied rejects the request with the model's deny message when the model is in
+// the user's denied models set, calling next otherwise. Only models with a deny message are
+// looked up, so other requests pay no extra Redis round trip.
+func checkModelDenied(ctx wrapper.HttpContext, config QuotaConfig, userId string, modelName string, message string, log wrapper.Log, next func()) {
+	lookupModelDenied(config, userId, modelName, log, func(denied bool) {
+		if denied {
+			log.Warnf("Model %s is denied for user %s", modelName, maskUserId(userId))
+			config.sendJSONResponse(http.StatusForbidden, "quota-check.model_denied", message, false, nil)
+			return
+		}
+		next()
+	})
+}
+
+// lookupModelDenied tells whether the model is in the user's denied models set. Lookup
+// failures count as not denied, quota is still enforced by the checks that follow.
+func lookupModelDenied(config QuotaConfig, userId string, modelName string, log wrapper.Log, callback func(denied bool)) {
+	deniedKey := config.deniedModelsKey(userId)
+	err := config.redisClient.SIsMember(deniedKey, modelName, func(response resp.Value) {
+		if wrapper.IsRedisErrorResponse(response) {
+			log.Warnf("Failed to check denied models for user %s, model %s: %v. Allowing request to pass through.", maskUserId(userId), modelName, wrapper.GetRedisErrorFromResponse(response))
+			callback(false)
+			return
+		}
+		callback(response.Integer() == 1)
+	})
+	if err != nil {
+		log.Warnf("Failed to dispatch denied models check for user %s, model %s: %v. Allowing request to pass through.", maskUserId(userId), modelName, err)
+		callback(false)
+	}
+}
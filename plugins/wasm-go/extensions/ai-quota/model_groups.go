@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+// parseModelGroups reads model_groups, mapping a group name to the models sharing its quota
+func parseModelGroups(json gjson.Result, config *QuotaConfig) error {
+	config.ModelGroups = make(map[string][]string)
+	config.modelGroupOf = make(map[string]string)
+	var groupErr error
+	json.Get("model_groups").ForEach(func(key, value gjson.Result) bool {
+		group := key.String()
+		models := make([]string, 0)
+		for _, model := range value.Array() {
+			modelName := model.String()
+			if modelName == "" {
+				continue
+			}
+			if other, exists := config.modelGroupOf[modelName]; exists {
+				groupErr = fmt.Errorf("model %s belongs to both model groups %s and %s", modelName, other, group)
+				return false
+			}
+			config.modelGroupOf[modelName] = group
+			models = append(models, modelName)
+		}
+		config.ModelGroups[group] = models
+		return true
+	})
+	if groupErr != nil {
+		return groupErr
+	}
+
+	config.RedisGroupPrefix = json.Get("redis_group_prefix").String()
+	if config.RedisGroupPrefix == "" {
+		config.RedisGroupPrefix = "chat_quota_group:"
+	}
+	config.RedisGroupUsedPrefix = json.Get("redis_group_used_prefix").String()
+	if config.RedisGroupUsedPrefix == "" {
+		config.RedisGroupUsedPrefix = "chat_quota_group_used:"
+	}
+	return nil
+}
+
+// modelTotalKey returns the total quota key charged for the model: the user's share of
+// the model's group when it has one, the user's own total quota otherwise
+func (c QuotaConfig) modelTotalKey(userId string, modelName string) string {
+	if group, exists := c.modelGroupOf[modelName]; exists {
+		return c.groupKey(group, userId)
+	}
+	return c.totalKey(userId)
+}
+
+// modelUsedKey returns the used quota key charged for the model, see modelTotalKey
+func (c QuotaConfig) modelUsedKey(userId string, modelName string) string {
+	if group, exists := c.modelGroupOf[modelName]; exists {
+		return c.groupUsedKey(group, userId)
+	}
+	return c.usedKey(userId)
+}
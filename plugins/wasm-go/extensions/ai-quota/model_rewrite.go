@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/sjson"
+)
+
+// injectDefaultModel writes default_model into a completion body that has no model, so
+// the upstream serves the model the request is charged for. Returns the body as sent.
+func injectDefaultModel(config QuotaConfig, body []byte, log wrapper.Log) []byte {
+	if !config.InjectDefaultModel {
+		return body
+	}
+	newBody, err := sjson.SetBytes(body, "model", config.DefaultModel)
+	if err != nil {
+		log.Warnf("Failed to inject default model %s: %v", config.DefaultModel, err)
+		return body
+	}
+	if err := proxywasm.ReplaceHttpRequestBody(newBody); err != nil {
+		log.Warnf("Failed to replace request body with default model %s: %v", config.DefaultModel, err)
+		return body
+	}
+	log.Debugf("Injected default model %s", config.DefaultModel)
+	return newBody
+}
+
+// rewriteMappedModel replaces the model of the request body with the name it maps to
+// through modelMapping, so the upstream receives the canonical model. Only the model
+// value is rewritten, the rest of the body is kept byte for byte.
+func rewriteMappedModel(config QuotaConfig, body []byte, modelName string, log wrapper.Log) {
+	if !config.RewriteMappedModel || modelName == "" {
+		return
+	}
+	mapped, exists := resolveMappedModel(config, modelName)
+	if !exists || mapped == modelName {
+		return
+	}
+	newBody, err := sjson.SetBytes(body, "model", mapped)
+	if err != nil {
+		log.Warnf("Failed to rewrite model %s to %s: %v", modelName, mapped, err)
+		return
+	}
+	if err := proxywasm.ReplaceHttpRequestBody(newBody); err != nil {
+		log.Warnf("Failed to replace request body with mapped model %s: %v", mapped, err)
+		return
+	}
+	log.Debugf("Rewrote model %s to mapped model %s", modelName, mapped)
+}
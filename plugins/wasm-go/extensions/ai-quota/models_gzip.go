@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"strings"
+)
+
+// defaultModelsGzipMinBytes is the smallest models response worth compressing
+const defaultModelsGzipMinBytes = 1024
+
+// acceptsGzip reports whether an Accept-Encoding header value allows gzip
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		// q=0 explicitly refuses the coding
+		if name, value, found := strings.Cut(strings.TrimSpace(params), "="); found && strings.TrimSpace(name) == "q" {
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// shouldGzipModels reports whether a models response body should be sent compressed
+func (c QuotaConfig) shouldGzipModels(body []byte, acceptEncoding string) bool {
+	return c.ModelsGzipMinBytes >= 0 && len(body) >= c.ModelsGzipMinBytes && acceptsGzip(acceptEncoding)
+}
+
+func gzipBytes(body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(body); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+// Levels whose counters served a request of an org member
+const (
+	QuotaLevelUser = "user"
+	QuotaLevelOrg  = "org"
+)
+
+func parseOrgConfig(json gjson.Result, config *QuotaConfig) {
+	config.UserOrgs = make(map[string]string)
+	json.Get("user_orgs").ForEach(func(key, value gjson.Result) bool {
+		if org := value.String(); org != "" {
+			config.UserOrgs[key.String()] = org
+		}
+		return true
+	})
+	config.OrgClaim = json.Get("org_claim").String()
+	config.RedisOrgPrefix = json.Get("redis_org_prefix").String()
+	if config.RedisOrgPrefix == "" {
+		config.RedisOrgPrefix = "chat_quota_org:"
+	}
+	config.RedisOrgUsedPrefix = json.Get("redis_org_used_prefix").String()
+	if config.RedisOrgUsedPrefix == "" {
+		config.RedisOrgUsedPrefix = "chat_quota_org_used:"
+	}
+	config.QuotaLevelHeader = json.Get("quota_level_header").String()
+	if config.QuotaLevelHeader == "" {
+		config.QuotaLevelHeader = "x-quota-level"
+	}
+}
+
+// userOrg returns the org of the user, taken from the org_claim of its token or else from user_orgs
+func userOrg(ctx wrapper.HttpContext, config QuotaConfig, userId string) (string, bool) {
+	if org := ctx.GetStringContext("userOrg", ""); org != "" {
+		return org, true
+	}
+	org, exists := config.UserOrgs[userId]
+	return org, exists
+}
+
+// orgQuotaCounters are the user's own quota, which serves the request when a positive total
+// is set, and the org's shared quota otherwise
+func orgQuotaCounters(config QuotaConfig, userId string, org string, modelName string) []wrapper.QuotaCounter {
+	return []wrapper.QuotaCounter{
+		{UsedKey: config.modelUsedKey(userId, modelName), LimitKey: config.modelTotalKey(userId, modelName)},
+		{UsedKey: config.orgUsedKey(org), LimitKey: config.orgKey(org)},
+	}
+}
+
+// doOrgQuotaCheck charges an org member, falling back to the org's shared quota when
+// the user has no quota of their own
+func doOrgQuotaCheck(ctx wrapper.HttpContext, config QuotaConfig, userId string, org string, quotaWeight int, modelName string, log wrapper.Log) {
+	counters := orgQuotaCounters(config, userId, org, modelName)
+	err := config.redisClient.MultiCounterDeduct(counters, quotaWeight, wrapper.DeductFirstFunded, func(response resp.Value) {
+		result, redisErr := wrapper.ParseMultiCounterDeduct(response, len(counters))
+		if redisErr != nil {
+			log.Errorf("Failed to check quota for user %s, org %s: %v", maskUserId(userId), org, redisErr)
+			config.sendJSONResponse(http.StatusInternalServerError, "quota-check.deduction_failed",
+				fmt.Sprintf("Quota deduction failed: %v", redisErr), false, nil)
+			return
+		}
+		remaining := result.Remaining
+		level := QuotaLevelOrg
+		if result.Refused == 0 || (result.Allowed && result.Taken[0] > 0) {
+			level = QuotaLevelUser
+		}
+		if !result.Allowed {
+			decisionMetrics.DeniedInsufficientQuota++
+			emitQuotaDecision(config, quotaDecision{userId: userId, modelName: modelName, weight: quotaWeight,
+				remaining: remaining, hasRemaining: true, decision: QuotaDecisionInsufficientQuota}, log)
+			if level == QuotaLevelOrg {
+				log.Warnf("Insufficient org quota for user %s, org %s: remaining=%d, required=%d", maskUserId(userId), org, remaining, quotaWeight)
+				sendInsufficientQuota(config, userId, modelName, "quota-check.insufficient_org_quota",
+					fmt.Sprintf("Insufficient org quota. Required: %s, Available: %s", config.formatQuota(quotaWeight), config.formatQuota(remaining)))
+				return
+			}
+			log.Warnf("Insufficient quota for user %s: remaining=%d, required=%d", maskUserId(userId), remaining, quotaWeight)
+			sendInsufficientQuota(config, userId, modelName, "quota-check.insufficient_quota",
+				fmt.Sprintf("Insufficient quota. Required: %s, Available: %s", config.formatQuota(quotaWeight), config.formatQuota(remaining)))
+			return
+		}
+		log.Infof("Successfully deducted %d quota for user %s from the %s level, org %s, model %s. Remaining: %d",
+			quotaWeight, maskUserId(userId), level, org, modelName, remaining)
+		decisionMetrics.Allowed++
+		emitQuotaDecision(config, quotaDecision{userId: userId, modelName: modelName, weight: quotaWeight,
+			remaining: remaining, hasRemaining: true, decision: QuotaDecisionAllowed}, log)
+		ctx.SetContext("quotaLevel", level)
+		appendUsageLog(config, userId, modelName, quotaWeight, level, ctx.GetStringContext("requestId", ""), log)
+		if level == QuotaLevelOrg {
+			recordCharge(ctx, config, userId, modelName, quotaWeight, counters[1].UsedKey)
+		} else {
+			recordCharge(ctx, config, userId, modelName, quotaWeight, counters[0].UsedKey)
+			checkAutoRefill(config, userId, modelName, remaining, log)
+		}
+		resumeAfterWriteAck(ctx, config, userId, log)
+	})
+	if err != nil {
+		log.Errorf("Failed to dispatch quota check for user %s, org %s: %v", maskUserId(userId), org, err)
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+	}
+}
+
+// setQuotaLevelHeader tells the client of an org member whether their own or the org's
+// quota served the request
+func setQuotaLevelHeader(ctx wrapper.HttpContext, config QuotaConfig, log wrapper.Log) {
+	level := ctx.GetStringContext("quotaLevel", "")
+	if level == "" {
+		return
+	}
+	if err := proxywasm.ReplaceHttpResponseHeader(config.QuotaLevelHeader, level); err != nil {
+		log.Warnf("Failed to set response header %s: %v", config.QuotaLevelHeader, err)
+	}
+}
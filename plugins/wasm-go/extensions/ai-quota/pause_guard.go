@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// pauseGuardTickMs is how often paused requests are checked against their deadline
+const pauseGuardTickMs = 1000
+
+// pauseGuard fails requests with a 503 when they stay paused past the deadline,
+// e.g. because a Redis callback was never invoked. Requests that already got a
+// local response are detected by their context being gone when the deadline fires.
+type pauseGuard struct {
+	timeoutMs int64
+	// requestIdHeader is echoed on the timeout responses, as on every local response
+	requestIdHeader string
+	// deadlines of the paused requests, keyed by http context id
+	deadlines map[uint32]int64
+}
+
+func newPauseGuard(timeoutMs int64, requestIdHeader string) *pauseGuard {
+	guard := &pauseGuard{
+		timeoutMs:       timeoutMs,
+		requestIdHeader: requestIdHeader,
+		deadlines:       make(map[uint32]int64),
+	}
+	wrapper.RegisteTickFunc(pauseGuardTickMs, guard.expire)
+	return guard
+}
+
+func (g *pauseGuard) track(contextID uint32) {
+	g.deadlines[contextID] = time.Now().UnixMilli() + g.timeoutMs
+}
+
+func (g *pauseGuard) release(contextID uint32) {
+	delete(g.deadlines, contextID)
+}
+
+func (g *pauseGuard) expire() {
+	now := time.Now().UnixMilli()
+	for contextID, deadline := range g.deadlines {
+		if now < deadline {
+			continue
+		}
+		delete(g.deadlines, contextID)
+		// The context is gone once the request has completed
+		if err := proxywasm.SetEffectiveContext(contextID); err != nil {
+			continue
+		}
+		proxywasm.LogWarnf("Request paused for more than %dms without a Redis callback, failing it", g.timeoutMs)
+		sendJSONResponseWithRequestId(g.requestIdHeader, http.StatusServiceUnavailable, "quota-check.pause_timeout",
+			"Quota check timed out", false, nil)
+	}
+}
+
+// guardRequestHeaders tracks requests paused by the request headers handler
+func guardRequestHeaders(handler func(wrapper.HttpContext, QuotaConfig, wrapper.Log) types.Action) func(wrapper.HttpContext, QuotaConfig, wrapper.Log) types.Action {
+	return func(ctx wrapper.HttpContext, config QuotaConfig, log wrapper.Log) types.Action {
+		action := handler(ctx, config, log)
+		if action == types.ActionPause {
+			config.pauseGuard.track(ctx.GetContextId())
+		}
+		return action
+	}
+}
+
+// guardRequestBody tracks requests paused by the request body handler
+func guardRequestBody(handler func(wrapper.HttpContext, QuotaConfig, []byte, wrapper.Log) types.Action) func(wrapper.HttpContext, QuotaConfig, []byte, wrapper.Log) types.Action {
+	return func(ctx wrapper.HttpContext, config QuotaConfig, body []byte, log wrapper.Log) types.Action {
+		action := handler(ctx, config, body, log)
+		if action == types.ActionPause {
+			config.pauseGuard.track(ctx.GetContextId())
+		}
+		return action
+	}
+}
+
+// resumeHttpRequest resumes a paused request and stops guarding it
+func resumeHttpRequest(ctx wrapper.HttpContext, config QuotaConfig) {
+	config.pauseGuard.release(ctx.GetContextId())
+	proxywasm.ResumeHttpRequest()
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/tidwall/gjson"
+)
+
+// PaymentRequired turns insufficient quota rejections into 402 responses carrying a top-up URL
+type PaymentRequired struct {
+	Enabled bool   `yaml:"enabled" json:"enabled"`
+	Url     string `yaml:"url" json:"url"`
+}
+
+func parsePaymentRequired(json gjson.Result, config *QuotaConfig) error {
+	paymentConfig := json.Get("payment_required")
+	config.PaymentRequired = PaymentRequired{
+		Enabled: paymentConfig.Get("enabled").Bool(),
+		Url:     paymentConfig.Get("url").String(),
+	}
+	if config.PaymentRequired.Enabled && config.PaymentRequired.Url == "" {
+		return errors.New("payment_required.url must not be empty when payment_required is enabled")
+	}
+	return nil
+}
+
+// sendInsufficientQuota rejects a request the user's or team's quota can't cover,
+// with 402 and the payment URL when payment_required is enabled and 403 otherwise,
+// and queues the exhaustion webhook when one is configured
+func sendInsufficientQuota(config QuotaConfig, userId string, modelName string, code string, message string) {
+	if config.exhaustionWebhook != nil {
+		config.exhaustionWebhook.notify(userId, modelName)
+	}
+	if config.PaymentRequired.Enabled {
+		config.sendJSONResponse(http.StatusPaymentRequired, code, message, false, map[string]string{
+			"payment_url": config.PaymentRequired.Url,
+		})
+		return
+	}
+	config.sendJSONResponse(http.StatusForbidden, code, message, false, nil)
+}
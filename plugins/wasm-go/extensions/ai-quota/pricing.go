@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tidwall/gjson"
+)
+
+// Pricing modes
+const (
+	// PricingModeWeight charges the abstract model_quota_weights
+	PricingModeWeight = "weight"
+	// PricingModeCurrency charges model_prices in micro-dollars against a balance in dollars
+	PricingModeCurrency = "currency"
+)
+
+// currencyPrecision makes one quota unit a micro-dollar
+const currencyPrecision = 6
+
+// parsePricingMode must run before amounts are parsed, since currency mode fixes the precision
+func parsePricingMode(json gjson.Result, config *QuotaConfig) error {
+	config.PricingMode = json.Get("pricing_mode").String()
+	switch config.PricingMode {
+	case "":
+		config.PricingMode = PricingModeWeight
+	case PricingModeWeight:
+	case PricingModeCurrency:
+		if precision := json.Get("quota_precision"); precision.Exists() && precision.Int() != currencyPrecision {
+			return fmt.Errorf("quota_precision must be %d in currency pricing mode", currencyPrecision)
+		}
+		if json.Get("model_quota_weights").Exists() {
+			return errors.New("model_quota_weights can't be used in currency pricing mode, use model_prices")
+		}
+	default:
+		return fmt.Errorf("unsupported pricing_mode %s", config.PricingMode)
+	}
+	return nil
+}
+
+// parseModelPrices loads model_prices as the weights charged in currency mode.
+// Prices are whole micro-dollars, which is exactly one quota unit at this precision.
+func parseModelPrices(json gjson.Result, config *QuotaConfig) error {
+	config.ModelPrices = make(map[string]int)
+	if config.PricingMode != PricingModeCurrency {
+		return nil
+	}
+	var priceErr error
+	json.Get("model_prices").ForEach(func(key, value gjson.Result) bool {
+		price := value.Int()
+		if value.Type != gjson.Number || float64(price) != value.Float() || price < 0 {
+			priceErr = fmt.Errorf("price for model %s must be a non-negative integer of micro-dollars", key.String())
+			return false
+		}
+		config.ModelPrices[key.String()] = int(price)
+		config.ModelQuotaWeights[key.String()] = int(price)
+		return true
+	})
+	return priceErr
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxQuotaPrecision bounds quota_precision so scaled amounts stay well within int range
+const maxQuotaPrecision = 6
+
+// Quota amounts are stored in Redis as integers counted in units of
+// 10^-quota_precision. Keeping Redis values integral lets INCRBY/DECRBY and
+// the Lua scripts operate on them exactly, without float rounding drift.
+
+// parseQuotaAmount converts a decimal string such as "1.25" into quota units
+func parseQuotaAmount(s string, precision int) (int, error) {
+	s = strings.TrimSpace(s)
+	sign := ""
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		sign, s = s[:1], s[1:]
+	}
+	intPart, fracPart, hasFrac := strings.Cut(s, ".")
+	if intPart == "" && fracPart == "" {
+		return 0, errors.New("empty quota amount")
+	}
+	if hasFrac && fracPart == "" {
+		return 0, fmt.Errorf("invalid quota amount %q", s)
+	}
+	if !isDigits(intPart) || !isDigits(fracPart) {
+		return 0, fmt.Errorf("invalid quota amount %q", s)
+	}
+	fracPart = strings.TrimRight(fracPart, "0")
+	if len(fracPart) > precision {
+		return 0, fmt.Errorf("quota amount %q has more than %d decimal places", s, precision)
+	}
+	if intPart == "" {
+		intPart = "0"
+	}
+	digits := intPart + fracPart + strings.Repeat("0", precision-len(fracPart))
+	units, err := strconv.Atoi(sign + digits)
+	if err != nil {
+		return 0, fmt.Errorf("quota amount %q out of range", s)
+	}
+	return units, nil
+}
+
+// formatQuotaAmount converts quota units back into their shortest decimal form
+func formatQuotaAmount(units int, precision int) string {
+	if precision == 0 {
+		return strconv.Itoa(units)
+	}
+	sign := ""
+	if units < 0 {
+		sign, units = "-", -units
+	}
+	scale := quotaScale(precision)
+	frac := strings.TrimRight(fmt.Sprintf("%0*d", precision, units%scale), "0")
+	if frac == "" {
+		return sign + strconv.Itoa(units/scale)
+	}
+	return sign + strconv.Itoa(units/scale) + "." + frac
+}
+
+func quotaScale(precision int) int {
+	scale := 1
+	for i := 0; i < precision; i++ {
+		scale *= 10
+	}
+	return scale
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func (c QuotaConfig) parseQuota(s string) (int, error) {
+	return parseQuotaAmount(s, c.QuotaPrecision)
+}
+
+func (c QuotaConfig) formatQuota(units int) string {
+	return formatQuotaAmount(units, c.QuotaPrecision)
+}
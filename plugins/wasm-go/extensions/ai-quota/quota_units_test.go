@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuotaAmount(t *testing.T) {
+	var tests = []struct {
+		input     string
+		precision int
+		units     int
+		wantErr   bool
+	}{
+		{"1.25", 2, 125, false},
+		{"-0.5", 2, -50, false},
+		{".5", 2, 50, false},
+		{"+3", 2, 300, false},
+		{" 2 ", 2, 200, false},
+		{"1.250", 2, 125, false},
+		{"0", 2, 0, false},
+		{"1.", 2, 0, true},
+		{"1.234", 2, 0, true},
+		{"99999999999999999999", 2, 0, true},
+		{"", 2, 0, true},
+		{"-", 2, 0, true},
+		{".", 2, 0, true},
+		{"abc", 2, 0, true},
+		{"1.2.3", 2, 0, true},
+		{"1e3", 2, 0, true},
+		{"7", 0, 7, false},
+		{"-7", 0, -7, false},
+		{"7.0", 0, 7, false},
+		{"7.5", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			units, err := parseQuotaAmount(tt.input, tt.precision)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.units, units)
+		})
+	}
+}
+
+func TestFormatQuotaAmount(t *testing.T) {
+	var tests = []struct {
+		units     int
+		precision int
+		output    string
+	}{
+		{125, 2, "1.25"},
+		{-50, 2, "-0.5"},
+		{300, 2, "3"},
+		{5, 2, "0.05"},
+		{-5, 2, "-0.05"},
+		{0, 2, "0"},
+		{-300, 2, "-3"},
+		{7, 0, "7"},
+		{-7, 0, "-7"},
+		{1000001, 6, "1.000001"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.output, func(t *testing.T) {
+			output := formatQuotaAmount(tt.units, tt.precision)
+			assert.Equal(t, tt.output, output)
+			units, err := parseQuotaAmount(output, tt.precision)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.units, units)
+		})
+	}
+}
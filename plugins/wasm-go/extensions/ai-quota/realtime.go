@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+)
+
+func parseRealtimeConfig(json gjson.Result, config *QuotaConfig) {
+	config.RealtimePaths = make([]string, 0)
+	for _, realtimePath := range json.Get("realtime_paths").Array() {
+		if realtimePath.String() != "" {
+			config.RealtimePaths = append(config.RealtimePaths, realtimePath.String())
+		}
+	}
+	config.RealtimeModelParam = json.Get("realtime_model_param").String()
+	if config.RealtimeModelParam == "" {
+		config.RealtimeModelParam = "model"
+	}
+}
+
+// isRealtime reports whether the request sets up a realtime session, whose model was
+// taken from the setup request's query string
+func isRealtime(ctx wrapper.HttpContext) bool {
+	_, exists := ctx.GetContext("realtimeModel").(string)
+	return exists
+}
+
+// requestModelName returns the model of a completion request, read from the body or,
+// for realtime sessions, the one cached when the session was set up
+func requestModelName(ctx wrapper.HttpContext, body []byte) string {
+	if model, exists := ctx.GetContext("realtimeModel").(string); exists {
+		return model
+	}
+	return gjson.GetBytes(body, "model").String()
+}
+
+// readCompletionBody continues a completion request once its user is known. The body is
+// buffered to read the model, except for realtime sessions: their connection upgrade has
+// no body and the following frames are opaque, so the session is charged right away.
+func readCompletionBody(ctx wrapper.HttpContext, config QuotaConfig, log wrapper.Log) types.Action {
+	if isRealtime(ctx) {
+		ctx.DontReadRequestBody()
+		return handleCompletionQuota(ctx, config, nil, log)
+	}
+	// Note: ai-proxy plugin (priority 100) may have already buffered the request body
+	// This call is safe and won't conflict with existing buffering
+	ctx.BufferRequestBody()
+	return types.HeaderStopIteration
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+type refillEvent struct {
+	UserId    string      `json:"user_id"`
+	Model     string      `json:"model"`
+	Remaining json.Number `json:"remaining"`
+	Threshold json.Number `json:"threshold"`
+	Timestamp int64       `json:"timestamp"`
+}
+
+// refillWebhook asks an external endpoint to top up a user whose remaining quota dropped
+// below the auto refill threshold. Like the exhaustion webhook, events are queued by the
+// request and sent from the root context tick. Notifications are deduplicated across VMs
+// with a Redis key, so a refill in progress isn't requested again by every VM.
+type refillWebhook struct {
+	client  wrapper.HttpClient
+	path    string
+	pending []refillEvent
+}
+
+func parseAutoRefillWebhook(json gjson.Result, config *QuotaConfig) error {
+	config.AutoRefillWebhookUrl = json.Get("auto_refill_webhook_url").String()
+	if config.AutoRefillWebhookUrl == "" {
+		return nil
+	}
+	client, path, err := newWebhookClient(config, config.AutoRefillWebhookUrl)
+	if err != nil {
+		return fmt.Errorf("invalid auto_refill_webhook_url: %w", err)
+	}
+	threshold := json.Get("auto_refill_threshold")
+	if threshold.Float() <= 0 {
+		return errors.New("auto_refill_threshold must be positive when auto_refill_webhook_url is set")
+	}
+	config.AutoRefillThreshold, err = config.parseQuota(strconv.FormatFloat(threshold.Float(), 'f', -1, 64))
+	if err != nil {
+		return fmt.Errorf("invalid auto_refill_threshold: %w", err)
+	}
+	config.AutoRefillInterval = int(json.Get("auto_refill_interval").Int())
+	if config.AutoRefillInterval < 0 {
+		return errors.New("auto_refill_interval must not be negative")
+	}
+	if config.AutoRefillInterval == 0 {
+		config.AutoRefillInterval = 300
+	}
+	config.RedisRefillPrefix = json.Get("redis_refill_prefix").String()
+	if config.RedisRefillPrefix == "" {
+		config.RedisRefillPrefix = "chat_quota_refill:"
+	}
+
+	config.refillWebhook = &refillWebhook{
+		client: client,
+		path:   path,
+	}
+	wrapper.RegisteTickFunc(exhaustionWebhookTickMs, config.refillWebhook.flush)
+	return nil
+}
+
+// checkAutoRefill queues a refill notification when a deduction left the user below the
+// auto refill threshold and no notification was sent within the interval. It never holds
+// up the request.
+func checkAutoRefill(config QuotaConfig, userId string, modelName string, remaining int, log wrapper.Log) {
+	if config.refillWebhook == nil || remaining >= config.AutoRefillThreshold {
+		return
+	}
+	err := config.redisClient.SetNX(config.refillKey(userId), time.Now().Unix(), config.AutoRefillInterval, func(response resp.Value) {
+		if wrapper.IsRedisErrorResponse(response) {
+			log.Warnf("Failed to check auto refill of user %s: %v", maskUserId(userId), wrapper.GetRedisErrorFromResponse(response))
+			return
+		}
+		// Someone else already requested a refill within the interval
+		if response.IsNull() {
+			return
+		}
+		config.refillWebhook.notify(refillEvent{
+			UserId:    userId,
+			Model:     modelName,
+			Remaining: json.Number(config.formatQuota(remaining)),
+			Threshold: json.Number(config.formatQuota(config.AutoRefillThreshold)),
+			Timestamp: time.Now().Unix(),
+		})
+	})
+	if err != nil {
+		log.Warnf("Failed to dispatch auto refill check of user %s: %v", maskUserId(userId), err)
+	}
+}
+
+func (w *refillWebhook) notify(event refillEvent) {
+	if len(w.pending) >= exhaustionWebhookMaxPending {
+		proxywasm.LogWarnf("Auto refill webhook queue is full, dropping notification for user %s", maskUserId(event.UserId))
+		return
+	}
+	w.pending = append(w.pending, event)
+}
+
+func (w *refillWebhook) flush() {
+	if len(w.pending) == 0 {
+		return
+	}
+	headers := [][2]string{{"content-type", "application/json"}}
+	for _, event := range w.pending {
+		body, _ := json.Marshal(event)
+		userId := event.UserId
+		err := w.client.Post(w.path, headers, body, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+			if statusCode < 200 || statusCode >= 300 {
+				proxywasm.LogWarnf("Auto refill webhook for user %s returned status %d", maskUserId(userId), statusCode)
+			}
+		}, exhaustionWebhookTimeoutMs)
+		if err != nil {
+			proxywasm.LogWarnf("Failed to dispatch auto refill webhook for user %s: %v", maskUserId(userId), err)
+		}
+	}
+	w.pending = w.pending[:0]
+}
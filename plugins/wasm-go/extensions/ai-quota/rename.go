@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/resp"
+)
+
+// renameUserScript moves the source keys KEYS[1..n] to the destination keys
+// KEYS[n+1..2n] all at once. Nothing is moved when the source has no keys or any
+// destination key exists already. Returns the number of keys moved.
+const renameUserScript = `
+	local n = #KEYS / 2
+	local found = 0
+	for i = 1, n do
+		if redis.call('exists', KEYS[n + i]) == 1 then
+			return redis.error_reply('EXISTS ' .. KEYS[n + i])
+		end
+		found = found + redis.call('exists', KEYS[i])
+	end
+	if found == 0 then
+		return redis.error_reply('NOTFOUND user has no quota keys')
+	end
+	local renamed = 0
+	for i = 1, n do
+		if redis.call('exists', KEYS[i]) == 1 then
+			renamed = renamed + redis.call('renamenx', KEYS[i], KEYS[n + i])
+		end
+	end
+	return renamed
+`
+
+// userKeys lists the keys moved when a user is renamed
+func (c QuotaConfig) userKeys(userId string) []interface{} {
+	return []interface{}{c.totalKey(userId), c.usedKey(userId), c.starKey(userId)}
+}
+
+// renameUser moves the total, used and star keys of user_id to new_user_id, for account
+// merges. It fails without moving anything when new_user_id already has any of them.
+func renameUser(config QuotaConfig, body string, log wrapper.Log) types.Action {
+	queryValues, _ := url.ParseQuery(body)
+	userId := queryValues.Get("user_id")
+	newUserId := queryValues.Get("new_user_id")
+	if userId == "" || newUserId == "" || userId == newUserId {
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id and new_user_id can't be empty and must differ.", false, nil)
+		return types.ActionContinue
+	}
+
+	keys := append(config.userKeys(userId), config.userKeys(newUserId)...)
+	err := config.redisClient.Eval(renameUserScript, len(keys), keys, nil, func(response resp.Value) {
+		if err := response.Error(); err != nil {
+			switch {
+			case strings.HasPrefix(err.Error(), "EXISTS "):
+				log.Warnf("Refusing to rename user %s to %s, the new user already has quota keys", maskUserId(userId), maskUserId(newUserId))
+				config.sendJSONResponse(http.StatusConflict, "ai-gateway.user_exists",
+					fmt.Sprintf("User %s already has quota keys", maskUserId(newUserId)), false, nil)
+			case strings.HasPrefix(err.Error(), "NOTFOUND "):
+				config.sendJSONResponse(http.StatusNotFound, "ai-gateway.user_not_found",
+					fmt.Sprintf("User %s has no quota keys", maskUserId(userId)), false, nil)
+			default:
+				config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+			}
+			return
+		}
+		log.Infof("Renamed %d quota keys of user %s to %s", response.Integer(), maskUserId(userId), maskUserId(newUserId))
+		// The cached star status belongs to the old id now
+		config.starCache.remove(userId)
+		data := map[string]interface{}{
+			"user_id":      userId,
+			"new_user_id":  newUserId,
+			"renamed_keys": response.Integer(),
+		}
+		config.sendJSONResponse(http.StatusOK, "ai-gateway.renameuser", "rename user successful", true, data)
+	})
+	if err != nil {
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
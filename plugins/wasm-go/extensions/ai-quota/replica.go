@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+// redisRead issues one read-only command against the given client
+type redisRead func(client wrapper.RedisClient, callback wrapper.RedisResponseCallback) error
+
+// parseReplicaConfig sets up the client used by the query endpoints, sharing the
+// primary's credentials. The quota check path never reads from it.
+func parseReplicaConfig(redisConfig gjson.Result, config *QuotaConfig, username, password string, timeout, database int) error {
+	replicaConfig := redisConfig.Get("replica")
+	if !replicaConfig.Exists() {
+		return nil
+	}
+	replica := RedisBackend{
+		ServiceName: replicaConfig.Get("service_name").String(),
+		ServicePort: int(replicaConfig.Get("service_port").Int()),
+	}
+	if replica.ServiceName == "" {
+		return errors.New("redis replica service name must not be empty")
+	}
+	if replica.ServicePort == 0 {
+		if strings.HasSuffix(replica.ServiceName, ".static") {
+			replica.ServicePort = 80
+		} else {
+			replica.ServicePort = 6379
+		}
+	}
+	config.redisInfo.Replica = &replica
+	config.replicaClient = wrapper.NewRedisClusterClient(wrapper.FQDNCluster{
+		FQDN: replica.ServiceName,
+		Port: int64(replica.ServicePort),
+	})
+	return config.replicaClient.Init(username, password, int64(timeout), wrapper.WithDataBase(database))
+}
+
+// replicaRead runs read against the replica when one is configured, falling back to
+// the primary when the replica returns an error or can't be reached
+func (c QuotaConfig) replicaRead(read redisRead, callback wrapper.RedisResponseCallback, log wrapper.Log) error {
+	if c.replicaClient == nil {
+		return read(c.redisClient, callback)
+	}
+	err := read(c.replicaClient, func(response resp.Value) {
+		if !wrapper.IsRedisErrorResponse(response) {
+			callback(response)
+			return
+		}
+		log.Warnf("Replica read failed, falling back to primary: %v", wrapper.GetRedisErrorFromResponse(response))
+		if err := read(c.redisClient, callback); err != nil {
+			log.Errorf("Failed to fall back to primary read: %v", err)
+			callback(response)
+		}
+	})
+	if err != nil {
+		log.Warnf("Failed to dispatch replica read, falling back to primary: %v", err)
+		return read(c.redisClient, callback)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/google/uuid"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+)
+
+// ensureRequestId reuses the incoming request id or generates one, writing it back
+// to the request so upstream logs and plugin responses share the same id
+func ensureRequestId(ctx wrapper.HttpContext, config QuotaConfig, log wrapper.Log) {
+	requestId, err := proxywasm.GetHttpRequestHeader(config.RequestIdHeader)
+	if err != nil || requestId == "" {
+		requestId = uuid.New().String()
+		if err := proxywasm.ReplaceHttpRequestHeader(config.RequestIdHeader, requestId); err != nil {
+			log.Warnf("Failed to set request id header %s: %v", config.RequestIdHeader, err)
+		}
+	}
+	ctx.SetContext("requestId", requestId)
+}
+
+// onHttpResponseHeaders echoes the request id on upstream responses of requests that passed the quota check,
+// reports the quota level that served org members, and starts reading the token usage of
+// responses billed by tokens
+func onHttpResponseHeaders(ctx wrapper.HttpContext, config QuotaConfig, log wrapper.Log) types.Action {
+	config = tenantConfig(ctx, config)
+	startUsageTracking(ctx, config)
+	setQuotaLevelHeader(ctx, config, log)
+	if requestId := ctx.GetStringContext("requestId", ""); requestId != "" {
+		if err := proxywasm.ReplaceHttpResponseHeader(config.RequestIdHeader, requestId); err != nil {
+			log.Warnf("Failed to set response header %s: %v", config.RequestIdHeader, err)
+		}
+	}
+	return types.ActionContinue
+}
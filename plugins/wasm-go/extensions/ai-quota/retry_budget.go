@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+func parseRetryBudgetConfig(json gjson.Result, config *QuotaConfig) error {
+	config.RedisRetryBudget = int(json.Get("redis_retry_budget").Int())
+	if config.RedisRetryBudget < 0 {
+		return errors.New("redis_retry_budget must not be negative")
+	}
+	return nil
+}
+
+// takeRetry spends one retry of the request's budget, shared by all its Redis calls so a
+// flaky Redis adds at most redis_retry_budget round trips to a request
+func takeRetry(ctx wrapper.HttpContext, config QuotaConfig) bool {
+	budget, ok := ctx.GetContext("retryBudget").(int)
+	if !ok {
+		budget = config.RedisRetryBudget
+	}
+	if budget <= 0 {
+		return false
+	}
+	ctx.SetContext("retryBudget", budget-1)
+	return true
+}
+
+// retryingGet reads the key, re-issuing the GET right away on a retryable error while the
+// request's retry budget lasts. Only reads are retried, a timed out write may have been
+// applied already.
+func retryingGet(ctx wrapper.HttpContext, config QuotaConfig, userId string, key string, log wrapper.Log, callback wrapper.RedisResponseCallback) error {
+	return config.redisClient.Get(key, func(response resp.Value) {
+		if wrapper.IsRedisErrorResponse(response) && wrapper.IsRetryableError(wrapper.GetRedisErrorFromResponse(response)) && takeRetry(ctx, config) {
+			log.Warnf("Retrying quota read of user %s after: %v", maskUserId(userId), wrapper.GetRedisErrorFromResponse(response))
+			if err := retryingGet(ctx, config, userId, key, log, callback); err == nil {
+				return
+			}
+		}
+		callback(response)
+	})
+}
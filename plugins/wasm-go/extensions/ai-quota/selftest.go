@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/resp"
+)
+
+// selfTestKeyTTL bounds the life of the throwaway key should the test stop before DEL
+const selfTestKeyTTL = 60
+
+// selfTestStep is a Redis command of the self-test and the check of its reply
+type selfTestStep struct {
+	name  string
+	run   func(key string, callback wrapper.RedisResponseCallback) error
+	check func(response resp.Value) error
+}
+
+type selfTestResult struct {
+	Step       string  `json:"step"`
+	Success    bool    `json:"success"`
+	DurationMs float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func expectReply(expected string) func(response resp.Value) error {
+	return func(response resp.Value) error {
+		if response.String() != expected {
+			return fmt.Errorf("expected %s, got %s", expected, response.String())
+		}
+		return nil
+	}
+}
+
+// selfTest round-trips a throwaway key through SET, GET, INCRBY and DEL, reporting the
+// outcome and latency of each step along with the Redis backend states. It stops at the
+// first failing step; the key expires on its own then.
+func selfTest(config QuotaConfig, log wrapper.Log) types.Action {
+	client := config.redisClient
+	steps := []selfTestStep{
+		{"set", func(key string, callback wrapper.RedisResponseCallback) error {
+			return client.SetEx(key, 1, selfTestKeyTTL, callback)
+		}, expectReply("OK")},
+		{"get", client.Get, expectReply("1")},
+		{"incrby", func(key string, callback wrapper.RedisResponseCallback) error {
+			return client.IncrBy(key, 1, callback)
+		}, expectReply("2")},
+		{"del", client.Del, expectReply("1")},
+	}
+	key := config.selfTestKey(strconv.FormatInt(time.Now().UnixNano(), 10))
+	results := make([]selfTestResult, 0, len(steps))
+
+	finish := func() {
+		backends := make([]map[string]interface{}, 0)
+		for _, backend := range wrapper.GetBackendStates() {
+			backends = append(backends, map[string]interface{}{
+				"name":   backend.Name,
+				"state":  backend.State,
+				"active": backend.Active,
+			})
+		}
+		passed := len(results) == len(steps) && results[len(results)-1].Success
+		data := map[string]interface{}{
+			"steps":          results,
+			"redis_ready":    client.Ready(),
+			"redis_backends": backends,
+		}
+		if !passed {
+			config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.selftest_failed", "self-test failed", false, data)
+			return
+		}
+		config.sendJSONResponse(http.StatusOK, "ai-gateway.selftest", "self-test passed", true, data)
+	}
+
+	var runStep func(i int) bool
+	runStep = func(i int) bool {
+		step := steps[i]
+		start := time.Now()
+		fail := func(err error) {
+			log.Warnf("Self-test step %s failed: %v", step.name, err)
+			results = append(results, selfTestResult{Step: step.name, DurationMs: elapsedMs(start), Error: err.Error()})
+		}
+		err := step.run(key, func(response resp.Value) {
+			if err := response.Error(); err != nil {
+				fail(err)
+			} else if err := step.check(response); err != nil {
+				fail(err)
+			} else {
+				results = append(results, selfTestResult{Step: step.name, Success: true, DurationMs: elapsedMs(start)})
+				if i+1 < len(steps) && runStep(i+1) {
+					return
+				}
+			}
+			finish()
+		})
+		if err != nil {
+			fail(err)
+			return false
+		}
+		return true
+	}
+	if !runStep(0) {
+		finish()
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
+
+func elapsedMs(start time.Time) float64 {
+	return float64(time.Since(start).Microseconds()) / 1000
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+// Strategies that can shadow the read-then-write quota check
+const (
+	ShadowStrategyAtomic = "atomic"
+)
+
+// atomicCheckScript makes the allow/deny decision of the quota check in one script without
+// deducting anything. Returns {allowed, remaining}.
+const atomicCheckScript = `
+	local remaining = (tonumber(redis.call('get', KEYS[1])) or 0) - (tonumber(redis.call('get', KEYS[2])) or 0)
+	if remaining >= tonumber(ARGV[1]) then
+		return {1, remaining}
+	end
+	return {0, remaining}
+`
+
+func parseShadowConfig(json gjson.Result, config *QuotaConfig) error {
+	config.ShadowStrategy = json.Get("shadow_strategy").String()
+	if config.ShadowStrategy != "" && config.ShadowStrategy != ShadowStrategyAtomic {
+		return fmt.Errorf("invalid shadow_strategy %s, must be %s", config.ShadowStrategy, ShadowStrategyAtomic)
+	}
+	return nil
+}
+
+type shadowVerdict struct {
+	allowed   bool
+	remaining int
+}
+
+// shadowComparison holds the decisions of the primary and the shadow strategy for one
+// request, compared once both have arrived
+type shadowComparison struct {
+	userId    string
+	modelName string
+	weight    int
+	primary   *shadowVerdict
+	shadow    *shadowVerdict
+}
+
+// startShadowCheck runs the shadow strategy next to the quota check of the user. It's
+// dispatched first, so both strategies read the counters before the deduction.
+func startShadowCheck(ctx wrapper.HttpContext, config QuotaConfig, userId string, totalKey string, usedKey string, quotaWeight int, modelName string, log wrapper.Log) {
+	if config.ShadowStrategy == "" {
+		return
+	}
+	comparison := &shadowComparison{userId: userId, modelName: modelName, weight: quotaWeight}
+	keys := []interface{}{totalKey, usedKey}
+	err := config.redisClient.Eval(atomicCheckScript, len(keys), keys, []interface{}{quotaWeight}, func(response resp.Value) {
+		if wrapper.IsRedisErrorResponse(response) || len(response.Array()) < 2 {
+			decisionMetrics.ShadowFailed++
+			log.Warnf("Shadow quota check failed for user %s: %v", maskUserId(userId), wrapper.GetRedisErrorFromResponse(response))
+			return
+		}
+		result := response.Array()
+		comparison.shadow = &shadowVerdict{allowed: result[0].Integer() == 1, remaining: result[1].Integer()}
+		comparison.compare(config, log)
+	})
+	if err != nil {
+		decisionMetrics.ShadowFailed++
+		log.Warnf("Failed to dispatch shadow quota check for user %s: %v", maskUserId(userId), err)
+		return
+	}
+	ctx.SetContext("shadowComparison", comparison)
+}
+
+// recordPrimaryDecision hands the decision of the read-then-write check to the comparison.
+// The primary decision is the one enforced, whatever the shadow decides.
+func recordPrimaryDecision(ctx wrapper.HttpContext, config QuotaConfig, allowed bool, remaining int, log wrapper.Log) {
+	comparison, ok := ctx.GetContext("shadowComparison").(*shadowComparison)
+	if !ok {
+		return
+	}
+	comparison.primary = &shadowVerdict{allowed: allowed, remaining: remaining}
+	comparison.compare(config, log)
+}
+
+func (s *shadowComparison) compare(config QuotaConfig, log wrapper.Log) {
+	if s.primary == nil || s.shadow == nil {
+		return
+	}
+	if s.primary.allowed == s.shadow.allowed {
+		decisionMetrics.ShadowAgreed++
+		return
+	}
+	decisionMetrics.ShadowDiverged++
+	log.Warnf("Shadow %s strategy disagrees on user %s, model %s, weight %s: primary allowed=%t remaining=%s, shadow allowed=%t remaining=%s",
+		config.ShadowStrategy, maskUserId(s.userId), s.modelName, config.formatQuota(s.weight),
+		s.primary.allowed, config.formatQuota(s.primary.remaining), s.shadow.allowed, config.formatQuota(s.shadow.remaining))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// starCache is a size bounded LRU set of users known to have starred the project
+type starCache struct {
+	maxSize int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type starCacheEntry struct {
+	userId    string
+	expiresAt int64 // unix seconds, 0 never expires
+}
+
+func newStarCache(maxSize int) *starCache {
+	return &starCache{
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// contains reports whether the user is cached and not expired, marking it as recently used
+func (c *starCache) contains(userId string) bool {
+	elem, exists := c.entries[userId]
+	if !exists {
+		return false
+	}
+	entry := elem.Value.(*starCacheEntry)
+	if entry.expiresAt > 0 && time.Now().Unix() >= entry.expiresAt {
+		c.remove(userId)
+		return false
+	}
+	c.order.MoveToFront(elem)
+	return true
+}
+
+// add caches the user for ttlSeconds (0 never expires), evicting the least recently
+// used entry when full
+func (c *starCache) add(userId string, ttlSeconds int) {
+	var expiresAt int64
+	if ttlSeconds > 0 {
+		expiresAt = time.Now().Unix() + int64(ttlSeconds)
+	}
+	if elem, exists := c.entries[userId]; exists {
+		elem.Value.(*starCacheEntry).expiresAt = expiresAt
+		c.order.MoveToFront(elem)
+		return
+	}
+	if c.maxSize > 0 && c.order.Len() >= c.maxSize {
+		if oldest := c.order.Back(); oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*starCacheEntry).userId)
+		}
+	}
+	c.entries[userId] = c.order.PushFront(&starCacheEntry{userId: userId, expiresAt: expiresAt})
+}
+
+func (c *starCache) remove(userId string) {
+	if elem, exists := c.entries[userId]; exists {
+		c.order.Remove(elem)
+		delete(c.entries, userId)
+	}
+}
+
+func (c *starCache) size() int {
+	return c.order.Len()
+}
+
+func parseStarCacheTTLConfig(json gjson.Result, config *QuotaConfig) error {
+	config.StarCacheTTL = int(json.Get("star_cache_ttl").Int())
+	if config.StarCacheTTL < 0 {
+		return errors.New("star_cache_ttl must not be negative")
+	}
+	config.TierClaim = json.Get("tier_claim").String()
+	if config.TierClaim == "" {
+		config.TierClaim = "tier"
+	}
+	config.StarCacheTierTTLs = make(map[string]int)
+	for tier, ttl := range json.Get("star_cache_tier_ttls").Map() {
+		if ttl.Int() < 0 {
+			return errors.New("star_cache_tier_ttls must not contain negative values")
+		}
+		config.StarCacheTierTTLs[tier] = int(ttl.Int())
+	}
+	return nil
+}
+
+// starCacheTTL resolves how long a cached star status of a user in the tier is trusted,
+// falling back to star_cache_ttl for unknown or missing tiers
+func (c QuotaConfig) starCacheTTL(tier string) int {
+	if ttl, exists := c.StarCacheTierTTLs[tier]; exists && tier != "" {
+		return ttl
+	}
+	return c.StarCacheTTL
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+)
+
+func parseRefundConfig(json gjson.Result, config *QuotaConfig) error {
+	config.RefundOnCancel = json.Get("refund_on_cancel").Bool()
+	config.RefundReservedTokens = int(json.Get("refund_reserved_tokens").Int())
+	if config.RefundReservedTokens < 0 {
+		return errors.New("refund_reserved_tokens must not be negative")
+	}
+	return nil
+}
+
+// recordReservedTokens remembers the output token limit of the request, which a cancelled
+// stream is measured against. Requests without one fall back to refund_reserved_tokens.
+func recordReservedTokens(ctx wrapper.HttpContext, config QuotaConfig, body []byte) {
+	if !config.RefundOnCancel {
+		return
+	}
+	reserved := config.RefundReservedTokens
+	for _, field := range []string{"max_completion_tokens", "max_tokens"} {
+		if limit := gjson.GetBytes(body, field); limit.Exists() && limit.Int() > 0 {
+			reserved = int(limit.Int())
+			break
+		}
+	}
+	ctx.SetContext("reservedTokens", reserved)
+}
+
+// deliveredTokens is the number of output tokens the client received. Streams reporting
+// cumulative output tokens are taken at their word, otherwise every event counts as a token.
+func (t *usageTracker) deliveredTokens() int {
+	if t.usage.Found && t.usage.Output > 0 {
+		return t.usage.Output
+	}
+	return t.events
+}
+
+// refundCancelledStream gives back the share of the charge the client never received when
+// a stream ends before its last chunk. The counters are decremented no lower than zero.
+func refundCancelledStream(ctx wrapper.HttpContext, config QuotaConfig, log wrapper.Log) {
+	if !config.RefundOnCancel || ctx.GetBoolContext("responseComplete", false) {
+		return
+	}
+	charge, ok := ctx.GetContext("quotaCharge").(*quotaCharge)
+	if !ok || charge.weight <= 0 {
+		return
+	}
+	tracker, ok := ctx.GetContext("usageTracker").(*usageTracker)
+	if !ok || !tracker.sse {
+		return
+	}
+	reserved, _ := ctx.GetContext("reservedTokens").(int)
+	if reserved <= 0 {
+		log.Debugf("Stream of user %s for model %s was cancelled without a reserved token count, keeping the full charge",
+			maskUserId(charge.userId), charge.modelName)
+		return
+	}
+	delivered := tracker.deliveredTokens()
+	if delivered >= reserved {
+		return
+	}
+	refund := charge.weight - config.roundCost(float64(charge.weight)*float64(delivered)/float64(reserved))
+	if refund <= 0 {
+		return
+	}
+	log.Infof("Stream of user %s for model %s was cancelled after %d of %d tokens, refunding %s",
+		maskUserId(charge.userId), charge.modelName, delivered, reserved, config.formatQuota(refund))
+	for _, key := range charge.keys {
+		key := key
+		err := config.redisClient.DecrByFloor(key, refund, 0, func(value int, clamped bool, err error) {
+			if err != nil {
+				log.Errorf("Failed to refund the cancelled stream of user %s on %s: %v", maskUserId(charge.userId), maskUserIdInKey(key, charge.userId), err)
+				return
+			}
+			if clamped {
+				log.Warnf("Refund of the cancelled stream of user %s on %s was clamped at zero", maskUserId(charge.userId), maskUserIdInKey(key, charge.userId))
+			}
+		})
+		if err != nil {
+			log.Errorf("Failed to refund the cancelled stream of user %s on %s: %v", maskUserId(charge.userId), maskUserIdInKey(key, charge.userId), err)
+		}
+	}
+}
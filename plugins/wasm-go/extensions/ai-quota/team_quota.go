@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+func parseTeamConfig(json gjson.Result, config *QuotaConfig) {
+	config.UserTeams = make(map[string]string)
+	json.Get("user_teams").ForEach(func(key, value gjson.Result) bool {
+		if team := value.String(); team != "" {
+			config.UserTeams[key.String()] = team
+		}
+		return true
+	})
+	config.RedisTeamPrefix = json.Get("redis_team_prefix").String()
+	if config.RedisTeamPrefix == "" {
+		config.RedisTeamPrefix = "chat_quota_team:"
+	}
+	config.RedisTeamUsedPrefix = json.Get("redis_team_used_prefix").String()
+	if config.RedisTeamUsedPrefix == "" {
+		config.RedisTeamUsedPrefix = "chat_quota_team_used:"
+	}
+}
+
+// teamQuotaCounters are the team pool and the user's sub-limit, which only applies when
+// the user has a total quota set
+func teamQuotaCounters(config QuotaConfig, userId string, team string, modelName string) []wrapper.QuotaCounter {
+	return []wrapper.QuotaCounter{
+		{UsedKey: config.teamUsedKey(team), LimitKey: config.teamKey(team)},
+		{UsedKey: config.modelUsedKey(userId, modelName), LimitKey: config.modelTotalKey(userId, modelName), Optional: true},
+	}
+}
+
+// doTeamQuotaCheck deducts the weight from the team pool and the user's optional sub-limit
+// in one script, or from neither unless both have enough remaining
+func doTeamQuotaCheck(ctx wrapper.HttpContext, config QuotaConfig, userId string, team string, quotaWeight int, modelName string, log wrapper.Log) {
+	counters := teamQuotaCounters(config, userId, team, modelName)
+	err := config.redisClient.MultiCounterDeduct(counters, quotaWeight, wrapper.DeductEach, func(response resp.Value) {
+		result, redisErr := wrapper.ParseMultiCounterDeduct(response, len(counters))
+		if redisErr != nil {
+			log.Errorf("Failed to check team quota for user %s, team %s: %v", maskUserId(userId), team, redisErr)
+			config.sendJSONResponse(http.StatusInternalServerError, "quota-check.deduction_failed",
+				fmt.Sprintf("Quota deduction failed: %v", redisErr), false, nil)
+			return
+		}
+		remaining := result.Remaining
+		if !result.Allowed {
+			decisionMetrics.DeniedInsufficientQuota++
+			emitQuotaDecision(config, quotaDecision{userId: userId, modelName: modelName, weight: quotaWeight,
+				remaining: remaining, hasRemaining: true, decision: QuotaDecisionInsufficientQuota}, log)
+			if result.Refused == 0 {
+				log.Warnf("Insufficient team quota for user %s, team %s: remaining=%d, required=%d", maskUserId(userId), team, remaining, quotaWeight)
+				sendInsufficientQuota(config, userId, modelName, "quota-check.insufficient_team_quota",
+					fmt.Sprintf("Insufficient team quota. Required: %s, Available: %s", config.formatQuota(quotaWeight), config.formatQuota(remaining)))
+				return
+			}
+			log.Warnf("Insufficient quota for user %s in team %s: remaining=%d, required=%d", maskUserId(userId), team, remaining, quotaWeight)
+			sendInsufficientQuota(config, userId, modelName, "quota-check.insufficient_quota",
+				fmt.Sprintf("Insufficient quota. Required: %s, Available: %s", config.formatQuota(quotaWeight), config.formatQuota(remaining)))
+			return
+		}
+		remaining = result.Left[0]
+		log.Infof("Successfully deducted %d quota for user %s from team %s, model %s. Team remaining: %d",
+			quotaWeight, maskUserId(userId), team, modelName, remaining)
+		decisionMetrics.Allowed++
+		emitQuotaDecision(config, quotaDecision{userId: userId, modelName: modelName, weight: quotaWeight,
+			remaining: remaining, hasRemaining: true, decision: QuotaDecisionAllowed}, log)
+		recordCharge(ctx, config, userId, modelName, quotaWeight, counters[0].UsedKey, counters[1].UsedKey)
+		appendUsageLog(config, userId, modelName, quotaWeight, UsageSourceTeam, ctx.GetStringContext("requestId", ""), log)
+		// Only the user's own sub-limit is refilled, the team pool isn't the user's to top up
+		if subLimitRemaining := result.Left[1]; subLimitRemaining >= 0 {
+			checkAutoRefill(config, userId, modelName, subLimitRemaining, log)
+		}
+		resumeAfterWriteAck(ctx, config, userId, log)
+	})
+	if err != nil {
+		log.Errorf("Failed to dispatch team quota check for user %s, team %s: %v", maskUserId(userId), team, err)
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// tenantGlobalOptions are kept by the plugin as a whole rather than per config, so a tenant
+// can't override them
+var tenantGlobalOptions = []string{
+	"tenants", "tenant_header", "request_id_header", "mask_user_ids", "mask_user_ids_salt",
+	"metrics_flush_interval_ms", "redis_metrics_key", "pause_timeout_ms",
+}
+
+// parseTenantConfigs parses every tenant as the root config with the tenant's options
+// replacing the root ones. It runs before the root config is parsed, so the root options
+// are the ones left in the plugin wide state.
+func parseTenantConfigs(json gjson.Result, config *QuotaConfig, log wrapper.Log) error {
+	config.TenantHeader = strings.ToLower(json.Get("tenant_header").String())
+	config.Tenants = make(map[string]*QuotaConfig)
+	tenants := json.Get("tenants")
+	if !tenants.Exists() {
+		return nil
+	}
+	if !tenants.IsObject() {
+		return fmt.Errorf("tenants must be an object keyed by host or %s value", tenantKeyName(config))
+	}
+	root, err := sjson.Delete(json.Raw, "tenants")
+	if err != nil {
+		return fmt.Errorf("failed to prepare tenant configs: %v", err)
+	}
+	var tenantErr error
+	tenants.ForEach(func(key, value gjson.Result) bool {
+		tenant := strings.ToLower(key.String())
+		if tenant == "" || !value.IsObject() {
+			tenantErr = fmt.Errorf("invalid tenant %q: must be a non-empty key with an object of options", key.String())
+			return false
+		}
+		merged := root
+		value.ForEach(func(option, optionValue gjson.Result) bool {
+			for _, global := range tenantGlobalOptions {
+				if option.String() == global {
+					tenantErr = fmt.Errorf("tenant %s: %s applies to all tenants and can't be overridden", tenant, global)
+					return false
+				}
+			}
+			merged, tenantErr = sjson.SetRaw(merged, option.String(), optionValue.Raw)
+			return tenantErr == nil
+		})
+		if tenantErr != nil {
+			return false
+		}
+		tenantConfig := &QuotaConfig{isTenant: true}
+		if err := parseConfig(gjson.Parse(merged), tenantConfig, log); err != nil {
+			tenantErr = fmt.Errorf("tenant %s: %v", tenant, err)
+			return false
+		}
+		config.Tenants[tenant] = tenantConfig
+		return true
+	})
+	return tenantErr
+}
+
+func tenantKeyName(config *QuotaConfig) string {
+	if config.TenantHeader != "" {
+		return config.TenantHeader
+	}
+	return "host"
+}
+
+// resolveTenantConfig picks the config of the tenant the request belongs to, by the
+// tenant header when configured and by host otherwise. Requests of no known tenant use
+// the root config.
+func resolveTenantConfig(ctx wrapper.HttpContext, config QuotaConfig, log wrapper.Log) QuotaConfig {
+	if len(config.Tenants) == 0 {
+		return config
+	}
+	var tenant string
+	if config.TenantHeader != "" {
+		tenant, _ = proxywasm.GetHttpRequestHeader(config.TenantHeader)
+	} else {
+		tenant = ctx.Host()
+		if host, _, err := net.SplitHostPort(tenant); err == nil {
+			tenant = host
+		}
+	}
+	tenant = strings.ToLower(strings.TrimSpace(tenant))
+	tenantConfig, exists := config.Tenants[tenant]
+	if !exists {
+		log.Debugf("No tenant config for %s %q, using the default config", tenantKeyName(&config), tenant)
+		return config
+	}
+	log.Debugf("Using the config of tenant %s", tenant)
+	ctx.SetContext("tenantConfig", tenantConfig)
+	return tenantConfig.withPauseGuard(config)
+}
+
+// tenantConfig is the config resolved for the request by its request headers
+func tenantConfig(ctx wrapper.HttpContext, config QuotaConfig) QuotaConfig {
+	if tenantConfig, ok := ctx.GetContext("tenantConfig").(*QuotaConfig); ok {
+		return tenantConfig.withPauseGuard(config)
+	}
+	return config
+}
+
+// withPauseGuard shares the pause guard of the root config, which tracks the paused
+// requests of all tenants
+func (c *QuotaConfig) withPauseGuard(root QuotaConfig) QuotaConfig {
+	tenant := *c
+	tenant.pauseGuard = root.pauseGuard
+	return tenant
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+// tokenFailureCacheSize bounds the sources tracked per VM for the token failure cooldown
+const tokenFailureCacheSize = 10000
+
+// tokenFailureScript counts a token failure of a source within the window. Once the
+// threshold is reached the counter is kept for the cooldown, so every VM seeing a further
+// failure of the source learns how long it is blocked. Returns {failures, cooldown ttl}.
+const tokenFailureScript = `
+local failures = redis.call('incr', KEYS[1])
+local threshold = tonumber(ARGV[2])
+if failures == 1 then
+	redis.call('expire', KEYS[1], ARGV[1])
+end
+if failures < threshold then
+	return {failures, 0}
+end
+if failures == threshold then
+	redis.call('expire', KEYS[1], ARGV[3])
+end
+return {failures, redis.call('ttl', KEYS[1])}
+`
+
+// tokenFailures tracks sources sending invalid tokens, blocked holds the sources in
+// cooldown and failing the ones with failures recorded from this VM
+type tokenFailures struct {
+	blocked *starCache
+	failing *starCache
+}
+
+func parseTokenFailureConfig(json gjson.Result, config *QuotaConfig) error {
+	config.TokenFailureThreshold = int(json.Get("token_failure_threshold").Int())
+	config.TokenFailureWindow = int(json.Get("token_failure_window").Int())
+	config.TokenFailureCooldown = int(json.Get("token_failure_cooldown").Int())
+	if config.TokenFailureThreshold < 0 || config.TokenFailureWindow < 0 || config.TokenFailureCooldown < 0 {
+		return errors.New("token_failure_threshold, token_failure_window and token_failure_cooldown must not be negative")
+	}
+	if config.TokenFailureWindow == 0 {
+		config.TokenFailureWindow = 60
+	}
+	if config.TokenFailureCooldown == 0 {
+		config.TokenFailureCooldown = 300
+	}
+	config.RedisTokenFailurePrefix = json.Get("redis_token_failure_prefix").String()
+	if config.RedisTokenFailurePrefix == "" {
+		config.RedisTokenFailurePrefix = "chat_quota_token_failures:"
+	}
+	if config.TokenFailureThreshold > 0 {
+		config.tokenFailures = &tokenFailures{
+			blocked: newStarCache(tokenFailureCacheSize),
+			failing: newStarCache(tokenFailureCacheSize),
+		}
+	}
+	return nil
+}
+
+func (c QuotaConfig) tokenFailureKey(source string) string {
+	return buildKey(c.TokenFailureKeyTemplate, keyParts{source: source})
+}
+
+// tokenFailureSource identifies the source of a request by its address without port
+func tokenFailureSource(log wrapper.Log) string {
+	address, err := proxywasm.GetProperty([]string{"source", "address"})
+	if err != nil {
+		log.Warnf("Failed to get source address: %v", err)
+		return ""
+	}
+	return sourceIP(string(address))
+}
+
+// rejectTokenCooldown rejects a request with 429 when its source is cooling down after
+// repeated invalid tokens, before the token is looked at
+func rejectTokenCooldown(config QuotaConfig, log wrapper.Log) bool {
+	if config.tokenFailures == nil {
+		return false
+	}
+	source := tokenFailureSource(log)
+	if source == "" || !config.tokenFailures.blocked.contains(source) {
+		return false
+	}
+	log.Debugf("Rejecting request from %s cooling down after repeated invalid tokens", source)
+	config.sendJSONResponse(http.StatusTooManyRequests, "ai-gateway.token_cooldown",
+		"Request denied by ai quota check. Too many invalid tokens, please retry later.", false, nil)
+	return true
+}
+
+// recordTokenFailure counts an invalid token of the request's source, blocking the
+// source on this VM for the rest of the cooldown once it reached the threshold
+func recordTokenFailure(config QuotaConfig, log wrapper.Log) {
+	if config.tokenFailures == nil {
+		return
+	}
+	source := tokenFailureSource(log)
+	if source == "" {
+		return
+	}
+	config.tokenFailures.failing.add(source, config.TokenFailureWindow)
+	args := []interface{}{config.TokenFailureWindow, config.TokenFailureThreshold, config.TokenFailureCooldown}
+	err := config.redisClient.Eval(tokenFailureScript, 1, []interface{}{config.tokenFailureKey(source)}, args, func(response resp.Value) {
+		if wrapper.IsRedisErrorResponse(response) || len(response.Array()) < 2 {
+			log.Warnf("Failed to record token failure of %s: %v", source, wrapper.GetRedisErrorFromResponse(response))
+			return
+		}
+		result := response.Array()
+		if ttl := result[1].Integer(); ttl > 0 {
+			log.Warnf("Source %s sent %d invalid tokens, blocking it for %d seconds", source, result[0].Integer(), ttl)
+			config.tokenFailures.blocked.add(source, int(ttl))
+		}
+	})
+	if err != nil {
+		log.Warnf("Failed to dispatch token failure of %s: %v", source, err)
+	}
+}
+
+// resetTokenFailures clears the failure count of a source after a valid token, so only
+// consecutive failures lead to a cooldown. Sources without failures seen by this VM are
+// skipped to keep Redis out of the normal request path.
+func resetTokenFailures(config QuotaConfig, log wrapper.Log) {
+	if config.tokenFailures == nil {
+		return
+	}
+	source := tokenFailureSource(log)
+	if source == "" || !config.tokenFailures.failing.contains(source) {
+		return
+	}
+	config.tokenFailures.failing.remove(source)
+	if err := config.redisClient.Del(config.tokenFailureKey(source), nil); err != nil {
+		log.Warnf("Failed to reset token failures of %s: %v", source, err)
+	}
+}
+
+// rejectInvalidToken records a token failure of the request's source and sends the 401
+func rejectInvalidToken(config QuotaConfig, code string, message string, log wrapper.Log) types.Action {
+	recordTokenFailure(config, log)
+	config.sendJSONResponse(http.StatusUnauthorized, code, fmt.Sprintf("Request denied by ai quota check. %s", message), false, nil)
+	return types.ActionContinue
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+// TrustedProxy restricts the deduct and admin headers to requests from a trusted source,
+// either a source address within Cidrs or carrying Header with HeaderValue
+type TrustedProxy struct {
+	Cidrs       []string `yaml:"cidrs" json:"cidrs"`
+	Header      string   `yaml:"header" json:"header"`
+	HeaderValue string   `yaml:"header_value" json:"-"`
+	networks    []*net.IPNet
+}
+
+func parseTrustedProxy(json gjson.Result, config *QuotaConfig) error {
+	trustedProxy := json.Get("trusted_proxy")
+	config.TrustedProxy = TrustedProxy{
+		Cidrs:       make([]string, 0),
+		Header:      trustedProxy.Get("header").String(),
+		HeaderValue: trustedProxy.Get("header_value").String(),
+	}
+	if (config.TrustedProxy.Header == "") != (config.TrustedProxy.HeaderValue == "") {
+		return errors.New("trusted_proxy header and header_value must be set together")
+	}
+	for _, cidr := range trustedProxy.Get("cidrs").Array() {
+		_, network, err := net.ParseCIDR(cidr.String())
+		if err != nil {
+			return fmt.Errorf("invalid trusted_proxy cidr %s: %w", cidr.String(), err)
+		}
+		config.TrustedProxy.Cidrs = append(config.TrustedProxy.Cidrs, cidr.String())
+		config.TrustedProxy.networks = append(config.TrustedProxy.networks, network)
+	}
+	return nil
+}
+
+func (t TrustedProxy) enabled() bool {
+	return len(t.networks) > 0 || t.Header != ""
+}
+
+// trusts reports whether the request comes from a trusted source
+func (t TrustedProxy) trusts(log wrapper.Log) bool {
+	if t.Header != "" {
+		if value, err := proxywasm.GetHttpRequestHeader(t.Header); err == nil && value == t.HeaderValue {
+			return true
+		}
+	}
+	if len(t.networks) == 0 {
+		return false
+	}
+	address, err := proxywasm.GetProperty([]string{"source", "address"})
+	if err != nil {
+		log.Warnf("Failed to get source address: %v", err)
+		return false
+	}
+	ip := net.ParseIP(sourceIP(string(address)))
+	if ip == nil {
+		return false
+	}
+	for _, network := range t.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceIP strips the port from an ip:port or [ipv6]:port source address
+func sourceIP(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return strings.Trim(address, "[]")
+}
+
+// stripUntrustedHeaders removes the quota-affecting headers a client outside the trusted
+// sources could have spoofed, so the deduct and weight override headers are ignored and
+// admin operations are refused. The trust header itself is never forwarded upstream.
+func stripUntrustedHeaders(config QuotaConfig, log wrapper.Log) {
+	if !config.TrustedProxy.enabled() {
+		return
+	}
+	trusted := config.TrustedProxy.trusts(log)
+	if config.TrustedProxy.Header != "" {
+		_ = proxywasm.RemoveHttpRequestHeader(config.TrustedProxy.Header)
+	}
+	if trusted {
+		return
+	}
+	for _, header := range []string{config.DeductHeader, config.AdminHeader, config.WeightOverrideHeader} {
+		if _, err := proxywasm.GetHttpRequestHeader(header); err == nil {
+			log.Warnf("Ignoring %s header from an untrusted source", header)
+			_ = proxywasm.RemoveHttpRequestHeader(header)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/tidwall/gjson"
+)
+
+// Policies for models without a configured weight
+const (
+	// UnknownModelFree lets them through free of charge
+	UnknownModelFree = "free"
+	// UnknownModelBlock rejects them
+	UnknownModelBlock = "block"
+	// UnknownModelLogOnly lets them through free of charge, logging a warning
+	UnknownModelLogOnly = "log_only"
+)
+
+func parseUnknownModelPolicy(json gjson.Result, config *QuotaConfig) error {
+	config.UnknownModelPolicy = json.Get("unknown_model_policy").String()
+	switch config.UnknownModelPolicy {
+	case "":
+		config.UnknownModelPolicy = UnknownModelLogOnly
+	case UnknownModelFree, UnknownModelBlock, UnknownModelLogOnly:
+	default:
+		return fmt.Errorf("unsupported unknown_model_policy %s, must be %s, %s or %s",
+			config.UnknownModelPolicy, UnknownModelFree, UnknownModelBlock, UnknownModelLogOnly)
+	}
+	return nil
+}
+
+// allowUnknownModel applies unknown_model_policy to a request for a model without a
+// configured weight, sending the rejection and returning false when it is blocked
+func allowUnknownModel(config QuotaConfig, userId string, modelName string, log wrapper.Log) bool {
+	decisionMetrics.UnknownModel++
+	switch config.UnknownModelPolicy {
+	case UnknownModelBlock:
+		log.Warnf("Rejecting request of user %s for unpriced model %s", maskUserId(userId), modelName)
+		emitQuotaDecision(config, quotaDecision{userId: userId, modelName: modelName, decision: QuotaDecisionUnknownModelDenied}, log)
+		config.sendJSONResponse(http.StatusForbidden, "quota-check.unknown_model",
+			fmt.Sprintf("Model %s is not available", modelName), false, nil)
+		return false
+	case UnknownModelLogOnly:
+		log.Warnf("Model %s of user %s has no quota weight configured, letting it through free of charge", modelName, maskUserId(userId))
+	}
+	return true
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+// Strategies locating the token usage in a response
+const (
+	UsageStrategyOpenAI    = "openai"
+	UsageStrategyAnthropic = "anthropic"
+)
+
+// defaultUsageStrategies covers providers whose usage isn't reported the OpenAI way
+var defaultUsageStrategies = map[string]string{
+	"claude": UsageStrategyAnthropic,
+}
+
+// maxUsageBodyBytes bounds how much of a non-streaming response is buffered to find its usage
+const maxUsageBodyBytes = 1 << 20
+
+// tokenUsage is the token count reported by the upstream
+type tokenUsage struct {
+	Input  int
+	Output int
+	Found  bool
+}
+
+func (u tokenUsage) total() int {
+	return u.Input + u.Output
+}
+
+// tokenRate is the quota per 1000 input and output tokens of a model, in quota units
+type tokenRate struct {
+	Input  int `yaml:"input" json:"input"`
+	Output int `yaml:"output" json:"output"`
+}
+
+// usageExtractors read the usage from a response JSON payload, a whole body or one
+// server-sent event, updating what earlier payloads of the same response reported
+var usageExtractors = map[string]func(payload gjson.Result, usage *tokenUsage){
+	// usage is reported as a whole, in the body or the final chunk of a stream
+	UsageStrategyOpenAI: func(payload gjson.Result, usage *tokenUsage) {
+		if u := payload.Get("usage"); u.IsObject() {
+			usage.Input = int(u.Get("prompt_tokens").Int())
+			usage.Output = int(u.Get("completion_tokens").Int())
+			usage.Found = true
+		}
+	},
+	// streams report input tokens in message_start and cumulative output tokens in message_delta
+	UsageStrategyAnthropic: func(payload gjson.Result, usage *tokenUsage) {
+		u := payload.Get("usage")
+		if !u.IsObject() {
+			u = payload.Get("message.usage")
+		}
+		if !u.IsObject() {
+			return
+		}
+		if input := u.Get("input_tokens"); input.Exists() {
+			usage.Input = int(input.Int())
+		}
+		if output := u.Get("output_tokens"); output.Exists() {
+			usage.Output = int(output.Int())
+		}
+		usage.Found = true
+	},
+}
+
+func parseUsageConfig(json gjson.Result, config *QuotaConfig) error {
+	config.ModelTokenWeights = make(map[string]int)
+	var weightErr error
+	json.Get("model_token_weights").ForEach(func(key, value gjson.Result) bool {
+		weight, err := config.parseQuota(strconv.FormatFloat(value.Float(), 'f', -1, 64))
+		if err != nil || weight < 0 {
+			weightErr = fmt.Errorf("invalid token weight for model %s: must be a non-negative number within quota_precision decimal places", key.String())
+			return false
+		}
+		config.ModelTokenWeights[key.String()] = weight
+		return true
+	})
+	if weightErr != nil {
+		return weightErr
+	}
+	config.ModelTokenRates = make(map[string]tokenRate)
+	json.Get("model_token_rates").ForEach(func(key, value gjson.Result) bool {
+		if _, exists := config.ModelTokenWeights[key.String()]; exists {
+			weightErr = fmt.Errorf("model %s has both a token weight and token rates", key.String())
+			return false
+		}
+		var rate tokenRate
+		for _, r := range []struct {
+			name   string
+			target *int
+		}{{"input", &rate.Input}, {"output", &rate.Output}} {
+			amount, err := config.parseQuota(strconv.FormatFloat(value.Get(r.name).Float(), 'f', -1, 64))
+			if err != nil || amount < 0 {
+				weightErr = fmt.Errorf("invalid %s token rate for model %s: must be a non-negative number within quota_precision decimal places", r.name, key.String())
+				return false
+			}
+			*r.target = amount
+		}
+		config.ModelTokenRates[key.String()] = rate
+		return true
+	})
+	if weightErr != nil {
+		return weightErr
+	}
+	config.UsageStrategies = make(map[string]string)
+	for providerType, strategy := range defaultUsageStrategies {
+		config.UsageStrategies[providerType] = strategy
+	}
+	for providerType, strategy := range json.Get("usage_strategies").Map() {
+		if _, exists := usageExtractors[strategy.String()]; !exists {
+			return fmt.Errorf("invalid usage strategy %s for provider %s, must be %s or %s",
+				strategy.String(), providerType, UsageStrategyOpenAI, UsageStrategyAnthropic)
+		}
+		config.UsageStrategies[providerType] = strategy.String()
+	}
+	return nil
+}
+
+// usageStrategy is the strategy for the configured provider, OpenAI compatible by default
+func (c QuotaConfig) usageStrategy() string {
+	if strategy, exists := c.UsageStrategies[c.Provider.Type]; exists {
+		return strategy
+	}
+	return UsageStrategyOpenAI
+}
+
+// quotaCharge is what the request phase deducted, settled against the token usage
+// once the response has ended or partly refunded when a stream is cancelled
+type quotaCharge struct {
+	userId    string
+	modelName string
+	weight    int
+	keys      []string
+	byTokens  bool
+}
+
+// usageTracker finds the usage in the response as it streams by
+type usageTracker struct {
+	extract func(payload gjson.Result, usage *tokenUsage)
+	sse     bool
+	pending []byte // incomplete event line, or the body so far when not streaming
+	usage   tokenUsage
+	events  int // data events seen so far
+}
+
+// billedByTokens reports whether the charge of the model is settled against its token usage
+func (c QuotaConfig) billedByTokens(modelName string) bool {
+	if _, exists := c.ModelTokenWeights[modelName]; exists {
+		return true
+	}
+	_, exists := c.ModelTokenRates[modelName]
+	return exists
+}
+
+// recordCharge remembers the counters the weight was deducted from, when the model is
+// billed by tokens or refund_on_cancel is set, so the response can settle the charge
+func recordCharge(ctx wrapper.HttpContext, config QuotaConfig, userId string, modelName string, weight int, keys ...string) {
+	byTokens := config.billedByTokens(modelName)
+	if !byTokens && !config.RefundOnCancel {
+		return
+	}
+	ctx.SetContext("quotaCharge", &quotaCharge{userId: userId, modelName: modelName, weight: weight, keys: keys, byTokens: byTokens})
+}
+
+// startUsageTracking prepares to read the usage of a response whose charge is to be settled
+func startUsageTracking(ctx wrapper.HttpContext, config QuotaConfig) {
+	if _, ok := ctx.GetContext("quotaCharge").(*quotaCharge); !ok {
+		return
+	}
+	contentType, _ := proxywasm.GetHttpResponseHeader("content-type")
+	ctx.SetContext("usageTracker", &usageTracker{
+		extract: usageExtractors[config.usageStrategy()],
+		sse:     strings.Contains(contentType, "text/event-stream"),
+	})
+}
+
+// feed reads the usage from the next chunk of the response
+func (t *usageTracker) feed(data []byte, endOfStream bool) {
+	if !t.sse {
+		if len(t.pending)+len(data) <= maxUsageBodyBytes {
+			t.pending = append(t.pending, data...)
+		}
+		if endOfStream && len(t.pending) <= maxUsageBodyBytes {
+			t.extract(gjson.ParseBytes(t.pending), &t.usage)
+		}
+		return
+	}
+	t.pending = append(t.pending, data...)
+	for {
+		end := bytes.IndexByte(t.pending, '\n')
+		if end < 0 {
+			break
+		}
+		t.feedLine(t.pending[:end])
+		t.pending = t.pending[end+1:]
+	}
+	if endOfStream {
+		t.feedLine(t.pending)
+		t.pending = nil
+	}
+}
+
+func (t *usageTracker) feedLine(line []byte) {
+	line = bytes.TrimSpace(line)
+	if !bytes.HasPrefix(line, []byte("data:")) {
+		return
+	}
+	payload := bytes.TrimSpace(line[len("data:"):])
+	if len(payload) > 0 && !bytes.Equal(payload, []byte("[DONE]")) {
+		t.events++
+	}
+	if gjson.ValidBytes(payload) {
+		t.extract(gjson.ParseBytes(payload), &t.usage)
+	}
+}
+
+// tokenCost is the quota charged for the usage, capped at max_weight. Models with token
+// rates are charged for input and output tokens separately.
+func (c QuotaConfig) tokenCost(modelName string, usage tokenUsage) int {
+	var cost int
+	if rate, exists := c.ModelTokenRates[modelName]; exists {
+		cost = c.roundCost((float64(usage.Input)*float64(rate.Input) + float64(usage.Output)*float64(rate.Output)) / 1000)
+	} else {
+		cost = c.roundCost(float64(usage.total()) * float64(c.ModelTokenWeights[modelName]) / 1000)
+	}
+	if c.MaxWeight > 0 && cost > c.MaxWeight {
+		cost = c.MaxWeight
+	}
+	return cost
+}
+
+// capTokenCost limits a token charge to max_deduction_per_request. The response has been
+// served by then, so the charge can't be refused, only capped.
+func (c QuotaConfig) capTokenCost(charge *quotaCharge, cost int, log wrapper.Log) int {
+	if c.MaxDeductionPerRequest == 0 || cost <= c.MaxDeductionPerRequest {
+		return cost
+	}
+	log.Errorf("Token charge %s of user %s for model %s exceeds max_deduction_per_request, capping it at %s",
+		c.formatQuota(cost), maskUserId(charge.userId), charge.modelName, c.formatQuota(c.MaxDeductionPerRequest))
+	return c.MaxDeductionPerRequest
+}
+
+// trackUsage settles the charge of the request against the token usage once the response
+// has ended, keeping the fixed weight when the usage can't be found
+func trackUsage(ctx wrapper.HttpContext, config QuotaConfig, data []byte, endOfStream bool, log wrapper.Log) {
+	tracker, ok := ctx.GetContext("usageTracker").(*usageTracker)
+	if !ok {
+		return
+	}
+	tracker.feed(data, endOfStream)
+	if !endOfStream {
+		return
+	}
+	ctx.SetContext("responseComplete", true)
+	charge := ctx.GetContext("quotaCharge").(*quotaCharge)
+	if !charge.byTokens {
+		return
+	}
+	if !tracker.usage.Found {
+		log.Warnf("No token usage found in the response for user %s, model %s, keeping the fixed weight %s",
+			maskUserId(charge.userId), charge.modelName, config.formatQuota(charge.weight))
+		return
+	}
+	adjustment := config.capTokenCost(charge, config.tokenCost(charge.modelName, tracker.usage), log) - charge.weight
+	log.Debugf("Token usage of user %s, model %s: input=%d, output=%d, adjusting the charge by %s",
+		maskUserId(charge.userId), charge.modelName, tracker.usage.Input, tracker.usage.Output, config.formatQuota(adjustment))
+	if adjustment == 0 {
+		return
+	}
+	for _, key := range charge.keys {
+		if err := settleCharge(config, charge, key, adjustment, log); err != nil {
+			log.Errorf("Failed to settle the token charge of user %s on %s: %v", maskUserId(charge.userId), maskUserIdInKey(key, charge.userId), err)
+		}
+	}
+}
+
+// settleCharge applies the adjustment to one charged counter. Refunds stop at zero, so a
+// counter reset while the request was in flight doesn't go negative and turn into free quota.
+func settleCharge(config QuotaConfig, charge *quotaCharge, key string, adjustment int, log wrapper.Log) error {
+	if adjustment > 0 {
+		return config.redisClient.IncrBy(key, adjustment, func(response resp.Value) {
+			if err := response.Error(); err != nil {
+				log.Errorf("Failed to settle the token charge of user %s on %s: %v", maskUserId(charge.userId), maskUserIdInKey(key, charge.userId), err)
+			}
+		})
+	}
+	return config.redisClient.DecrByFloor(key, -adjustment, 0, func(value int, clamped bool, err error) {
+		if err != nil {
+			log.Errorf("Failed to settle the token charge of user %s on %s: %v", maskUserId(charge.userId), maskUserIdInKey(key, charge.userId), err)
+			return
+		}
+		if clamped {
+			log.Warnf("Token charge refund of user %s on %s was clamped at zero", maskUserId(charge.userId), maskUserIdInKey(key, charge.userId))
+		}
+	})
+}
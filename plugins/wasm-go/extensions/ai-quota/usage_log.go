@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm/types"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+// Counters a request was charged to, as recorded in the usage log
+const (
+	UsageSourceUser = "user"
+	UsageSourceTeam = "team"
+	UsageSourceOrg  = "org"
+	UsageSourceFree = "free"
+)
+
+// usageLogScript prepends the record ARGV[1] to the list KEYS[1], keeps only the newest
+// ARGV[2] records and, when ARGV[3] is positive, lets the list expire ARGV[3] seconds
+// after the user's last request
+const usageLogScript = `
+	redis.call('lpush', KEYS[1], ARGV[1])
+	redis.call('ltrim', KEYS[1], 0, tonumber(ARGV[2]) - 1)
+	if tonumber(ARGV[3]) > 0 then
+		redis.call('expire', KEYS[1], ARGV[3])
+	end
+	return 1
+`
+
+// usageLogEntry is the compact summary of a charged request kept in the usage log
+type usageLogEntry struct {
+	Timestamp int64       `json:"ts"`
+	Model     string      `json:"model"`
+	Weight    json.Number `json:"weight"`
+	Source    string      `json:"source"`
+	RequestId string      `json:"request_id,omitempty"`
+}
+
+func parseUsageLogConfig(json gjson.Result, config *QuotaConfig) error {
+	config.UsageLogSize = int(json.Get("usage_log_size").Int())
+	if config.UsageLogSize < 0 {
+		return errors.New("usage_log_size must not be negative")
+	}
+	config.UsageLogTTL = 30 * 24 * 3600
+	if ttl := json.Get("usage_log_ttl"); ttl.Exists() {
+		config.UsageLogTTL = int(ttl.Int())
+		if config.UsageLogTTL < 0 {
+			return errors.New("usage_log_ttl must not be negative")
+		}
+	}
+	config.RedisUsageLogPrefix = json.Get("redis_usage_log_prefix").String()
+	if config.RedisUsageLogPrefix == "" {
+		config.RedisUsageLogPrefix = "chat_quota_usage_log:"
+	}
+	return nil
+}
+
+// appendUsageLog records a charged request in the user's rolling usage log. It never
+// holds up the request, a failed write only loses the record.
+func appendUsageLog(config QuotaConfig, userId string, modelName string, weight int, source string, requestId string, log wrapper.Log) {
+	if config.UsageLogSize == 0 {
+		return
+	}
+	record, _ := json.Marshal(usageLogEntry{
+		Timestamp: time.Now().Unix(),
+		Model:     modelName,
+		Weight:    json.Number(config.formatQuota(weight)),
+		Source:    source,
+		RequestId: requestId,
+	})
+	keys := []interface{}{config.usageLogKey(userId)}
+	args := []interface{}{string(record), config.UsageLogSize, config.UsageLogTTL}
+	err := config.redisClient.Eval(usageLogScript, len(keys), keys, args, func(response resp.Value) {
+		if err := response.Error(); err != nil {
+			log.Warnf("Failed to append to the usage log of user %s: %v", maskUserId(userId), err)
+		}
+	})
+	if err != nil {
+		log.Warnf("Failed to dispatch usage log append for user %s: %v", maskUserId(userId), err)
+	}
+}
+
+// queryUsageLog returns the most recent usage log records of a user, newest first
+func queryUsageLog(config QuotaConfig, url *url.URL, log wrapper.Log) types.Action {
+	queryValues := url.Query()
+	userId, err := config.queryUserId(queryValues)
+	if err != nil {
+		sendUnknownConsumer(config, queryValues)
+		return types.ActionContinue
+	}
+	if userId == "" {
+		config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. user_id or consumer can't be empty.", false, nil)
+		return types.ActionContinue
+	}
+	limit := config.UsageLogSize
+	if raw := queryValues.Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			config.sendJSONResponse(http.StatusBadRequest, "ai-gateway.invalid_params", "Request denied by ai quota check. limit must be a positive integer.", false, nil)
+			return types.ActionContinue
+		}
+	}
+	if limit == 0 {
+		config.sendJSONResponse(http.StatusNotFound, "ai-gateway.usage_log_disabled", "The usage log is disabled, set usage_log_size to enable it", false, nil)
+		return types.ActionContinue
+	}
+
+	err = config.replicaRead(func(client wrapper.RedisClient, callback wrapper.RedisResponseCallback) error {
+		return client.LRange(config.usageLogKey(userId), 0, limit-1, callback)
+	}, func(response resp.Value) {
+		if err := response.Error(); err != nil {
+			log.Errorf("Failed to query the usage log of user %s: %v", maskUserId(userId), err)
+			config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+			return
+		}
+		entries := make([]json.RawMessage, 0, len(response.Array()))
+		for _, record := range response.Array() {
+			if gjson.Valid(record.String()) {
+				entries = append(entries, json.RawMessage(record.String()))
+			}
+		}
+		data := map[string]interface{}{
+			"user_id": userId,
+			"entries": entries,
+		}
+		config.sendJSONResponse(http.StatusOK, "ai-gateway.queryusagelog", "query usage log successful", true, data)
+	}, log)
+	if err != nil {
+		config.sendJSONResponse(http.StatusServiceUnavailable, "ai-gateway.error", fmt.Sprintf("redis error:%v", err), false, nil)
+		return types.ActionContinue
+	}
+	return types.ActionPause
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageTrackerFeed(t *testing.T) {
+	var tests = []struct {
+		name     string
+		strategy string
+		sse      bool
+		chunks   []string
+		usage    tokenUsage
+		events   int
+	}{
+		{
+			name:     "openai body",
+			strategy: UsageStrategyOpenAI,
+			chunks: []string{
+				`{"id":"chatcmpl-1","choices":[{"message":{"role":"assistant","content":"Hi"}}],`,
+				`"usage":{"prompt_tokens":12,"completion_tokens":30,"total_tokens":42}}`,
+			},
+			usage: tokenUsage{Input: 12, Output: 30, Found: true},
+		},
+		{
+			name:     "openai stream with usage in the final chunk",
+			strategy: UsageStrategyOpenAI,
+			sse:      true,
+			chunks: []string{
+				"data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n",
+				"data: {\"choices\":[{\"delta\":{\"content\":\"lo\"}}]}\n\ndata: {\"choices\":[],",
+				"\"usage\":{\"prompt_tokens\":8,\"completion_tokens\":2}}\n\n",
+				"data: [DONE]\n\n",
+			},
+			usage:  tokenUsage{Input: 8, Output: 2, Found: true},
+			events: 3,
+		},
+		{
+			name:     "openai stream without usage",
+			strategy: UsageStrategyOpenAI,
+			sse:      true,
+			chunks: []string{
+				"data: {\"choices\":[{\"delta\":{\"content\":\"Hi\"}}]}\n\n",
+				"data: [DONE]",
+			},
+			events: 1,
+		},
+		{
+			name:     "anthropic body",
+			strategy: UsageStrategyAnthropic,
+			chunks: []string{
+				`{"id":"msg_1","type":"message","content":[{"type":"text","text":"Hi"}],"usage":{"input_tokens":25,"output_tokens":11}}`,
+			},
+			usage: tokenUsage{Input: 25, Output: 11, Found: true},
+		},
+		{
+			name:     "anthropic stream",
+			strategy: UsageStrategyAnthropic,
+			sse:      true,
+			chunks: []string{
+				"event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"msg_1\",\"usage\":{\"input_tokens\":25,\"output_tokens\":1}}}\n\n",
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n",
+				"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":15}}\n\n",
+				"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n",
+			},
+			usage:  tokenUsage{Input: 25, Output: 15, Found: true},
+			events: 4,
+		},
+		{
+			name:     "anthropic stream without usage",
+			strategy: UsageStrategyAnthropic,
+			sse:      true,
+			chunks: []string{
+				"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"Hi\"}}\n\n",
+			},
+			events: 1,
+		},
+		{
+			name:     "body that is not JSON",
+			strategy: UsageStrategyOpenAI,
+			chunks:   []string{"upstream connect error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := &usageTracker{extract: usageExtractors[tt.strategy], sse: tt.sse}
+			for i, chunk := range tt.chunks {
+				tracker.feed([]byte(chunk), i == len(tt.chunks)-1)
+			}
+			assert.Equal(t, tt.usage, tracker.usage)
+			assert.Equal(t, tt.events, tracker.events)
+		})
+	}
+}
+
+func TestTokenCost(t *testing.T) {
+	config := QuotaConfig{
+		CostRounding:      CostRoundingCeil,
+		ModelTokenWeights: map[string]int{"gpt-4": 2},
+		ModelTokenRates:   map[string]tokenRate{"gpt-4o": {Input: 3, Output: 10}},
+	}
+	tracker := &usageTracker{extract: usageExtractors[UsageStrategyOpenAI]}
+	tracker.feed([]byte(`{"usage":{"prompt_tokens":1000,"completion_tokens":500}}`), true)
+	assert.True(t, tracker.usage.Found)
+	assert.Equal(t, 3, config.tokenCost("gpt-4", tracker.usage))
+	// (1000 * 3 + 500 * 10) / 1000
+	assert.Equal(t, 8, config.tokenCost("gpt-4o", tracker.usage))
+}
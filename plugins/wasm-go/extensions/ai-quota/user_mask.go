@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/matcher"
+	"github.com/tidwall/gjson"
+)
+
+// userIdMasking is applied when logging, which mostly happens from Redis callbacks where
+// the plugin config is not at hand. Set from mask_user_ids and mask_user_ids_salt.
+var userIdMasking = struct {
+	enabled bool
+	salt    string
+}{}
+
+// parseUserMaskConfig sets the masking from the root config only. Route rules are parsed on
+// their own JSON, so one leaving out mask_user_ids would otherwise turn masking off for
+// every route, and options set in a rule are rejected rather than ignored.
+func parseUserMaskConfig(json gjson.Result, config *QuotaConfig) error {
+	if isRuleConfig(json) {
+		for _, option := range []string{"mask_user_ids", "mask_user_ids_salt"} {
+			if json.Get(option).Exists() {
+				return fmt.Errorf("%s applies to all routes and must be set in the plugin config, not in %s", option, matcher.RULES_KEY)
+			}
+		}
+		config.MaskUserIds = userIdMasking.enabled
+		config.MaskUserIdsSalt = userIdMasking.salt
+		return nil
+	}
+	config.MaskUserIds = json.Get("mask_user_ids").Bool()
+	config.MaskUserIdsSalt = json.Get("mask_user_ids_salt").String()
+	userIdMasking.enabled = config.MaskUserIds
+	userIdMasking.salt = config.MaskUserIdsSalt
+	return nil
+}
+
+// isRuleConfig tells a route rule from the root config by its match keys
+func isRuleConfig(json gjson.Result) bool {
+	for _, key := range []string{matcher.MATCH_ROUTE_KEY, matcher.MATCH_DOMAIN_KEY, matcher.MATCH_SERVICE_KEY, matcher.MATCH_ROUTE_PREFIX_KEY} {
+		if json.Get(key).Exists() {
+			return true
+		}
+	}
+	return false
+}
+
+// maskUserId replaces a user id, which may be an email address, with a stable hash in
+// logs and error responses, so requests of the same user can still be correlated.
+// Redis keys keep the real id.
+func maskUserId(userId string) string {
+	if !userIdMasking.enabled || userId == "" {
+		return userId
+	}
+	sum := sha256.Sum256([]byte(userIdMasking.salt + userId))
+	return "user-" + hex.EncodeToString(sum[:6])
+}
+
+// maskUserIdInKey masks the user id within a Redis key about to be logged
+func maskUserIdInKey(key string, userId string) string {
+	if !userIdMasking.enabled || userId == "" {
+		return key
+	}
+	return strings.Replace(key, userId, maskUserId(userId), 1)
+}
@@ -7,10 +7,12 @@ const (
 
 	MimeTypeTextPlain       = "text/plain"
 	MimeTypeApplicationJson = "application/json"
+	MimeTypeTextCsv         = "text/csv"
 )
 
-func SendResponse(statusCode uint32, statusCodeDetails string, contentType, body string) error {
-	return proxywasm.SendHttpResponseWithDetail(statusCode, statusCodeDetails, CreateHeaders(HeaderContentType, contentType), []byte(body), -1)
+func SendResponse(statusCode uint32, statusCodeDetails string, contentType, body string, extraHeaders ...string) error {
+	headers := CreateHeaders(append([]string{HeaderContentType, contentType}, extraHeaders...)...)
+	return proxywasm.SendHttpResponseWithDetail(statusCode, statusCodeDetails, headers, []byte(body), -1)
 }
 
 func CreateHeaders(kvs ...string) [][2]string {
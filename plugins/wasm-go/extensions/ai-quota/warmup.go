@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/resp"
+)
+
+func parseWarmupConfig(json gjson.Result, config *QuotaConfig) error {
+	config.WarmupGraceSeconds = int(json.Get("warmup_grace_seconds").Int())
+	if config.WarmupGraceSeconds < 0 {
+		return errors.New("warmup_grace_seconds must not be negative")
+	}
+	config.warmupDeadline = time.Now().Unix() + int64(config.WarmupGraceSeconds)
+	return nil
+}
+
+// inWarmupGrace reports whether the request should skip quota enforcement because Redis
+// is not ready yet and the warm-up grace period after the config was loaded is still running
+func (c QuotaConfig) inWarmupGrace() bool {
+	if c.WarmupGraceSeconds == 0 || c.redisClient.Ready() {
+		return false
+	}
+	if time.Now().Unix() >= c.warmupDeadline {
+		return false
+	}
+	// Dispatching retries the deferred Init, so the client becomes ready as soon as Redis is
+	// reachable and the request is enforced normally
+	if err := c.redisClient.Command([]interface{}{"ping"}, func(response resp.Value) {}); err == nil {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/alibaba/higress/plugins/wasm-go/pkg/wrapper"
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// exhaustionWebhookTickMs is how often queued notifications are sent
+	exhaustionWebhookTickMs = 1000
+	// exhaustionWebhookTimeoutMs bounds each webhook call
+	exhaustionWebhookTimeoutMs = 2000
+	// exhaustionWebhookMaxPending caps the queue so a webhook outage can't grow it unbounded
+	exhaustionWebhookMaxPending = 1000
+)
+
+type exhaustionEvent struct {
+	UserId    string `json:"user_id"`
+	Model     string `json:"model"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// exhaustionWebhook notifies an external endpoint when a user runs out of quota. Events are
+// queued by the request and sent from the root context tick, so the rejection is never held
+// up and the call is not cancelled when the rejected request completes.
+type exhaustionWebhook struct {
+	client          wrapper.HttpClient
+	path            string
+	intervalSeconds int64
+	// last notification per user, limiting each user to one notification per interval
+	lastSent map[string]int64
+	pending  []exhaustionEvent
+}
+
+func parseExhaustionWebhook(json gjson.Result, config *QuotaConfig) error {
+	config.ExhaustionWebhookUrl = json.Get("exhaustion_webhook_url").String()
+	if config.ExhaustionWebhookUrl == "" {
+		return nil
+	}
+	client, path, err := newWebhookClient(config, config.ExhaustionWebhookUrl)
+	if err != nil {
+		return fmt.Errorf("invalid exhaustion_webhook_url: %w", err)
+	}
+	config.ExhaustionWebhookInterval = int(json.Get("exhaustion_webhook_interval").Int())
+	if config.ExhaustionWebhookInterval < 0 {
+		return errors.New("exhaustion_webhook_interval must not be negative")
+	}
+	if config.ExhaustionWebhookInterval == 0 {
+		config.ExhaustionWebhookInterval = 300
+	}
+
+	config.exhaustionWebhook = &exhaustionWebhook{
+		client:          client,
+		path:            path,
+		intervalSeconds: int64(config.ExhaustionWebhookInterval),
+		lastSent:        make(map[string]int64),
+	}
+	wrapper.RegisteTickFunc(exhaustionWebhookTickMs, config.exhaustionWebhook.flush)
+	return nil
+}
+
+// parseWebhookTLS reads how webhook calls reach their endpoint. Certificates and SNI
+// are verified by the upstream cluster, the plugin can only pick it and refuse plaintext.
+func parseWebhookTLS(json gjson.Result, config *QuotaConfig) {
+	config.WebhookCluster = json.Get("webhook_cluster").String()
+	config.WebhookRequireHttps = json.Get("webhook_require_https").Bool()
+}
+
+// newWebhookClient builds the client and request path for a webhook url, sending through
+// webhook_cluster when one is configured
+func newWebhookClient(config *QuotaConfig, rawUrl string) (wrapper.HttpClient, string, error) {
+	webhookUrl, err := url.Parse(rawUrl)
+	if err != nil || webhookUrl.Hostname() == "" {
+		return nil, "", fmt.Errorf("%s is not a url with a host", rawUrl)
+	}
+	if config.WebhookRequireHttps && webhookUrl.Scheme != "https" {
+		return nil, "", fmt.Errorf("%s must use https when webhook_require_https is set", rawUrl)
+	}
+	if config.WebhookCluster != "" {
+		client := wrapper.NewClusterClient(wrapper.TargetCluster{
+			Cluster: config.WebhookCluster,
+			Host:    webhookUrl.Host,
+		})
+		return client, webhookUrl.RequestURI(), nil
+	}
+	port := int64(80)
+	if webhookUrl.Scheme == "https" {
+		port = 443
+	}
+	if webhookUrl.Port() != "" {
+		port, _ = strconv.ParseInt(webhookUrl.Port(), 10, 64)
+	}
+	client := wrapper.NewClusterClient(wrapper.FQDNCluster{
+		FQDN: webhookUrl.Hostname(),
+		Port: port,
+	})
+	return client, webhookUrl.RequestURI(), nil
+}
+
+// notify queues a notification for the user unless one was sent within the interval
+func (w *exhaustionWebhook) notify(userId string, modelName string) {
+	now := time.Now().Unix()
+	if last, exists := w.lastSent[userId]; exists && now-last < w.intervalSeconds {
+		return
+	}
+	if len(w.pending) >= exhaustionWebhookMaxPending {
+		proxywasm.LogWarnf("Exhaustion webhook queue is full, dropping notification for user %s", maskUserId(userId))
+		return
+	}
+	w.lastSent[userId] = now
+	w.pending = append(w.pending, exhaustionEvent{UserId: userId, Model: modelName, Timestamp: now})
+}
+
+func (w *exhaustionWebhook) flush() {
+	now := time.Now().Unix()
+	for userId, last := range w.lastSent {
+		if now-last >= w.intervalSeconds {
+			delete(w.lastSent, userId)
+		}
+	}
+	if len(w.pending) == 0 {
+		return
+	}
+	headers := [][2]string{{"content-type", "application/json"}}
+	for _, event := range w.pending {
+		body, _ := json.Marshal(event)
+		userId := event.UserId
+		err := w.client.Post(w.path, headers, body, func(statusCode int, responseHeaders http.Header, responseBody []byte) {
+			if statusCode < 200 || statusCode >= 300 {
+				proxywasm.LogWarnf("Exhaustion webhook for user %s returned status %d", maskUserId(userId), statusCode)
+			}
+		}, exhaustionWebhookTimeoutMs)
+		if err != nil {
+			proxywasm.LogWarnf("Failed to dispatch exhaustion webhook for user %s: %v", maskUserId(userId), err)
+		}
+	}
+	w.pending = w.pending[:0]
+}
@@ -0,0 +1,30 @@
+package main
+
+import "strings"
+
+// modelWeightFallbackSeparators end the version suffixes stripped by the weight fallback
+const modelWeightFallbackSeparators = "-:@"
+
+// modelWeight looks up the weight of a model. With model_weight_fallback, a model without
+// a weight of its own is priced like its base model, stripping one version suffix at a
+// time (gpt-4-0613 -> gpt-4). Returns the weight, the model it was found under and
+// whether one was found.
+func (c QuotaConfig) modelWeight(modelName string) (int, string, bool) {
+	if weight, exists := c.currentWeight(modelName); exists {
+		return weight, modelName, true
+	}
+	if !c.ModelWeightFallback {
+		return 0, "", false
+	}
+	base := modelName
+	for {
+		i := strings.LastIndexAny(base, modelWeightFallbackSeparators)
+		if i <= 0 {
+			return 0, "", false
+		}
+		base = base[:i]
+		if weight, exists := c.currentWeight(base); exists {
+			return weight, base, true
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/higress-group/proxy-wasm-go-sdk/proxywasm"
+	"github.com/tidwall/gjson"
+)
+
+var errInvalidWeightOverride = errors.New("invalid weight override")
+
+func parseWeightOverrideConfig(json gjson.Result, config *QuotaConfig) error {
+	config.WeightOverrideHeader = json.Get("weight_override_header").String()
+	if config.WeightOverrideHeader == "" {
+		config.WeightOverrideHeader = "x-quota-weight"
+	}
+	if overrideMax := json.Get("weight_override_max"); overrideMax.Exists() {
+		var err error
+		config.WeightOverrideMax, err = config.parseQuota(strconv.FormatFloat(overrideMax.Float(), 'f', -1, 64))
+		if err != nil {
+			return fmt.Errorf("invalid weight_override_max: %v", err)
+		}
+		if config.WeightOverrideMax < 0 {
+			return errors.New("weight_override_max must not be negative")
+		}
+	}
+	// Without a trusted source any client could price its own requests
+	if config.WeightOverrideMax > 0 && !config.TrustedProxy.enabled() {
+		return errors.New("weight_override_max requires trusted_proxy")
+	}
+	return nil
+}
+
+// weightOverride returns the quota weight set by a trusted orchestrator for this request.
+// Untrusted requests never get here with the header, it is stripped with the deduct header.
+func (c QuotaConfig) weightOverride() (int, bool, error) {
+	if c.WeightOverrideMax == 0 {
+		return 0, false, nil
+	}
+	value, err := proxywasm.GetHttpRequestHeader(c.WeightOverrideHeader)
+	if err != nil || value == "" {
+		return 0, false, nil
+	}
+	weight, err := c.parseQuota(value)
+	if err != nil || weight <= 0 || weight > c.WeightOverrideMax {
+		return 0, false, fmt.Errorf("%w: %s must be a positive amount up to %s", errInvalidWeightOverride, c.WeightOverrideHeader, c.formatQuota(c.WeightOverrideMax))
+	}
+	return weight, true, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// weightChange is a model weight taking effect at a scheduled time
+type weightChange struct {
+	Weight        int       `yaml:"weight" json:"weight"` // In quota units, see QuotaPrecision
+	EffectiveFrom time.Time `yaml:"effective_from" json:"effective_from"`
+}
+
+func parseWeightSchedules(json gjson.Result, config *QuotaConfig) error {
+	config.ModelWeightSchedules = make(map[string][]weightChange)
+	var scheduleErr error
+	json.Get("model_weight_schedules").ForEach(func(key, value gjson.Result) bool {
+		changes := make([]weightChange, 0)
+		for i, item := range value.Array() {
+			weight, err := config.parseQuota(strconv.FormatFloat(item.Get("weight").Float(), 'f', -1, 64))
+			if err != nil || weight < 0 || !item.Get("weight").Exists() {
+				scheduleErr = fmt.Errorf("invalid scheduled weight %d of model %s: must be a non-negative number within quota_precision decimal places", i, key.String())
+				return false
+			}
+			effectiveFrom, err := time.Parse(time.RFC3339, item.Get("effective_from").String())
+			if err != nil {
+				scheduleErr = fmt.Errorf("invalid effective_from of scheduled weight %d of model %s, must be an RFC 3339 time: %v", i, key.String(), err)
+				return false
+			}
+			changes = append(changes, weightChange{Weight: weight, EffectiveFrom: effectiveFrom})
+		}
+		sort.SliceStable(changes, func(i, j int) bool {
+			return changes[i].EffectiveFrom.Before(changes[j].EffectiveFrom)
+		})
+		config.ModelWeightSchedules[key.String()] = changes
+		return true
+	})
+	return scheduleErr
+}
+
+// currentWeight is the weight of the model at this moment: the latest scheduled change
+// that has taken effect on the node clock, or else its model_quota_weights entry
+func (c QuotaConfig) currentWeight(modelName string) (int, bool) {
+	now := time.Now()
+	changes := c.ModelWeightSchedules[modelName]
+	for i := len(changes) - 1; i >= 0; i-- {
+		if !changes[i].EffectiveFrom.After(now) {
+			return changes[i].Weight, true
+		}
+	}
+	weight, exists := c.ModelQuotaWeights[modelName]
+	return weight, exists
+}
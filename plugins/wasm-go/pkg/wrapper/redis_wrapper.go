@@ -39,6 +39,7 @@ const (
 	RedisErrorTypeCommand
 	RedisErrorTypeNetwork
 	RedisErrorTypeUnknown
+	RedisErrorTypeBackpressure
 )
 
 // RedisError represents a Redis operation error with context
@@ -52,6 +53,9 @@ type RedisError struct {
 }
 
 func (e *RedisError) Error() string {
+	if e.Operation == "" {
+		return fmt.Sprintf("Redis %s error: %s", e.TypeString(), e.Message)
+	}
 	return fmt.Sprintf("Redis %s error in %s (key: %s): %s", e.TypeString(), e.Operation, e.Key, e.Message)
 }
 
@@ -69,6 +73,8 @@ func (e *RedisError) TypeString() string {
 		return "Command"
 	case RedisErrorTypeNetwork:
 		return "Network"
+	case RedisErrorTypeBackpressure:
+		return "Backpressure"
 	default:
 		return "Unknown"
 	}
@@ -107,6 +113,12 @@ type RedisClient interface {
 	Exists(key string, callback RedisResponseCallback) error
 	Expire(key string, ttl int, callback RedisResponseCallback) error
 	Persist(key string, callback RedisResponseCallback) error
+	Rename(src string, dst string, callback RedisResponseCallback) error
+	// RenameNX renames src only when dst doesn't exist, replying 1 when renamed and 0 otherwise
+	RenameNX(src string, dst string, callback RedisResponseCallback) error
+	// MemoryUsage replies the bytes used by the key and its value, or null when the key doesn't
+	// exist. Nested values are sampled, samples of 0 uses the server default and -1 samples all
+	MemoryUsage(key string, samples int, callback RedisResponseCallback) error
 
 	// String
 	Get(key string, callback RedisResponseCallback) error
@@ -119,11 +131,33 @@ type RedisClient interface {
 	Decr(key string, callback RedisResponseCallback) error
 	IncrBy(key string, delta int, callback RedisResponseCallback) error
 	DecrBy(key string, delta int, callback RedisResponseCallback) error
+	// LCS requires Redis 7; the reply is the subsequence, or its length when withLen is set
+	LCS(key1, key2 string, withLen bool, callback RedisResponseCallback) error
+
+	// Server
+	Wait(numReplicas int, timeout int, callback RedisResponseCallback) error
+	// Reset returns the connection to a clean state, requires Redis 6.2
+	Reset(callback RedisResponseCallback) error
+
+	// Bitmap
+	BitField(key string, ops []BitFieldOp, callback RedisResponseCallback) error
+
+	// Generic
+	Sort(key string, by, get string, limit [2]int, alpha, desc bool, callback RedisResponseCallback) error
 
 	// Optimized batch operations for quota management
 	BatchGetQuotaInfo(totalKey, usedKey string, callback RedisResponseCallback) error
 	BatchSetWithExpiry(kvMap map[string]interface{}, ttl int, callback RedisResponseCallback) error
 	AtomicQuotaCheck(totalKey, usedKey string, quotaWeight int, callback RedisResponseCallback) error
+	// AtomicBatchQuotaCheck deducts the sum of all weights, or nothing when the remaining quota is short
+	AtomicBatchQuotaCheck(totalKey, usedKey string, quotaWeights []int, callback RedisResponseCallback) error
+	// MultiCounterDeduct deducts the cost across the counters as the mode says, or nothing
+	// when they can't cover it; ParseMultiCounterDeduct reads the response
+	MultiCounterDeduct(counters []QuotaCounter, cost int, mode DeductMode, callback RedisResponseCallback) error
+	// SeenRecently records id and reports whether it was already seen within the window
+	SeenRecently(id string, windowSeconds int, callback func(seen bool, err error)) error
+	// DecrByFloor decrements key by delta without going below floor
+	DecrByFloor(key string, delta, floor int, callback func(value int, clamped bool, err error)) error
 
 	// List
 	LLen(key string, callback RedisResponseCallback) error
@@ -169,12 +203,30 @@ type RedisClient interface {
 	ZAdd(key string, msMap map[string]interface{}, callback RedisResponseCallback) error
 	ZCount(key string, min interface{}, max interface{}, callback RedisResponseCallback) error
 	ZIncrBy(key string, member string, delta interface{}, callback RedisResponseCallback) error
+	// ZAddIncr adds delta to the member's score like ZINCRBY, but honoring NX/XX/GT/LT flags;
+	// the reply is the new score, or nil when a condition isn't met, see ZAddIncrScore
+	ZAddIncr(key string, flags []string, member string, delta interface{}, callback RedisResponseCallback) error
 	ZScore(key, member string, callback RedisResponseCallback) error
 	ZRank(key, member string, callback RedisResponseCallback) error
 	ZRevRank(key, member string, callback RedisResponseCallback) error
 	ZRem(key string, members []string, callback RedisResponseCallback) error
 	ZRange(key string, start, stop int, callback RedisResponseCallback) error
 	ZRevRange(key string, start, stop int, callback RedisResponseCallback) error
+	ZRangeStore(dst, src string, start, stop int, byScore, rev bool, callback RedisResponseCallback) error
+}
+
+// BitFieldOp describes a single GET, SET or INCRBY subcommand of BITFIELD
+type BitFieldOp struct {
+	// Op is one of GET, SET or INCRBY
+	Op string
+	// Type is the integer encoding, e.g. u8 or i16
+	Type string
+	// Offset is the bit offset, or the index of the field when Scaled is set
+	Offset int
+	// Scaled multiplies Offset by the type width, emitting the #N offset form
+	Scaled bool
+	// Value is the value to set for SET, or the increment for INCRBY
+	Value int64
 }
 
 type RedisClusterClient[C Cluster] struct {
@@ -182,10 +234,23 @@ type RedisClusterClient[C Cluster] struct {
 	ready          bool
 	checkReadyFunc func() error
 	option         redisOption
+	// whether the RedisBloom module is available, detected on first use
+	bloomSupport bloomSupport
 }
 
+type bloomSupport int
+
+const (
+	bloomSupportUnknown bloomSupport = iota
+	bloomSupportAvailable
+	bloomSupportUnavailable
+)
+
 type redisOption struct {
-	dataBase int
+	dataBase             int
+	maxInFlight          int
+	failover             []Cluster
+	resetOnProtocolError bool
 }
 
 type optionFunc func(*redisOption)
@@ -196,6 +261,75 @@ func WithDataBase(dataBase int) optionFunc {
 	}
 }
 
+// WithMaxInFlight limits the number of Redis calls of the client dispatched but not yet
+// answered. Calls over the limit are rejected with a Backpressure error. The limit and the
+// count are kept per primary cluster, 0 means unlimited.
+func WithMaxInFlight(maxInFlight int) optionFunc {
+	return func(o *redisOption) {
+		o.maxInFlight = maxInFlight
+	}
+}
+
+// redisMaxInFlight holds the in-flight limits set by WithMaxInFlight, keyed by primary cluster name
+var redisMaxInFlight = make(map[string]int64)
+
+// redisInFlightCalls counts the dispatched calls waiting for a response, keyed by primary cluster name
+var redisInFlightCalls = make(map[string]int64)
+
+// WithFailover sets backends tried in order when a call to the primary fails with a
+// connection or network error. They are initialized with the primary's credentials
+// and database.
+func WithFailover(clusters ...Cluster) optionFunc {
+	return func(o *redisOption) {
+		o.failover = clusters
+	}
+}
+
+// WithResetOnProtocolError sends RESET to the backend after a response fails to parse,
+// recovering a connection left in a bad state, e.g. by a partial pipeline, without a
+// full re-init. RESET also drops the selected database and authentication of the
+// connection, so only enable it when the proxy re-establishes them.
+func WithResetOnProtocolError() optionFunc {
+	return func(o *redisOption) {
+		o.resetOnProtocolError = true
+	}
+}
+
+// redisResetOnProtocolError holds the primary cluster names registered by WithResetOnProtocolError
+var redisResetOnProtocolError = make(map[string]bool)
+
+// shouldResetAfter reports whether a RESET should follow the failed call, never for a
+// failed RESET itself so that a broken connection doesn't loop
+func shouldResetAfter(redisErr *RedisError, primary Cluster) bool {
+	return redisErr.Type == RedisErrorTypeProtocol && redisErr.Operation != "RESET" &&
+		redisResetOnProtocolError[primary.ClusterName()]
+}
+
+// redisFailoverBackends holds the failover backends registered by WithFailover, keyed by primary cluster name
+var redisFailoverBackends = make(map[string][]Cluster)
+
+// redisBackend returns the cluster serving the given backend index, 0 being the primary
+func redisBackend(primary Cluster, backend int) (Cluster, bool) {
+	if backend == 0 {
+		return primary, true
+	}
+	backends := redisFailoverBackends[primary.ClusterName()]
+	if backend > len(backends) {
+		return nil, false
+	}
+	return backends[backend-1], true
+}
+
+// shouldFailover reports whether the error means the backend is unreachable
+// and the call should move on to the next backend, if there is one
+func shouldFailover(redisErr *RedisError, primary Cluster, backend int) bool {
+	if redisErr.Type != RedisErrorTypeConnection && redisErr.Type != RedisErrorTypeNetwork {
+		return false
+	}
+	_, ok := redisBackend(primary, backend+1)
+	return ok
+}
+
 func NewRedisClusterClient[C Cluster](cluster C) *RedisClusterClient[C] {
 	return &RedisClusterClient[C]{
 		cluster: cluster,
@@ -207,7 +341,7 @@ func NewRedisClusterClient[C Cluster](cluster C) *RedisClusterClient[C] {
 
 // RedisCallWithRetry provides enhanced Redis call with intelligent error handling and retries
 func RedisCallWithRetry(cluster Cluster, respQuery []byte, callback RedisResponseCallback, operation string, key string, config RetryConfig) error {
-	return redisCallInternal(cluster, respQuery, callback, operation, key, config, 0)
+	return redisCallInternal(cluster, respQuery, callback, operation, key, config, 0, 0)
 }
 
 // RedisCall maintains backward compatibility with existing code
@@ -216,8 +350,9 @@ func RedisCall(cluster Cluster, respQuery []byte, callback RedisResponseCallback
 }
 
 // redisCallInternal handles the actual Redis call with retry logic
-func redisCallInternal(cluster Cluster, respQuery []byte, callback RedisResponseCallback, operation string, key string, config RetryConfig, attempt int) error {
+func redisCallInternal(cluster Cluster, respQuery []byte, callback RedisResponseCallback, operation string, key string, config RetryConfig, attempt int, backend int) error {
 	requestID := uuid.New().String()
+	target, _ := redisBackend(cluster, backend)
 
 	// Update metrics
 	globalRedisMetrics.TotalCalls++
@@ -225,10 +360,29 @@ func redisCallInternal(cluster Cluster, respQuery []byte, callback RedisResponse
 		globalRedisMetrics.RetryAttempts++
 	}
 
+	// Apply back-pressure when too many calls are waiting for a response
+	primary := cluster.ClusterName()
+	if limit := redisMaxInFlight[primary]; limit > 0 && redisInFlightCalls[primary] >= limit {
+		globalRedisMetrics.RejectedCalls++
+		globalRedisMetrics.FailedCalls++
+		proxywasm.LogWarnf("Redis call rejected, %d calls in flight to %s reached the limit, operation: %s, key: %s, request-id: %s",
+			redisInFlightCalls[primary], primary, operation, key, requestID)
+		return &RedisError{
+			Type:      RedisErrorTypeBackpressure,
+			Operation: operation,
+			Key:       key,
+			Message:   fmt.Sprintf("too many in-flight Redis calls (limit %d)", redisMaxInFlight[primary]),
+			Retryable: true,
+			Temporary: true,
+		}
+	}
+
 	_, err := proxywasm.DispatchRedisCall(
-		cluster.ClusterName(),
+		target.ClusterName(),
 		respQuery,
 		func(status int, responseSize int) {
+			globalRedisMetrics.InFlightCalls--
+			redisInFlightCalls[primary]--
 			response, err := proxywasm.GetRedisCallResponse(0, responseSize)
 			var responseValue resp.Value
 			var redisErr *RedisError
@@ -236,6 +390,18 @@ func redisCallInternal(cluster Cluster, respQuery []byte, callback RedisResponse
 			if status != 0 || err != nil {
 				// Classify the error for better handling
 				redisErr = classifyRedisError(status, err, operation, key)
+				recordBackendResult(target, redisErr)
+
+				if shouldFailover(redisErr, cluster, backend) {
+					proxywasm.LogWarnf("Redis backend %s unreachable: %s, failing over to the next backend, request-id: %s",
+						target.ClusterName(), redisErr.Message, requestID)
+					globalRedisMetrics.FailoverCalls++
+					// A failed re-dispatch has already been counted, report its error as is
+					if failoverErr := redisCallInternal(cluster, respQuery, callback, operation, key, config, attempt, backend+1); failoverErr != nil && callback != nil {
+						callback(resp.ErrorValue(failoverErr))
+					}
+					return
+				}
 
 				// Log error with appropriate level based on type
 				if redisErr.Type == RedisErrorTypeAuth || !redisErr.Temporary {
@@ -279,9 +445,20 @@ func redisCallInternal(cluster Cluster, respQuery []byte, callback RedisResponse
 					proxywasm.LogCriticalf("Redis protocol error: %s, request-id: %s", redisErr.Message, requestID)
 					globalRedisMetrics.FailedCalls++
 					responseValue = resp.ErrorValue(redisErr)
+					if shouldResetAfter(redisErr, cluster) {
+						proxywasm.LogWarnf("Resetting Redis connection to %s after protocol error, request-id: %s", target.ClusterName(), requestID)
+						if resetErr := RedisCallWithRetry(target, respString([]interface{}{"reset"}), nil, "RESET", "", DefaultRetryConfig); resetErr != nil {
+							proxywasm.LogWarnf("Failed to reset Redis connection to %s: %v, request-id: %s", target.ClusterName(), resetErr, requestID)
+						}
+					}
 				} else {
 					// Success case
 					globalRedisMetrics.SuccessfulCalls++
+					if globalRedisMetrics.BackendCalls == nil {
+						globalRedisMetrics.BackendCalls = make(map[string]int64)
+					}
+					globalRedisMetrics.BackendCalls[target.ClusterName()]++
+					recordBackendResult(target, nil)
 					responseValue = value
 					proxywasm.LogDebugf("Redis call successful, operation: %s, key: %s, request-id: %s, respQuery: %s, respValue: %s",
 						operation, key, requestID,
@@ -297,10 +474,19 @@ func redisCallInternal(cluster Cluster, respQuery []byte, callback RedisResponse
 
 	if err != nil {
 		redisErr := classifyRedisError(0, err, operation, key)
+		recordBackendResult(target, redisErr)
+		if shouldFailover(redisErr, cluster, backend) {
+			proxywasm.LogWarnf("Redis dispatch to %s failed: %s, failing over to the next backend, request-id: %s",
+				target.ClusterName(), redisErr.Message, requestID)
+			globalRedisMetrics.FailoverCalls++
+			return redisCallInternal(cluster, respQuery, callback, operation, key, config, attempt, backend+1)
+		}
 		proxywasm.LogCriticalf("Redis dispatch failed: %s, request-id: %s", redisErr.Message, requestID)
 		globalRedisMetrics.FailedCalls++
 		return redisErr
 	} else {
+		globalRedisMetrics.InFlightCalls++
+		redisInFlightCalls[primary]++
 		proxywasm.LogDebugf("Redis call dispatched, operation: %s, key: %s, request-id: %s, respQuery: %s",
 			operation, key, requestID, base64.StdEncoding.EncodeToString([]byte(respQuery)))
 	}
@@ -354,14 +540,40 @@ func (c *RedisClusterClient[C]) Ready() bool {
 	return c.ready
 }
 
+// initClusterName is the name a cluster is initialized with, selecting the configured database
+func (o redisOption) initClusterName(cluster Cluster) string {
+	if o.dataBase != 0 {
+		return fmt.Sprintf("%s?db=%d", cluster.ClusterName(), o.dataBase)
+	}
+	return cluster.ClusterName()
+}
+
 func (c *RedisClusterClient[C]) Init(username, password string, timeout int64, opts ...optionFunc) error {
 	for _, opt := range opts {
 		opt(&c.option)
 	}
-	clusterName := c.cluster.ClusterName()
-	if c.option.dataBase != 0 {
-		clusterName = fmt.Sprintf("%s?db=%d", clusterName, c.option.dataBase)
+	if c.option.maxInFlight > 0 {
+		redisMaxInFlight[c.cluster.ClusterName()] = int64(c.option.maxInFlight)
+	} else {
+		delete(redisMaxInFlight, c.cluster.ClusterName())
+	}
+	registerBackendState(c.cluster, true)
+	for _, backend := range c.option.failover {
+		registerBackendState(backend, false)
 	}
+	if c.option.resetOnProtocolError {
+		redisResetOnProtocolError[c.cluster.ClusterName()] = true
+	}
+	if len(c.option.failover) > 0 {
+		redisFailoverBackends[c.cluster.ClusterName()] = c.option.failover
+		// Failover backends serve the same database as the primary
+		for _, backend := range c.option.failover {
+			if err := proxywasm.RedisInit(c.option.initClusterName(backend), username, password, uint32(timeout)); err != nil {
+				proxywasm.LogWarnf("failed to init failover redis %s: %v", backend.ClusterName(), err)
+			}
+		}
+	}
+	clusterName := c.option.initClusterName(c.cluster)
 	err := proxywasm.RedisInit(clusterName, username, password, uint32(timeout))
 	if err != nil {
 		c.checkReadyFunc = func() error {
@@ -473,6 +685,46 @@ func (c *RedisClusterClient[C]) Persist(key string, callback RedisResponseCallba
 	return RedisCallWithRetry(c.cluster, respString(args), callback, "PERSIST", key, DefaultRetryConfig)
 }
 
+func (c *RedisClusterClient[C]) Rename(src string, dst string, callback RedisResponseCallback) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	args := make([]interface{}, 0)
+	args = append(args, "rename")
+	args = append(args, src)
+	args = append(args, dst)
+	return RedisCallWithRetry(c.cluster, respString(args), callback, "RENAME", src, DefaultRetryConfig)
+}
+
+func (c *RedisClusterClient[C]) RenameNX(src string, dst string, callback RedisResponseCallback) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	args := make([]interface{}, 0)
+	args = append(args, "renamenx")
+	args = append(args, src)
+	args = append(args, dst)
+	return RedisCallWithRetry(c.cluster, respString(args), callback, "RENAMENX", src, DefaultRetryConfig)
+}
+
+func (c *RedisClusterClient[C]) MemoryUsage(key string, samples int, callback RedisResponseCallback) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	args := make([]interface{}, 0)
+	args = append(args, "memory")
+	args = append(args, "usage")
+	args = append(args, key)
+	if samples > 0 {
+		args = append(args, "samples")
+		args = append(args, samples)
+	} else if samples < 0 {
+		args = append(args, "samples")
+		args = append(args, 0)
+	}
+	return RedisCallWithRetry(c.cluster, respString(args), callback, "MEMORY", key, DefaultRetryConfig)
+}
+
 // String
 func (c *RedisClusterClient[C]) Get(key string, callback RedisResponseCallback) error {
 	if err := c.checkReadyFunc(); err != nil {
@@ -629,6 +881,148 @@ func (c *RedisClusterClient[C]) DecrBy(key string, delta int, callback RedisResp
 	return RedisCallWithRetry(c.cluster, respString(args), callback, "DECRBY", key, DefaultRetryConfig)
 }
 
+// LCS returns the longest common subsequence of the strings stored at key1 and key2.
+// The reply is a bulk string, or an integer holding its length when withLen is set.
+// In cluster mode both keys must hash to the same slot.
+func (c *RedisClusterClient[C]) LCS(key1, key2 string, withLen bool, callback RedisResponseCallback) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	return RedisCallWithRetry(c.cluster, respString(buildLCSArgs(key1, key2, withLen)), callback, "LCS", key1, DefaultRetryConfig)
+}
+
+func buildLCSArgs(key1, key2 string, withLen bool) []interface{} {
+	args := make([]interface{}, 0)
+	args = append(args, "lcs")
+	args = append(args, key1)
+	args = append(args, key2)
+	if withLen {
+		args = append(args, "len")
+	}
+	return args
+}
+
+// Server
+func (c *RedisClusterClient[C]) Wait(numReplicas int, timeout int, callback RedisResponseCallback) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	args := make([]interface{}, 0)
+	args = append(args, "wait")
+	args = append(args, numReplicas)
+	args = append(args, timeout)
+	return RedisCallWithRetry(c.cluster, respString(args), callback, "WAIT", "", DefaultRetryConfig)
+}
+
+// Reset issues RESET, discarding any transaction, watched keys and subscriptions of the
+// connection and replying with the RESET status
+func (c *RedisClusterClient[C]) Reset(callback RedisResponseCallback) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	return RedisCallWithRetry(c.cluster, respString([]interface{}{"reset"}), callback, "RESET", "", DefaultRetryConfig)
+}
+
+// Bitmap
+func (c *RedisClusterClient[C]) BitField(key string, ops []BitFieldOp, callback RedisResponseCallback) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	args, err := buildBitFieldArgs(key, ops)
+	if err != nil {
+		return err
+	}
+	return RedisCallWithRetry(c.cluster, respString(args), callback, "BITFIELD", key, DefaultRetryConfig)
+}
+
+// buildBitFieldArgs validates the operations and builds the BITFIELD command arguments
+func buildBitFieldArgs(key string, ops []BitFieldOp) ([]interface{}, error) {
+	if len(ops) == 0 {
+		return nil, errors.New("bitfield requires at least one operation")
+	}
+	args := make([]interface{}, 0, 2+len(ops)*4)
+	args = append(args, "bitfield")
+	args = append(args, key)
+	for i, op := range ops {
+		if op.Type == "" {
+			return nil, fmt.Errorf("bitfield operation %d: type must not be empty", i)
+		}
+		if op.Offset < 0 {
+			return nil, fmt.Errorf("bitfield operation %d: offset must not be negative", i)
+		}
+		offset := fmt.Sprint(op.Offset)
+		if op.Scaled {
+			offset = "#" + offset
+		}
+		switch strings.ToLower(op.Op) {
+		case "get":
+			args = append(args, "get", op.Type, offset)
+		case "set":
+			args = append(args, "set", op.Type, offset, op.Value)
+		case "incrby":
+			args = append(args, "incrby", op.Type, offset, op.Value)
+		default:
+			return nil, fmt.Errorf("bitfield operation %d: unsupported op %q", i, op.Op)
+		}
+	}
+	return args, nil
+}
+
+// Generic
+// Sort sorts the elements of a list, set or sorted set. An empty by or get omits
+// the clause, and limit is {offset, count} with {0, 0} meaning no limit.
+func (c *RedisClusterClient[C]) Sort(key string, by, get string, limit [2]int, alpha, desc bool, callback RedisResponseCallback) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	args, err := buildSortArgs(key, by, get, limit, alpha, desc)
+	if err != nil {
+		return err
+	}
+	return RedisCallWithRetry(c.cluster, respString(args), callback, "SORT", key, DefaultRetryConfig)
+}
+
+// buildSortArgs validates the clause combination and builds the SORT command arguments
+func buildSortArgs(key string, by, get string, limit [2]int, alpha, desc bool) ([]interface{}, error) {
+	noSort := strings.EqualFold(by, "nosort")
+	if by != "" && !noSort && !strings.Contains(by, "*") {
+		return nil, fmt.Errorf("sort by pattern %q must contain *", by)
+	}
+	if noSort && (alpha || desc) {
+		return nil, errors.New("sort alpha and desc have no effect with by nosort")
+	}
+	if get != "" && get != "#" && !strings.Contains(get, "*") {
+		return nil, fmt.Errorf("sort get pattern %q must contain * or be #", get)
+	}
+	offset, count := limit[0], limit[1]
+	if offset < 0 {
+		return nil, errors.New("sort limit offset must not be negative")
+	}
+	if offset > 0 && count == 0 {
+		return nil, errors.New("sort limit count must not be 0 when offset is set")
+	}
+
+	args := make([]interface{}, 0)
+	args = append(args, "sort")
+	args = append(args, key)
+	if by != "" {
+		args = append(args, "by", by)
+	}
+	if offset != 0 || count != 0 {
+		args = append(args, "limit", offset, count)
+	}
+	if get != "" {
+		args = append(args, "get", get)
+	}
+	if desc {
+		args = append(args, "desc")
+	}
+	if alpha {
+		args = append(args, "alpha")
+	}
+	return args, nil
+}
+
 // List
 func (c *RedisClusterClient[C]) LLen(key string, callback RedisResponseCallback) error {
 	if err := c.checkReadyFunc(); err != nil {
@@ -1062,6 +1456,36 @@ func (c *RedisClusterClient[C]) ZIncrBy(key string, member string, delta interfa
 	return RedisCall(c.cluster, respString(args), callback)
 }
 
+func (c *RedisClusterClient[C]) ZAddIncr(key string, flags []string, member string, delta interface{}, callback RedisResponseCallback) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	args := buildZAddIncrArgs(key, flags, member, delta)
+	return RedisCallWithRetry(c.cluster, respString(args), callback, "ZADD", key, DefaultRetryConfig)
+}
+
+func buildZAddIncrArgs(key string, flags []string, member string, delta interface{}) []interface{} {
+	args := make([]interface{}, 0)
+	args = append(args, "zadd")
+	args = append(args, key)
+	for _, flag := range flags {
+		args = append(args, strings.ToLower(flag))
+	}
+	args = append(args, "incr")
+	args = append(args, delta)
+	args = append(args, member)
+	return args
+}
+
+// ZAddIncrScore reads a ZAddIncr reply, ok is false when the score was not updated
+// because a NX/XX/GT/LT condition wasn't met
+func ZAddIncrScore(response resp.Value) (score float64, ok bool) {
+	if response.IsNull() {
+		return 0, false
+	}
+	return response.Float(), true
+}
+
 func (c *RedisClusterClient[C]) ZScore(key, member string, callback RedisResponseCallback) error {
 	if err := c.checkReadyFunc(); err != nil {
 		return err
@@ -1132,6 +1556,34 @@ func (c *RedisClusterClient[C]) ZRevRange(key string, start, stop int, callback
 	return RedisCall(c.cluster, respString(args), callback)
 }
 
+// ZRangeStore copies a range of src into dst on the server side. With byScore,
+// start and stop are scores instead of ranks; with rev the order is reversed,
+// so a reversed score range expects start to be the upper bound. In cluster
+// mode dst and src must hash to the same slot.
+func (c *RedisClusterClient[C]) ZRangeStore(dst, src string, start, stop int, byScore, rev bool, callback RedisResponseCallback) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	args := buildZRangeStoreArgs(dst, src, start, stop, byScore, rev)
+	return RedisCallWithRetry(c.cluster, respString(args), callback, "ZRANGESTORE", dst, DefaultRetryConfig)
+}
+
+func buildZRangeStoreArgs(dst, src string, start, stop int, byScore, rev bool) []interface{} {
+	args := make([]interface{}, 0)
+	args = append(args, "zrangestore")
+	args = append(args, dst)
+	args = append(args, src)
+	args = append(args, start)
+	args = append(args, stop)
+	if byScore {
+		args = append(args, "byscore")
+	}
+	if rev {
+		args = append(args, "rev")
+	}
+	return args
+}
+
 // BatchGetQuotaInfo optimizes quota checking by using MGET for multiple keys
 func (c *RedisClusterClient[C]) BatchGetQuotaInfo(totalKey, usedKey string, callback RedisResponseCallback) error {
 	if err := c.checkReadyFunc(); err != nil {
@@ -1203,6 +1655,361 @@ func (c *RedisClusterClient[C]) AtomicQuotaCheck(totalKey, usedKey string, quota
 	return c.Eval(script, 2, keys, args, callback)
 }
 
+// atomicBatchQuotaScript checks the sum of all weights against the remaining
+// quota and deducts it in one step. Returns {total, used, remaining, success}.
+const atomicBatchQuotaScript = `
+	local total_quota = tonumber(redis.call('get', KEYS[1])) or 0
+	local used_quota = tonumber(redis.call('get', KEYS[2])) or 0
+	local remaining_quota = total_quota - used_quota
+	local sum = 0
+	for i = 1, #ARGV do
+		sum = sum + tonumber(ARGV[i])
+	end
+	if remaining_quota < sum then
+		return {total_quota, used_quota, remaining_quota, 0}
+	end
+	redis.call('incrby', KEYS[2], sum)
+	return {total_quota, used_quota, remaining_quota, 1}
+`
+
+// AtomicBatchQuotaCheck is AtomicQuotaCheck for several weights at once, e.g. a request
+// fanning out to multiple models. Either all weights are deducted or none.
+func (c *RedisClusterClient[C]) AtomicBatchQuotaCheck(totalKey, usedKey string, quotaWeights []int, callback RedisResponseCallback) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	if len(quotaWeights) == 0 {
+		return errors.New("atomic batch quota check needs at least one weight")
+	}
+	args := make([]interface{}, 0, len(quotaWeights))
+	for _, weight := range quotaWeights {
+		if weight < 0 {
+			return errors.New("atomic batch quota check weights must not be negative")
+		}
+		args = append(args, weight)
+	}
+	return c.Eval(atomicBatchQuotaScript, 2, []interface{}{totalKey, usedKey}, args, callback)
+}
+
+// DeductMode is how MultiCounterDeduct spreads the cost over its counters
+type DeductMode string
+
+const (
+	// DeductSpill takes as much as possible from each counter before moving on to the
+	// next, e.g. a free allowance before the paid balance
+	DeductSpill DeductMode = "spill"
+	// DeductEach takes the whole cost from every counter, e.g. a team pool and the
+	// member's sub-limit
+	DeductEach DeductMode = "each"
+	// DeductFirstFunded takes the whole cost from the first counter with a positive
+	// limit, or from the last one, e.g. a user's own quota before the org's
+	DeductFirstFunded DeductMode = "first_funded"
+)
+
+// QuotaCounter is one source of quota for MultiCounterDeduct: its used counter and
+// either a key holding its limit or a fixed limit
+type QuotaCounter struct {
+	UsedKey string
+	// LimitKey holds the limit, missing keys count as 0; when empty Limit is used
+	LimitKey string
+	Limit    int
+	// Optional counters are skipped when their LimitKey doesn't exist
+	Optional bool
+	// TTLSeconds expires the used key when the deduction creates it, 0 keeps it
+	TTLSeconds int
+}
+
+// multiCounterDeductScript deducts the cost from the counters in the way the mode in
+// ARGV[2] says. KEYS are the used keys followed by the limit keys of the counters that
+// have one. ARGV is the cost, the mode, then a limit spec per counter and a TTL per
+// counter. A spec is either the counter's fixed limit, the empty string to read the next
+// limit key, or "?" to read it and skip the counter when it's missing. Returns
+// {1, remaining, taken per counter..., left per counter...} or {0, remaining, refusing
+// counter}, where skipped counters are left -1 and the refusing counter is 0 when only
+// the combined remaining quota is short.
+const multiCounterDeductScript = `
+	local cost = tonumber(ARGV[1])
+	local mode = ARGV[2]
+	local n = (#ARGV - 2) / 2
+	local next_limit_key = n + 1
+	local limits, left, taken = {}, {}, {}
+	for i = 1, n do
+		local spec = ARGV[i + 2]
+		local limit
+		if spec == '' or spec == '?' then
+			limit = tonumber(redis.call('get', KEYS[next_limit_key]))
+			next_limit_key = next_limit_key + 1
+			if limit == nil and spec == '' then
+				limit = 0
+			end
+		else
+			limit = tonumber(spec)
+		end
+		limits[i] = limit
+		left[i] = -1
+		if limit ~= nil then
+			left[i] = math.max(limit - (tonumber(redis.call('get', KEYS[i])) or 0), 0)
+		end
+		taken[i] = 0
+	end
+	local remaining
+	if mode == 'each' then
+		for i = 1, n do
+			if left[i] >= 0 and left[i] < cost then
+				return {0, left[i], i}
+			end
+		end
+		for i = 1, n do
+			if left[i] >= 0 then
+				taken[i] = cost
+				if remaining == nil or left[i] - cost < remaining then
+					remaining = left[i] - cost
+				end
+			end
+		end
+	elseif mode == 'first_funded' then
+		local chosen = n
+		for i = 1, n do
+			if limits[i] ~= nil and limits[i] > 0 then
+				chosen = i
+				break
+			end
+		end
+		if left[chosen] < cost then
+			return {0, math.max(left[chosen], 0), chosen}
+		end
+		taken[chosen] = cost
+		remaining = left[chosen] - cost
+	else
+		local total = 0
+		for i = 1, n do
+			total = total + math.max(left[i], 0)
+		end
+		if total < cost then
+			return {0, total, 0}
+		end
+		local rest = cost
+		for i = 1, n do
+			taken[i] = math.min(math.max(left[i], 0), rest)
+			rest = rest - taken[i]
+		end
+		remaining = total - cost
+	end
+	local result = {1, remaining or 0}
+	for i = 1, n do
+		if taken[i] > 0 then
+			local used = redis.call('incrby', KEYS[i], taken[i])
+			local ttl = tonumber(ARGV[n + i + 2])
+			if ttl > 0 and used == taken[i] then
+				redis.call('expire', KEYS[i], ttl)
+			end
+			left[i] = left[i] - taken[i]
+		end
+		result[i + 2] = taken[i]
+		result[n + i + 2] = left[i]
+	end
+	return result
+`
+
+// MultiCounterDeduct draws the cost from several quota sources atomically, spreading it
+// over the counters as the mode says, or deducts nothing when they can't cover it. In
+// cluster mode all keys must hash to the same slot.
+func (c *RedisClusterClient[C]) MultiCounterDeduct(counters []QuotaCounter, cost int, mode DeductMode, callback RedisResponseCallback) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	keys, args, err := buildMultiCounterDeductArgs(counters, cost, mode)
+	if err != nil {
+		return err
+	}
+	return c.Eval(multiCounterDeductScript, len(keys), keys, args, callback)
+}
+
+func buildMultiCounterDeductArgs(counters []QuotaCounter, cost int, mode DeductMode) ([]interface{}, []interface{}, error) {
+	if len(counters) == 0 {
+		return nil, nil, errors.New("multi counter deduct needs at least one counter")
+	}
+	if cost < 0 {
+		return nil, nil, errors.New("multi counter deduct cost must not be negative")
+	}
+	if mode != DeductSpill && mode != DeductEach && mode != DeductFirstFunded {
+		return nil, nil, fmt.Errorf("unknown multi counter deduct mode %q", mode)
+	}
+	keys := make([]interface{}, 0, 2*len(counters))
+	limitKeys := make([]interface{}, 0, len(counters))
+	args := make([]interface{}, 0, 2*len(counters)+2)
+	args = append(args, cost, string(mode))
+	for _, counter := range counters {
+		if counter.UsedKey == "" {
+			return nil, nil, errors.New("multi counter deduct counters need a used key")
+		}
+		if counter.Optional && counter.LimitKey == "" {
+			return nil, nil, errors.New("optional multi counter deduct counters need a limit key")
+		}
+		keys = append(keys, counter.UsedKey)
+		switch {
+		case counter.Optional:
+			limitKeys = append(limitKeys, counter.LimitKey)
+			args = append(args, "?")
+		case counter.LimitKey != "":
+			limitKeys = append(limitKeys, counter.LimitKey)
+			args = append(args, "")
+		default:
+			args = append(args, counter.Limit)
+		}
+	}
+	for _, counter := range counters {
+		args = append(args, counter.TTLSeconds)
+	}
+	return append(keys, limitKeys...), args, nil
+}
+
+// MultiCounterResult is the outcome of MultiCounterDeduct
+type MultiCounterResult struct {
+	Allowed bool
+	// Remaining is the quota left after the deduction, or what was short of the cost
+	Remaining int
+	// Refused is the index of the counter that couldn't cover the cost, -1 when the
+	// combined remaining quota was short or the deduction was allowed
+	Refused int
+	// Taken and Left are per counter what was deducted and what remains, -1 for skipped
+	// optional counters. Both are only set when the deduction was allowed.
+	Taken []int
+	Left  []int
+}
+
+// ParseMultiCounterDeduct reads the response of MultiCounterDeduct with n counters
+func ParseMultiCounterDeduct(response resp.Value, n int) (MultiCounterResult, error) {
+	if IsRedisErrorResponse(response) {
+		return MultiCounterResult{}, GetRedisErrorFromResponse(response)
+	}
+	values := response.Array()
+	if len(values) < 3 {
+		return MultiCounterResult{}, fmt.Errorf("unexpected multi counter deduct response: %v", response)
+	}
+	result := MultiCounterResult{Allowed: values[0].Integer() == 1, Remaining: values[1].Integer(), Refused: -1}
+	if !result.Allowed {
+		result.Refused = values[2].Integer() - 1
+		return result, nil
+	}
+	if len(values) != 2*n+2 {
+		return MultiCounterResult{}, fmt.Errorf("unexpected multi counter deduct response for %d counters: %v", n, response)
+	}
+	for i := 0; i < n; i++ {
+		result.Taken = append(result.Taken, values[i+2].Integer())
+		result.Left = append(result.Left, values[n+i+2].Integer())
+	}
+	return result, nil
+}
+
+// decrByFloorScript decrements the key but never below the floor.
+// Returns {new value, 1 if the decrement was clamped}.
+const decrByFloorScript = `
+	local current = tonumber(redis.call('get', KEYS[1])) or 0
+	local floor = tonumber(ARGV[2])
+	local target = current - tonumber(ARGV[1])
+	if target < floor then
+		-- leave values already at or below the floor untouched
+		if current <= floor then
+			return {current, 1}
+		end
+		redis.call('set', KEYS[1], floor, 'keepttl')
+		return {floor, 1}
+	end
+	return {redis.call('decrby', KEYS[1], ARGV[1]), 0}
+`
+
+// DecrByFloor decrements key by delta, clamping the result at floor instead of going below it
+func (c *RedisClusterClient[C]) DecrByFloor(key string, delta, floor int, callback func(value int, clamped bool, err error)) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	if delta < 0 {
+		return errors.New("decr by floor delta must not be negative")
+	}
+	return c.Eval(decrByFloorScript, 1, []interface{}{key}, []interface{}{delta, floor}, func(response resp.Value) {
+		if IsRedisErrorResponse(response) {
+			callback(0, false, GetRedisErrorFromResponse(response))
+			return
+		}
+		result := response.Array()
+		if len(result) < 2 {
+			callback(0, false, fmt.Errorf("unexpected decr by floor response: %s", response.String()))
+			return
+		}
+		callback(result[0].Integer(), result[1].Integer() == 1, nil)
+	})
+}
+
+// seenRecentlyKeyPrefix is the key prefix of the time bucketed sets used by SeenRecently
+const seenRecentlyKeyPrefix = "seen_recently:"
+
+// seenRecentlyScript checks the previous bucket and adds the id to the current one,
+// using RedisBloom filters when ARGV[3] is 1 and plain sets otherwise
+const seenRecentlyScript = `
+	local seen = 0
+	if ARGV[3] == '1' then
+		seen = redis.call('BF.EXISTS', KEYS[2], ARGV[1])
+		if redis.call('BF.ADD', KEYS[1], ARGV[1]) == 0 then
+			seen = 1
+		end
+	else
+		seen = redis.call('SISMEMBER', KEYS[2], ARGV[1])
+		if redis.call('SADD', KEYS[1], ARGV[1]) == 0 then
+			seen = 1
+		end
+	end
+	redis.call('EXPIRE', KEYS[1], ARGV[2])
+	return seen
+`
+
+// seenRecentlyKeys returns the current and previous bucket keys for the window. The window
+// is used as hash tag so both keys live in the same slot in cluster mode.
+func seenRecentlyKeys(now time.Time, windowSeconds int) (string, string) {
+	bucket := now.Unix() / int64(windowSeconds)
+	return fmt.Sprintf("%s{%d}:%d", seenRecentlyKeyPrefix, windowSeconds, bucket),
+		fmt.Sprintf("%s{%d}:%d", seenRecentlyKeyPrefix, windowSeconds, bucket-1)
+}
+
+// SeenRecently is an approximate replay check. The id is added to a set keyed by the
+// current time bucket, and is reported as seen when it is already in the current or the
+// previous bucket, so an id is remembered for between one and two windows. RedisBloom
+// filters are used when the module is loaded, falling back to plain sets otherwise.
+func (c *RedisClusterClient[C]) SeenRecently(id string, windowSeconds int, callback func(seen bool, err error)) error {
+	if err := c.checkReadyFunc(); err != nil {
+		return err
+	}
+	if windowSeconds <= 0 {
+		return errors.New("seen recently window must be positive")
+	}
+	currentKey, previousKey := seenRecentlyKeys(time.Now(), windowSeconds)
+	keys := []interface{}{currentKey, previousKey}
+	useBloom := c.bloomSupport != bloomSupportUnavailable
+	bloomFlag := 0
+	if useBloom {
+		bloomFlag = 1
+	}
+	args := []interface{}{id, windowSeconds * 2, bloomFlag}
+	return c.Eval(seenRecentlyScript, 2, keys, args, func(response resp.Value) {
+		if IsRedisErrorResponse(response) {
+			if useBloom && c.bloomSupport == bloomSupportUnknown && strings.Contains(strings.ToLower(response.String()), "unknown") {
+				proxywasm.LogInfof("RedisBloom is not available, falling back to plain sets for seen recently checks")
+				c.bloomSupport = bloomSupportUnavailable
+				if err := c.SeenRecently(id, windowSeconds, callback); err != nil {
+					callback(false, err)
+				}
+				return
+			}
+			callback(false, GetRedisErrorFromResponse(response))
+			return
+		}
+		if useBloom {
+			c.bloomSupport = bloomSupportAvailable
+		}
+		callback(response.Integer() == 1, nil)
+	})
+}
+
 // classifyRedisError analyzes error and determines type and retry characteristics
 func classifyRedisError(status int, err error, operation string, key string) *RedisError {
 	redisErr := &RedisError{
@@ -1288,6 +2095,14 @@ type RedisMetrics struct {
 	SuccessfulCalls int64
 	FailedCalls     int64
 	RetryAttempts   int64
+	// InFlightCalls is the number of dispatched calls waiting for a response
+	InFlightCalls int64
+	// RejectedCalls counts calls rejected by the in-flight limit
+	RejectedCalls int64
+	// FailoverCalls counts calls moved to the next backend after a connection failure
+	FailoverCalls int64
+	// BackendCalls counts successful calls by the cluster name of the backend that served them
+	BackendCalls map[string]int64
 }
 
 // Global metrics instance
@@ -1295,12 +2110,87 @@ var globalRedisMetrics RedisMetrics
 
 // GetRedisMetrics returns current Redis operation metrics
 func GetRedisMetrics() RedisMetrics {
-	return globalRedisMetrics
+	metrics := globalRedisMetrics
+	metrics.BackendCalls = make(map[string]int64, len(globalRedisMetrics.BackendCalls))
+	for backend, calls := range globalRedisMetrics.BackendCalls {
+		metrics.BackendCalls[backend] = calls
+	}
+	return metrics
 }
 
-// ResetRedisMetrics resets the global Redis metrics
+// Backend health states reported by GetBackendStates
+const (
+	BackendStateHealthy = "healthy"
+	BackendStateFailing = "failing"
+)
+
+// BackendState describes the health of a Redis backend as seen by this plugin instance
+type BackendState struct {
+	Name string
+	// Primary is true for the backend configured as primary, false for failover backends
+	Primary bool
+	// State is failing while the most recent calls hit connection or network errors
+	State string
+	// ConsecutiveFailures counts connection or network errors since the last successful call
+	ConsecutiveFailures int64
+	// TotalFailures counts all connection or network errors
+	TotalFailures int64
+	// Active is true for the backend that served the most recent successful call
+	Active bool
+}
+
+var (
+	redisBackendStates = make(map[string]*BackendState)
+	// redisBackendOrder keeps backends in registration order for reporting
+	redisBackendOrder []string
+	// redisActiveBackend is the backend that served the most recent successful call
+	redisActiveBackend string
+)
+
+func registerBackendState(cluster Cluster, primary bool) {
+	name := cluster.ClusterName()
+	if _, exists := redisBackendStates[name]; exists {
+		return
+	}
+	redisBackendStates[name] = &BackendState{Name: name, Primary: primary, State: BackendStateHealthy}
+	redisBackendOrder = append(redisBackendOrder, name)
+}
+
+// recordBackendResult updates the backend health, only connection and network errors count as failures
+func recordBackendResult(cluster Cluster, redisErr *RedisError) {
+	state, exists := redisBackendStates[cluster.ClusterName()]
+	if !exists {
+		return
+	}
+	if redisErr == nil {
+		state.ConsecutiveFailures = 0
+		state.State = BackendStateHealthy
+		redisActiveBackend = state.Name
+		return
+	}
+	if redisErr.Type != RedisErrorTypeConnection && redisErr.Type != RedisErrorTypeNetwork {
+		return
+	}
+	state.ConsecutiveFailures++
+	state.TotalFailures++
+	state.State = BackendStateFailing
+}
+
+// GetBackendStates returns the health of every initialized Redis backend, in registration order
+func GetBackendStates() []BackendState {
+	states := make([]BackendState, 0, len(redisBackendOrder))
+	for _, name := range redisBackendOrder {
+		state := *redisBackendStates[name]
+		state.Active = name == redisActiveBackend
+		states = append(states, state)
+	}
+	return states
+}
+
+// ResetRedisMetrics resets the global Redis metrics, keeping the in-flight count
+// since those calls are still outstanding
 func ResetRedisMetrics() {
-	globalRedisMetrics = RedisMetrics{}
+	globalRedisMetrics = RedisMetrics{InFlightCalls: globalRedisMetrics.InFlightCalls}
 }
 
 // IsRetryableError checks if the given error is retryable
@@ -1316,10 +2206,51 @@ func IsRedisErrorResponse(val resp.Value) bool {
 	return val.Type() == resp.Error
 }
 
-// GetRedisErrorFromResponse extracts error from a resp.Value
+// GetRedisErrorFromResponse returns the error carried by an error reply as a *RedisError,
+// so IsRetryableError works the same for dispatch errors and reply errors
 func GetRedisErrorFromResponse(val resp.Value) error {
 	if val.Type() == resp.Error {
-		return fmt.Errorf("Redis error: %s", val.String())
+		return parseRedisReplyError(val.String())
 	}
 	return nil
 }
+
+// transientReplyErrors are server error prefixes for conditions that clear up on their own
+var transientReplyErrors = []string{"LOADING", "BUSY", "TRYAGAIN", "CLUSTERDOWN", "MASTERDOWN", "NOREPLICAS"}
+
+func parseRedisReplyError(message string) *RedisError {
+	// Errors raised by this wrapper are passed to callbacks as error replies, keep their classification
+	for errType := RedisErrorTypeConnection; errType <= RedisErrorTypeBackpressure; errType++ {
+		redisErr := &RedisError{Type: errType}
+		prefix := "Redis " + redisErr.TypeString() + " error in "
+		if !strings.HasPrefix(message, prefix) {
+			continue
+		}
+		operation, rest, _ := strings.Cut(strings.TrimPrefix(message, prefix), " (key: ")
+		key, detail, found := strings.Cut(rest, "): ")
+		if !found {
+			break
+		}
+		redisErr.Operation = operation
+		redisErr.Key = key
+		redisErr.Message = detail
+		redisErr.Retryable = errType != RedisErrorTypeAuth && errType != RedisErrorTypeProtocol && errType != RedisErrorTypeCommand
+		redisErr.Temporary = redisErr.Retryable
+		return redisErr
+	}
+
+	// Otherwise it is the server rejecting the command, e.g. WRONGTYPE
+	redisErr := &RedisError{
+		Type:    RedisErrorTypeCommand,
+		Message: message,
+	}
+	errCode, _, _ := strings.Cut(message, " ")
+	for _, transient := range transientReplyErrors {
+		if errCode == transient {
+			redisErr.Retryable = true
+			redisErr.Temporary = true
+			break
+		}
+	}
+	return redisErr
+}
@@ -0,0 +1,354 @@
+// Copyright (c) 2022 Alibaba Group Holding Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wrapper
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/resp"
+)
+
+func TestBuildBitFieldArgs(t *testing.T) {
+	cases := []struct {
+		name      string
+		ops       []BitFieldOp
+		expect    string
+		expectErr bool
+	}{
+		{
+			name: "incrby then get",
+			ops: []BitFieldOp{
+				{Op: "INCRBY", Type: "u16", Offset: 2, Scaled: true, Value: 5},
+				{Op: "GET", Type: "u16", Offset: 2, Scaled: true},
+			},
+			expect: "*9\r\n$8\r\nbitfield\r\n$3\r\nkey\r\n$6\r\nincrby\r\n$3\r\nu16\r\n$2\r\n#2\r\n$1\r\n5\r\n" +
+				"$3\r\nget\r\n$3\r\nu16\r\n$2\r\n#2\r\n",
+		},
+		{
+			name: "set with raw bit offset",
+			ops: []BitFieldOp{
+				{Op: "set", Type: "i8", Offset: 100, Value: -3},
+			},
+			expect: "*6\r\n$8\r\nbitfield\r\n$3\r\nkey\r\n$3\r\nset\r\n$2\r\ni8\r\n$3\r\n100\r\n$2\r\n-3\r\n",
+		},
+		{
+			name:      "no operations",
+			expectErr: true,
+		},
+		{
+			name:      "unsupported op",
+			ops:       []BitFieldOp{{Op: "overflow", Type: "u8"}},
+			expectErr: true,
+		},
+		{
+			name:      "missing type",
+			ops:       []BitFieldOp{{Op: "get"}},
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			args, err := buildBitFieldArgs("key", c.ops)
+			if c.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expect, string(respString(args)))
+		})
+	}
+}
+
+func TestBuildSortArgs(t *testing.T) {
+	cases := []struct {
+		name      string
+		by        string
+		get       string
+		limit     [2]int
+		alpha     bool
+		desc      bool
+		expect    []interface{}
+		expectErr bool
+	}{
+		{
+			name:   "plain",
+			expect: []interface{}{"sort", "key"},
+		},
+		{
+			name:   "alpha desc",
+			alpha:  true,
+			desc:   true,
+			expect: []interface{}{"sort", "key", "desc", "alpha"},
+		},
+		{
+			name:   "all clauses",
+			by:     "weight_*",
+			get:    "object_*",
+			limit:  [2]int{10, 5},
+			desc:   true,
+			expect: []interface{}{"sort", "key", "by", "weight_*", "limit", 10, 5, "get", "object_*", "desc"},
+		},
+		{
+			name:   "nosort with limit",
+			by:     "nosort",
+			get:    "#",
+			limit:  [2]int{0, 20},
+			expect: []interface{}{"sort", "key", "by", "nosort", "limit", 0, 20, "get", "#"},
+		},
+		{
+			name:   "negative count returns all from offset",
+			limit:  [2]int{3, -1},
+			expect: []interface{}{"sort", "key", "limit", 3, -1},
+		},
+		{
+			name:      "by pattern without wildcard",
+			by:        "weight",
+			expectErr: true,
+		},
+		{
+			name:      "nosort with alpha",
+			by:        "NOSORT",
+			alpha:     true,
+			expectErr: true,
+		},
+		{
+			name:      "get pattern without wildcard",
+			get:       "object",
+			expectErr: true,
+		},
+		{
+			name:      "negative offset",
+			limit:     [2]int{-1, 10},
+			expectErr: true,
+		},
+		{
+			name:      "offset without count",
+			limit:     [2]int{5, 0},
+			expectErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			args, err := buildSortArgs("key", c.by, c.get, c.limit, c.alpha, c.desc)
+			if c.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, c.expect, args)
+		})
+	}
+}
+
+func TestSeenRecentlyKeys(t *testing.T) {
+	current, previous := seenRecentlyKeys(time.Unix(1000, 0), 60)
+	assert.Equal(t, "seen_recently:{60}:16", current)
+	assert.Equal(t, "seen_recently:{60}:15", previous)
+
+	// the bucket rotates exactly at the window boundary
+	current, previous = seenRecentlyKeys(time.Unix(1020, 0), 60)
+	assert.Equal(t, "seen_recently:{60}:17", current)
+	assert.Equal(t, "seen_recently:{60}:16", previous)
+}
+
+func TestBuildZRangeStoreArgs(t *testing.T) {
+	cases := []struct {
+		name    string
+		start   int
+		stop    int
+		byScore bool
+		rev     bool
+		expect  []interface{}
+	}{
+		{
+			name:   "by rank",
+			start:  0,
+			stop:   9,
+			expect: []interface{}{"zrangestore", "dst", "src", 0, 9},
+		},
+		{
+			name:   "by rank reversed",
+			start:  0,
+			stop:   -1,
+			rev:    true,
+			expect: []interface{}{"zrangestore", "dst", "src", 0, -1, "rev"},
+		},
+		{
+			name:    "by score",
+			start:   100,
+			stop:    200,
+			byScore: true,
+			expect:  []interface{}{"zrangestore", "dst", "src", 100, 200, "byscore"},
+		},
+		{
+			name:    "by score reversed",
+			start:   200,
+			stop:    100,
+			byScore: true,
+			rev:     true,
+			expect:  []interface{}{"zrangestore", "dst", "src", 200, 100, "byscore", "rev"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.expect, buildZRangeStoreArgs("dst", "src", c.start, c.stop, c.byScore, c.rev))
+		})
+	}
+}
+
+func TestGetRedisErrorFromResponse(t *testing.T) {
+	cases := []struct {
+		name      string
+		reply     string
+		expect    RedisErrorType
+		retryable bool
+		message   string
+	}{
+		{
+			name:    "wrong type reply",
+			reply:   "WRONGTYPE Operation against a key holding the wrong kind of value",
+			expect:  RedisErrorTypeCommand,
+			message: "WRONGTYPE Operation against a key holding the wrong kind of value",
+		},
+		{
+			name:      "loading reply is transient",
+			reply:     "LOADING Redis is loading the dataset in memory",
+			expect:    RedisErrorTypeCommand,
+			retryable: true,
+			message:   "LOADING Redis is loading the dataset in memory",
+		},
+		{
+			name:      "wrapper connection error keeps its type",
+			reply:     (&RedisError{Type: RedisErrorTypeConnection, Operation: "GET", Key: "k", Message: "Connection refused"}).Error(),
+			expect:    RedisErrorTypeConnection,
+			retryable: true,
+			message:   "Connection refused",
+		},
+		{
+			name:    "wrapper auth error is not retryable",
+			reply:   (&RedisError{Type: RedisErrorTypeAuth, Operation: "GET", Key: "k", Message: "Authentication failed"}).Error(),
+			expect:  RedisErrorTypeAuth,
+			message: "Authentication failed",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := GetRedisErrorFromResponse(resp.ErrorValue(errors.New(c.reply)))
+			redisErr, ok := err.(*RedisError)
+			assert.True(t, ok)
+			assert.Equal(t, c.expect, redisErr.Type)
+			assert.Equal(t, c.message, redisErr.Message)
+			assert.Equal(t, c.retryable, IsRetryableError(err))
+		})
+	}
+
+	assert.Nil(t, GetRedisErrorFromResponse(resp.StringValue("OK")))
+}
+
+func TestBuildLCSArgs(t *testing.T) {
+	assert.Equal(t, []interface{}{"lcs", "key1", "key2"}, buildLCSArgs("key1", "key2", false))
+	assert.Equal(t, []interface{}{"lcs", "key1", "key2", "len"}, buildLCSArgs("key1", "key2", true))
+	assert.Equal(t, "*4\r\n$3\r\nlcs\r\n$4\r\nkey1\r\n$4\r\nkey2\r\n$3\r\nlen\r\n", string(respString(buildLCSArgs("key1", "key2", true))))
+}
+
+func TestShouldResetAfter(t *testing.T) {
+	primary := FQDNCluster{FQDN: "redis-reset.dns", Port: 6379}
+	protocolErr := &RedisError{Type: RedisErrorTypeProtocol, Operation: "GET"}
+
+	// disabled unless the primary opted in
+	assert.False(t, shouldResetAfter(protocolErr, primary))
+
+	redisResetOnProtocolError[primary.ClusterName()] = true
+	defer delete(redisResetOnProtocolError, primary.ClusterName())
+	assert.True(t, shouldResetAfter(protocolErr, primary))
+	assert.False(t, shouldResetAfter(&RedisError{Type: RedisErrorTypeTimeout, Operation: "GET"}, primary))
+	// a failed RESET is not followed by another one
+	assert.False(t, shouldResetAfter(&RedisError{Type: RedisErrorTypeProtocol, Operation: "RESET"}, primary))
+}
+
+func TestBuildZAddIncrArgs(t *testing.T) {
+	assert.Equal(t, []interface{}{"zadd", "board", "incr", 5, "alice"},
+		buildZAddIncrArgs("board", nil, "alice", 5))
+	assert.Equal(t, []interface{}{"zadd", "board", "xx", "gt", "incr", 1.5, "alice"},
+		buildZAddIncrArgs("board", []string{"XX", "GT"}, "alice", 1.5))
+}
+
+func TestZAddIncrScore(t *testing.T) {
+	score, ok := ZAddIncrScore(resp.StringValue("7.5"))
+	assert.True(t, ok)
+	assert.Equal(t, 7.5, score)
+
+	// NX on an existing member, or XX on a missing one, replies nil
+	score, ok = ZAddIncrScore(resp.NullValue())
+	assert.False(t, ok)
+	assert.Equal(t, 0.0, score)
+}
+
+func TestBuildMultiCounterDeductArgs(t *testing.T) {
+	keys, args, err := buildMultiCounterDeductArgs([]QuotaCounter{
+		{UsedKey: "free_used", Limit: 20, TTLSeconds: 86400},
+		{UsedKey: "team_used", LimitKey: "team_total"},
+		{UsedKey: "user_used", LimitKey: "user_total", Optional: true},
+	}, 5, DeductEach)
+	assert.NoError(t, err)
+	// limit keys follow the used keys, in the order of the counters reading them
+	assert.Equal(t, []interface{}{"free_used", "team_used", "user_used", "team_total", "user_total"}, keys)
+	assert.Equal(t, []interface{}{5, "each", 20, "", "?", 86400, 0, 0}, args)
+
+	_, _, err = buildMultiCounterDeductArgs(nil, 5, DeductSpill)
+	assert.Error(t, err)
+	_, _, err = buildMultiCounterDeductArgs([]QuotaCounter{{UsedKey: "used"}}, -1, DeductSpill)
+	assert.Error(t, err)
+	_, _, err = buildMultiCounterDeductArgs([]QuotaCounter{{LimitKey: "total"}}, 1, DeductSpill)
+	assert.Error(t, err)
+	_, _, err = buildMultiCounterDeductArgs([]QuotaCounter{{UsedKey: "used", Limit: 5, Optional: true}}, 1, DeductSpill)
+	assert.Error(t, err)
+	_, _, err = buildMultiCounterDeductArgs([]QuotaCounter{{UsedKey: "used", Limit: 5}}, 1, "random")
+	assert.Error(t, err)
+}
+
+func TestParseMultiCounterDeduct(t *testing.T) {
+	allowed := resp.ArrayValue([]resp.Value{
+		resp.IntegerValue(1), resp.IntegerValue(3),
+		resp.IntegerValue(2), resp.IntegerValue(0),
+		resp.IntegerValue(3), resp.IntegerValue(-1),
+	})
+	result, err := ParseMultiCounterDeduct(allowed, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, MultiCounterResult{Allowed: true, Remaining: 3, Refused: -1, Taken: []int{2, 0}, Left: []int{3, -1}}, result)
+
+	refused := resp.ArrayValue([]resp.Value{resp.IntegerValue(0), resp.IntegerValue(1), resp.IntegerValue(2)})
+	result, err = ParseMultiCounterDeduct(refused, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, MultiCounterResult{Remaining: 1, Refused: 1}, result)
+
+	// a combined shortfall names no counter
+	short := resp.ArrayValue([]resp.Value{resp.IntegerValue(0), resp.IntegerValue(4), resp.IntegerValue(0)})
+	result, err = ParseMultiCounterDeduct(short, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, result.Refused)
+
+	_, err = ParseMultiCounterDeduct(resp.ArrayValue([]resp.Value{resp.IntegerValue(1), resp.IntegerValue(3), resp.IntegerValue(2)}), 2)
+	assert.Error(t, err)
+	_, err = ParseMultiCounterDeduct(resp.ErrorValue(errors.New("NOSCRIPT")), 2)
+	assert.Error(t, err)
+}